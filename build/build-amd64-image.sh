@@ -18,10 +18,10 @@ cd "${PROJECT_ROOT}"/
 
 rm -rf build/amd/csiplugin-connector.go build/amd/csiplugin-connector-svc build/amd/csiplugin-connector
 
-cp build/lib/csiplugin-connector.go build/amd/csiplugin-connector.go
 cp build/lib/csiplugin-connector.service build/amd/csiplugin-connector.service
 cp build/lib/amd64-nsenter build/amd/nsenter
 cp build/lib/freezefs.sh build/amd/freezefs.sh
+cp build/lib/gen-fuse-manifest.sh build/amd/gen-fuse-manifest.sh
 cp build/lib/amd64-entrypoint.sh build/amd/amd64-entrypoint.sh
 
 export GOARCH="amd64"
@@ -33,9 +33,10 @@ GIT_BRANCH=`git symbolic-ref --short -q HEAD`
 BUILD_TIME=`date +%FT%T%z`
 
 CGO_ENABLED=0 go build -ldflags "-X main.VERSION=${VERSION} -X main.BRANCH=${GIT_BRANCH} -X main.REVISION=${GIT_HASH} -X main.BUILDTIME=${BUILD_TIME}" -o plugin.csi.alibabacloud.com
+# the connector shares the fuse launcher and allow-list with the plugin
+CGO_ENABLED=0 go build -ldflags "-X main.VERSION=${VERSION}-${GIT_HASH}" -o build/amd/csiplugin-connector ./build/lib
 
 cd "${PROJECT_ROOT}"/build/amd/
-CGO_ENABLED=0 go build csiplugin-connector.go
 
 if [ "$1" == "" ]; then
   mv "${PROJECT_ROOT}"/plugin.csi.alibabacloud.com ./
@@ -21,8 +21,9 @@ rm -rf /var/lib/kubelet/plugins/ossplugin.csi.alibabacloud.com/csi.sock
 
 
 ## OSS plugin setup
-if [ ! `${HOST_CMD}  which ossfs` ]; then
-    echo "ossfs don't exist on host machine, please install first"
+## in managed install mode the plugin copies the ossfs shipped in this image to the host
+if [ "${FUSE_INSTALL_MODE}" != "managed" ] && [ ! `${HOST_CMD}  which ossfs` ]; then
+    echo "ossfs don't exist on host machine, please install first or set FUSE_INSTALL_MODE=managed"
     exit 1
 fi
 
@@ -38,20 +39,58 @@ else
     newmd5=`md5sum /csi/csiplugin-connector | awk '{print $1}'`
     if [ "$oldmd5" = "$newmd5" ]; then
         updateConnector="false"
-    else
-        rm -rf /host/etc/csi-tool/
-        rm -rf /host/etc/csi-tool/connector.sock
-        rm -rf /var/log/alicloud/connector.pid
-        mkdir -p /host/etc/csi-tool/
     fi
 fi
 cp /freezefs.sh /host/etc/csi-tool/freezefs.sh
 if [ "$updateConnector" = "true" ]; then
+    # the sockets of the running connector stay in place, the new connector takes them over;
+    # the binary is renamed over the running one as it cannot be written while it runs
     echo "Install csiplugin-connector...."
-    cp /csi/csiplugin-connector /host/etc/csi-tool/csiplugin-connector
-    chmod 755 /host/etc/csi-tool/csiplugin-connector
+    cp /csi/csiplugin-connector /host/etc/csi-tool/csiplugin-connector.new
+    chmod 755 /host/etc/csi-tool/csiplugin-connector.new
+    mv -f /host/etc/csi-tool/csiplugin-connector.new /host/etc/csi-tool/csiplugin-connector
 fi
 
+# callers allowed on the connector sockets, its status endpoint and drain timeout, the connector reads them from
+# its config file at start and again on SIGHUP (systemctl reload csiplugin-connector)
+connectorConfig=/host/etc/csi-tool/connector.env
+: > ${connectorConfig}.tmp
+chmod 600 ${connectorConfig}.tmp
+for name in CONNECTOR_ALLOWED_UIDS CONNECTOR_ALLOWED_GIDS CONNECTOR_ALLOWED_CGROUPS CONNECTOR_STATUS_ADDRESS CONNECTOR_DRAIN_TIMEOUT; do
+    if [[ ! -z "${!name}" ]]; then
+        echo "${name}=${!name}" >> ${connectorConfig}.tmp
+    fi
+done
+mv -f ${connectorConfig}.tmp ${connectorConfig}
+
+# hosts without systemd (minimal and container-optimized ones) cannot run the connector service,
+# the connector is started directly in the host mount namespace and leaves the container cgroup itself
+if ! ${HOST_CMD} test -d /run/systemd/system; then
+    echo "systemd is not running on host, starting csiplugin-connector directly."
+    connectorPid=`${HOST_CMD} cat /var/log/alicloud/connector.pid 2>/dev/null`
+    if [ -n "$connectorPid" ] && [ "$updateConnector" = "true" ] && kill -0 "$connectorPid" 2>/dev/null; then
+        # the new connector takes over the sockets of the running one, which drains its requests and exits
+        if ${HOST_CMD} /etc/csi-tool/csiplugin-connector; then
+            /bin/plugin.csi.alibabacloud.com $@
+            exit $?
+        fi
+        # a connector without the control socket drains the requests in flight, exits and releases its pid file
+        kill -s QUIT "$connectorPid" 2>/dev/null
+        for((i=1;i<=40;i++)); do
+            kill -0 "$connectorPid" 2>/dev/null || break
+            sleep 1
+        done
+    elif [ -n "$connectorPid" ]; then
+        kill -s HUP "$connectorPid" 2>/dev/null
+    fi
+    if [ -z "$connectorPid" ] || [ "$updateConnector" = "true" ] || ! kill -0 "$connectorPid" 2>/dev/null; then
+        ${HOST_CMD} mkdir -p /var/log/alicloud
+        ${HOST_CMD} rm -f /var/log/alicloud/connector.pid
+        ${HOST_CMD} /etc/csi-tool/csiplugin-connector
+    fi
+    /bin/plugin.csi.alibabacloud.com $@
+    exit $?
+fi
 
 # install/update csiplugin connector service
 updateConnectorService="true"
@@ -88,7 +127,6 @@ if [ "$updateConnectorService" = "true" ]; then
     done
 fi
 
-rm -rf /var/log/alicloud/connector.pid
 echo "Starting systemctl enable csiplugin-connector.service."
 for((i=1;i<=10;i++));
 do
@@ -101,6 +139,17 @@ do
     fi
 done
 
+# with the same service the installed connector is started by reload, it takes over the sockets of
+# the running one and reads its config file, the connector is restarted if that fails
+if [ "$updateConnectorService" = "false" ] && ${HOST_CMD} systemctl is-active -q csiplugin-connector.service; then
+    echo "Starting systemctl reload csiplugin-connector.service."
+    if ${HOST_CMD} systemctl reload csiplugin-connector.service; then
+        /bin/plugin.csi.alibabacloud.com $@
+        exit $?
+    fi
+fi
+
+rm -rf /var/log/alicloud/connector.pid
 echo "Starting systemctl restart csiplugin-connector.service."
 for((i=1;i<=10;i++));
 do
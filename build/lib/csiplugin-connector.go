@@ -1,18 +1,22 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/sevlyar/go-daemon"
+	"wujunyi792/oss-csi-lite-plugin/pkg/audit"
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+	csilog "wujunyi792/oss-csi-lite-plugin/pkg/log"
 )
 
 const (
@@ -20,6 +24,8 @@ const (
 	LogFilename = "/var/log/alicloud/csi_connector.log"
 	// PIDFilename name of pid file
 	PIDFilename = "/var/log/alicloud/connector.pid"
+	// readyTimeout bounds the start of the connector, a handoff waits for the running connector
+	readyTimeout = time.Minute
 	// WorkPath workspace
 	WorkPath = "./"
 	// OSSSocketPath socket path
@@ -32,11 +38,17 @@ const (
 	GetPathDevice = "df --output=source %s"
 )
 
+// commandExecutor runs the commands of the connector, each is killed with its process group at its deadline
+var commandExecutor = executor.New()
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == fuse.JoinCgroupArg {
+		fuse.JoinCgroupAndExec(os.Args[2:])
+		return
+	}
 	// log file is: /var/log/alicloud/csi_connector.log
+	// the pid file is written by the connector once it serves, a running connector holds it until the handoff
 	cntxt := &daemon.Context{
-		PidFileName: PIDFilename,
-		PidFilePerm: 0644,
 		LogFileName: LogFilename,
 		LogFilePerm: 0640,
 		WorkDir:     WorkPath,
@@ -49,92 +61,101 @@ func main() {
 		log.Fatalf("Unable to run connector: %s", err.Error())
 	}
 	if d != nil {
+		if err := waitReady(d); err != nil {
+			log.Fatalf("Connector is not started: %v", err)
+		}
 		return
 	}
-	defer cntxt.Release()
+	defer releasePID()
+	// commands and replies are logged, keep secrets out of the log file
+	log.SetOutput(csilog.RedactWriter(os.Stderr))
 	log.Print("OSS Connector Daemon Is Starting...")
+	if !fuse.HasSystemd("/") {
+		// without systemd the connector is started from the plugin container, leave its cgroup
+		// so the connector and the fuse processes survive the restart of the plugin
+		joinConnectorCgroup()
+	}
+	srv, err := newServer()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	connectorServer = srv
+	auditLog = openAuditLog()
+	if auditLog != nil {
+		go serveAudit(srv, AuditSocketPath)
+	}
+	statusAddress, err := connector.StatusAddress()
+	if err != nil {
+		log.Fatalf("Invalid status endpoint: %v", err)
+	}
+	if statusAddress != "" {
+		go serveStatus(statusAddress)
+	}
+	processes.path = ProcessesFilename
+	go superviseProcesses()
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		EnsureSocketPath(OSSSocketPath)
-		log.Printf("Socket path is ready: %s", OSSSocketPath)
-		ln, err := net.Listen("unix", OSSSocketPath)
-		if err != nil {
-			log.Fatalf("Server Listen error: %s", err.Error())
-		}
-		log.Print("Daemon Started ...")
-		defer ln.Close()
-
-		go watchDogCheck()
-		// Handler to process the command
-		for {
-			fd, err := ln.Accept()
-			if err != nil {
-				log.Printf("Server Accept error: %s", err.Error())
-				continue
-			}
-			go echoServer(fd)
+	// a running connector keeps serving until this one listens on its sockets
+	t, err := takeOver(srv.controlPath)
+	if err != nil {
+		log.Fatalf("Take over the sockets of the running connector is failed, it keeps serving, err: %v", err)
+	}
+	if t == nil {
+		if err := lockPIDFile(); err != nil {
+			log.Fatalf("Lock pid file %s is failed, another connector may be running, err: %v", PIDFilename, err)
 		}
-	}()
-	go func() {
-		defer wg.Done()
-		EnsureSocketPath(DiskSocketPath)
-		log.Printf("Socket path is ready: %s", OSSSocketPath)
-		ln, err := net.Listen("unix", DiskSocketPath)
-		if err != nil {
-			log.Fatalf("runDiskProxy: server Listen error: %v", err.Error())
+		// the processes of a connector which exited without a handoff are supervised again
+		processes.load()
+	} else {
+		srv.inherit(t.listeners, t.handoff)
+	}
+	if err := srv.listen(OSSSocketPath, echoServer); err != nil {
+		log.Fatalf("Server Listen error: %s", err.Error())
+	}
+	log.Print("Daemon Started ...")
+	if err := srv.listen(DiskSocketPath, freezeFilesystemServer); err != nil {
+		log.Fatalf("runDiskProxy: server Listen error: %v", err.Error())
+	}
+	if t != nil {
+		if err := t.confirm(); err != nil {
+			log.Fatalf("Confirm the handoff is failed, the running connector keeps serving, err: %v", err)
 		}
-		log.Print("Disk proxy daemon started ....")
-		defer ln.Close()
-		go watchDogCheck()
-
-		// Handler to process the command
-		for {
-			fd, err := ln.Accept()
-			if err != nil {
-				log.Printf("Disk Server Accept error: %s", err.Error())
-				continue
-			}
-			go freezeFilesystemServer(fd)
+		if err := lockPIDFile(); err != nil {
+			log.Printf("Lock pid file %s is failed, err: %v", PIDFilename, err)
 		}
-	}()
-	wg.Wait()
-}
-
-func watchDogCheck() {
-	// watchdog of UNIX Domain Socket
-	var socketsPath []string
-	if os.Getenv("WATCHDOG_SOCKETS_PATH") != "" {
-		socketsPath = strings.Split(os.Getenv("WATCHDOG_SOCKETS_PATH"), ",")
+		log.Printf("Took over sockets %v of connector %s (pid %d)", t.handoff.Sockets, t.handoff.FromVersion, t.handoff.FromPID)
+		go srv.receiveProcesses(t)
 	}
-	socketNotAliveCount := make(map[string]int)
-	if len(socketsPath) == 0 {
-		return
+	log.Print("Disk proxy daemon started ....")
+	if err := srv.serveControl(); err != nil {
+		log.Printf("Control socket is not available, the connector cannot be upgraded without downtime, err: %v", err)
 	}
+	srv.run()
+}
+
+// waitReady wait until the started connector writes the pid file, so a failed start or handoff
+// is reported by the exit code of the command
+func waitReady(child *os.Process) error {
+	exited := make(chan struct{})
+	go func() {
+		child.Wait()
+		close(exited)
+	}()
+	deadline := time.After(readyTimeout)
 	for {
-		deadSockets := 0
-		for _, path := range socketsPath {
-			if err := isUnixDomainSocketLive(path); err != nil {
-				log.Printf("socket %s is not alive: %v", path, err)
-				socketNotAliveCount[path]++
-			} else {
-				socketNotAliveCount[path] = 0
-			}
-			if socketNotAliveCount[path] >= 6 {
-				deadSockets++
-			}
+		if pid, err := daemon.ReadPidFile(PIDFilename); err == nil && pid == child.Pid {
+			return nil
 		}
-		if deadSockets >= len(socketsPath) {
-			log.Printf("watchdog find too many dead sockets, csiplugin-connector will exit(0)")
-			os.Exit(0)
+		select {
+		case <-exited:
+			return fmt.Errorf("connector (pid %d) exited, see %s", child.Pid, LogFilename)
+		case <-deadline:
+			return fmt.Errorf("connector (pid %d) did not write %s in %s", child.Pid, PIDFilename, readyTimeout)
+		case <-time.After(100 * time.Millisecond):
 		}
-		time.Sleep(time.Second * 10)
 	}
 }
 
-func freezeFilesystemServer(c net.Conn) {
+func freezeFilesystemServer(c net.Conn, peer *connector.Peer) {
 	buf := make([]byte, 2048)
 	nr, err := c.Read(buf)
 	if err != nil {
@@ -154,7 +175,18 @@ func freezeFilesystemServer(c net.Conn) {
 	}
 	log.Printf("freezeFilesystemServer:: command: %v", command)
 	// run command
-	if out, err := run(command); err != nil {
+	start := time.Now()
+	out, err := run(command)
+	record := &audit.Record{Source: audit.SourceConnector, Action: audit.ActionDisk, Caller: auditCaller(peer)}
+	record.SetArgv("sh", "-c", command)
+	record.SetOutput(out, "")
+	if err != nil {
+		record.ExitCode = -1
+		record.Error = "freeze command failed"
+	}
+	record.Finish(start, record.ExitCode, nil)
+	auditLog.Log(record)
+	if err != nil {
 		reply := "Fail: " + command + ", error: " + err.Error()
 		_, err = c.Write([]byte(reply))
 		log.Print("diskServer Fail to run cmd:", reply)
@@ -208,60 +240,238 @@ func isIsolateDevice(globalPath string) bool {
 	return !strings.EqualFold(pathOut, dirOut)
 }
 
-func echoServer(c net.Conn) {
-	buf := make([]byte, 2048)
-	nr, err := c.Read(buf)
-	if err != nil {
-		log.Print("Server Read error: ", err.Error())
-		return
+func echoServer(c net.Conn, peer *connector.Peer) {
+	if err := connector.ServeConn(c, mountHandler{caller: auditCaller(peer)}); err != nil {
+		log.Printf("Serve connection is failed, err: %v", err)
 	}
+}
 
-	cmd := string(buf[0:nr])
-	log.Printf("Server receive mount cmd: %s", cmd)
+// mountHandler run the fuse commands of the plugin with the framed and the legacy protocol,
+// and audit them with the caller of the connection
+type mountHandler struct {
+	caller *audit.Caller
+}
 
-	if strings.Contains(cmd, "/usr/local/bin/ossfs") {
-		err = checkOssfsCmd(cmd)
-	} else if strings.Contains(cmd, "mount -t alinas") {
-		err = checkRichNasClientCmd(cmd)
-	} else if strings.Contains(cmd, "/etc/jindofs-tool/jindo-fuse") {
-		err = checkJindofsCmd(cmd)
+func (h mountHandler) HandleLegacy(cmd string) string {
+	start := time.Now()
+	reply := handleLegacy(cmd)
+	failed := !strings.HasPrefix(reply, "Success")
+	stats.Observe(connector.RequestLegacy, time.Since(start), failed)
+	record := &audit.Record{Source: audit.SourceConnector, Action: audit.ActionLegacy, Caller: h.caller}
+	record.SetArgv("sh", "-c", cmd)
+	record.SetOutput(reply, "")
+	var err error
+	if failed {
+		// the reply holds the output, which is only recorded by its digest
+		err = errors.New("legacy command failed")
+		record.ExitCode = -1
 	}
+	record.Finish(start, record.ExitCode, err)
+	auditLog.Log(record)
+	return reply
+}
 
-	if err != nil {
+func handleLegacy(cmd string) string {
+	log.Printf("Server receive mount cmd: %s", cmd)
+	if err := checkMountCmd(cmd); err != nil {
 		out := "Fail: " + err.Error()
 		log.Printf("Check user space mount is failed, err: %s", out)
-		if _, err := c.Write([]byte(out)); err != nil {
-			log.Printf("Check user space mount write is failed, err: %s", err.Error())
-		}
-		return
+		return out
 	}
-	// run command
-	if out, err := run(cmd); err != nil {
-		reply := "Fail: " + cmd + ", error: " + err.Error()
-		_, err = c.Write([]byte(reply))
+	runFunc := run
+	if strings.HasPrefix(cmd, fuse.LaunchCommand+" ") {
+		runFunc = runLaunch
+	}
+	out, err := runFunc(cmd)
+	if err != nil {
+		reply := csilog.Redact("Fail: " + cmd + ", error: " + err.Error())
 		log.Print("Server Fail to run cmd:", reply)
+		return reply
+	}
+	out = "Success:" + out
+	log.Printf("Success: %s", out)
+	return out
+}
+
+func (h mountHandler) Handle(req *connector.Request) *connector.Response {
+	start := time.Now()
+	resp := handle(req)
+	stats.Observe(requestType(req), time.Since(start), resp.Code != connector.CodeOK)
+	if !req.Status {
+		auditLog.Log(auditRecord(h.caller, req, resp, start))
+	}
+	return resp
+}
+
+// auditRecord return the audit record of a request of the framed protocol
+func auditRecord(caller *audit.Caller, req *connector.Request, resp *connector.Response, start time.Time) *audit.Record {
+	record := &audit.Record{Source: audit.SourceConnector, Action: audit.ActionCommand, Caller: caller, Code: string(resp.Code)}
+	if m := req.Mount; m != nil {
+		record.Action, record.VolumeID, record.Pod = audit.ActionMount, m.VolumeID, m.Pod
+		record.SetArgv(append(m.Launch().PrefixArgs(), m.Argv()...)...)
 	} else {
-		out = "Success:" + out
-		_, err = c.Write([]byte(out))
-		log.Printf("Success: %s", out)
+		record.SetArgv("sh", "-c", req.Command)
+	}
+	record.SetOutput(resp.Stdout, resp.Stderr)
+	record.Finish(start, resp.ExitCode, resp.Err())
+	return record
+}
+
+func handle(req *connector.Request) *connector.Response {
+	if req.Status {
+		return &connector.Response{Code: connector.CodeOK, Status: currentStatus()}
+	}
+	if req.Mount != nil {
+		return handleMount(req)
+	}
+	log.Printf("Server receive request %s: %s", req.ID, req.Command)
+	if err := checkMountCmd(req.Command); err != nil {
+		log.Printf("Request %s is rejected, err: %v", req.ID, err)
+		return &connector.Response{Code: connector.CodeRejected, ExitCode: -1, Message: err.Error()}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout())
+	defer cancel()
+	resp := execute(ctx, req.Command)
+	log.Printf("Request %s: %s, exit code: %d, stdout: %s, stderr: %s", req.ID, resp.Code, resp.ExitCode, resp.Stdout, resp.Stderr)
+	return resp
+}
+
+// handleMount run a typed mount request, its command is built by the connector and run without a shell
+func handleMount(req *connector.Request) *connector.Response {
+	m := req.Mount
+	log.Printf("Server receive mount request %s: %s", req.ID, m.CommandLine())
+	err := m.Validate(loadRegistry())
+	if err == nil && !IsFileExisting(m.Target) {
+		err = errors.New("mount target not exist " + m.Target)
+	}
+	if err != nil {
+		log.Printf("Request %s is rejected, err: %v", req.ID, err)
+		return &connector.Response{Code: connector.CodeRejected, ExitCode: -1, Message: err.Error()}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout())
+	defer cancel()
+	c, cleanup, err := prepareMount(m)
+	if err != nil {
+		return &connector.Response{Code: connector.CodeExecFailed, ExitCode: -1, Message: err.Error()}
+	}
+	resp := waitCommand(ctx, c, cleanup)
+	if resp.Code == connector.CodeOK {
+		processes.add(m)
 	}
+	log.Printf("Request %s: %s, exit code: %d, stdout: %s, stderr: %s", req.ID, resp.Code, resp.ExitCode, resp.Stdout, resp.Stderr)
+	return resp
 }
 
-func checkJindofsCmd(cmd string) error {
-	jindofsPrefix := "systemd-run --scope -- /etc/jindofs-tool/jindo-fuse "
-	if strings.HasPrefix(cmd, jindofsPrefix) {
+// checkMountCmd refuse the commands which are not the fuse commands of the registry
+func checkMountCmd(cmd string) error {
+	registry := loadRegistry()
+	client := fuseClientOf(registry, cmd)
+	if isFuseVersionCmd(registry, cmd) {
+		return nil
+	} else if strings.Contains(cmd, "mount -t alinas") {
+		return checkRichNasClientCmd(cmd)
+	} else if client == fuse.OssfsClient {
+		return checkOssfsCmd(registry, cmd)
+	} else if client == fuse.JindofsClient {
+		return checkJindofsCmd(registry, cmd)
+	} else if strings.HasPrefix(cmd, "systemd-run ") || strings.HasPrefix(cmd, fuse.LaunchCommand+" ") {
+		return errors.New("launch command of an unknown fuse client: " + cmd)
+	}
+	return nil
+}
+
+// execute run cmd until ctx is done, with separate stdout and stderr
+func execute(ctx context.Context, cmd string) *connector.Response {
+	c, cleanup := executor.Shell(cmd), func() {}
+	if strings.HasPrefix(cmd, fuse.LaunchCommand+" ") {
+		var err error
+		if c, cleanup, err = prepareLaunch(cmd); err != nil {
+			return &connector.Response{Code: connector.CodeExecFailed, ExitCode: -1, Message: err.Error()}
+		}
+	}
+	return waitCommand(ctx, c, cleanup)
+}
+
+// waitCommand run c until ctx is done, with separate stdout and stderr, and call cleanup if it fails
+func waitCommand(ctx context.Context, c *executor.Command, cleanup func()) *connector.Response {
+	result, err := commandExecutor.Run(ctx, c)
+	if err != nil {
+		cleanup()
+	}
+	return connector.CommandResponse(result, err)
+}
+
+// loadRegistry read the fuse client registry the plugin copies to the host
+func loadRegistry() *fuse.Registry {
+	registry, err := fuse.LoadRegistry(fuse.HostRegistryPath)
+	if err != nil {
+		log.Printf("Load fuse registry is failed, only default binaries are allowed, err: %v", err)
+		return &fuse.Registry{}
+	}
+	return registry
+}
+
+// fuseClientOf return the fuse client started by cmd, if any
+func fuseClientOf(registry *fuse.Registry, cmd string) string {
+	fuseCmd := cmd
+	if launch, err := fuse.ParseLaunch(cmd); err == nil {
+		fuseCmd = launch.Command
+	} else if index := strings.Index(cmd, " -- "); index >= 0 {
+		// the fuse client check reports the launcher error
+		fuseCmd = cmd[index+len(" -- "):]
+	}
+	client, _ := registry.MatchBinary(fuseCmd)
+	return client
+}
+
+// isFuseVersionCmd allow the plugin to probe the version of installed fuse clients
+func isFuseVersionCmd(registry *fuse.Registry, cmd string) bool {
+	binary := strings.TrimSuffix(cmd, " --version")
+	if binary == cmd {
+		return false
+	}
+	client, matched := registry.MatchBinary(binary)
+	return client != "" && matched == binary
+}
+
+func checkJindofsCmd(registry *fuse.Registry, cmd string) error {
+	launch, err := fuse.ParseLaunch(cmd)
+	if err != nil {
+		return err
+	}
+	fuseCmd, launched := launch.Command, launch.Launcher != ""
+	jindofsPrefix := ""
+	if client, binary := registry.MatchBinary(fuseCmd); launched && client == fuse.JindofsClient {
+		jindofsPrefix = binary + " "
+	}
+	if jindofsPrefix != "" {
 		if strings.Contains(cmd, ";") {
 			return errors.New("Jindofs Options: command cannot contains ; " + cmd)
 		}
-		cmdParametes := strings.TrimPrefix(cmd, jindofsPrefix)
+		cmdParametes := strings.TrimPrefix(fuseCmd, jindofsPrefix)
 		cmdParametes = strings.TrimSpace(cmdParametes)
 		cmdParametes = strings.Join(strings.Fields(cmdParametes), " ")
 		parameteList := strings.Split(cmdParametes, " ")
+		oFlag := false
 		for _, value := range parameteList {
+			if value == "-o" {
+				if oFlag {
+					return errors.New("Jindofs Options: inputs must -o string, 2 -o now ")
+				}
+				oFlag = true
+				continue
+			}
+			if oFlag {
+				oFlag = false
+				continue
+			}
 			if !strings.HasPrefix(value, "-o") && !strings.HasPrefix(value, "/") {
 				return errors.New("Jindofs Options: must start with -o :" + cmd)
 			}
 		}
+		if oFlag {
+			return errors.New("Jindofs Options: no option follows -o :" + cmd)
+		}
 	}
 	return nil
 }
@@ -287,11 +497,19 @@ func checkRichNasClientCmd(cmd string) error {
 // /var/lib/kubelet/pods/070d1a40-16a4-11ea-842e-00163e062fe1/volumes/kubernetes.io~csi/oss-csi-pv/mount
 // -ourl=oss-cn-shenzhen-internal.aliyuncs.com
 // -o max_stat_cache_size=0 -o allow_other
-func checkOssfsCmd(cmd string) error {
-	ossCmdPrefixList := []string{"systemd-run --scope -- /usr/local/bin/ossfs", "systemd-run --scope -- ossfs", "ossfs"}
+func checkOssfsCmd(registry *fuse.Registry, cmd string) error {
+	launch, err := fuse.ParseLaunch(cmd)
+	if err != nil {
+		return err
+	}
+	fuseCmd, launched := launch.Command, launch.Launcher != ""
+	ossCmdPrefixList := []string{"ossfs"}
+	if client, binary := registry.MatchBinary(fuseCmd); launched && client == fuse.OssfsClient {
+		ossCmdPrefixList = []string{binary, "ossfs"}
+	}
 	ossCmdPrefix := ""
 	for _, cmdPrefix := range ossCmdPrefixList {
-		if strings.HasPrefix(cmd, cmdPrefix) {
+		if strings.HasPrefix(fuseCmd, cmdPrefix) {
 			ossCmdPrefix = cmdPrefix
 			break
 		}
@@ -299,7 +517,7 @@ func checkOssfsCmd(cmd string) error {
 
 	// check oss command options
 	if ossCmdPrefix != "" {
-		cmdParametes := strings.TrimPrefix(cmd, ossCmdPrefix)
+		cmdParametes := strings.TrimPrefix(fuseCmd, ossCmdPrefix)
 		cmdParametes = strings.TrimSpace(cmdParametes)
 		cmdParametes = strings.Join(strings.Fields(cmdParametes), " ")
 
@@ -348,12 +566,15 @@ func checkOssfsCmd(cmd string) error {
 	return errors.New("Oss Options: options with error prefix: " + cmd)
 }
 
+// run run the shell command cmd, with stdout and stderr combined
 func run(cmd string) (string, error) {
-	out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+	c := executor.Shell(cmd)
+	c.Combined = true
+	result, err := commandExecutor.Run(context.Background(), c)
 	if err != nil {
-		return "", fmt.Errorf("Failed to run cmd: " + cmd + ", with out: " + string(out) + ", with error: " + err.Error())
+		return "", fmt.Errorf("Failed to run cmd: " + cmd + ", with out: " + result.Stdout + ", with error: " + err.Error())
 	}
-	return string(out), nil
+	return result.Stdout, nil
 }
 
 // IsFileExisting checks file exist in volume driver or not
@@ -381,14 +602,24 @@ func isUnixDomainSocketLive(socketPath string) error {
 	return nil
 }
 
-// EnsureSocketPath ...
-func EnsureSocketPath(socketPath string) {
+// EnsureSocketPath remove the socket left by a previous connector and listen on socketPath,
+// the socket is only accessible by root and a missing directory is created for root only
+func EnsureSocketPath(socketPath string) (net.Listener, error) {
 	if IsFileExisting(socketPath) {
 		os.Remove(socketPath)
 	} else {
 		pathDir := filepath.Dir(socketPath)
 		if !IsFileExisting(pathDir) {
-			os.MkdirAll(pathDir, os.ModePerm)
+			os.MkdirAll(pathDir, socketDirMode)
 		}
 	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, socketMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
 }
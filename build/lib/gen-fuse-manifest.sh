@@ -0,0 +1,37 @@
+#!/bin/sh
+# Generate the manifest of the fuse clients shipped in the plugin image.
+# Layout: <root>/<client>/<version>/bin/<binary> and <root>/<client>/<version>/lib/*.so*
+set -e
+
+root=${1:-/csi/fuse}
+manifest="${root}/manifest.json"
+
+cd "${root}"
+first_client="true"
+echo '{"clients": [' > "${manifest}.tmp"
+for client_dir in */*/; do
+    client=$(dirname "${client_dir}")
+    version=$(basename "${client_dir}")
+    entrypoint=$(cd "${client_dir}" && ls bin/* | head -n 1)
+    if [ "${first_client}" = "false" ]; then
+        echo ',' >> "${manifest}.tmp"
+    fi
+    first_client="false"
+    printf '{"name": "%s", "version": "%s", "entrypoint": "%s", "files": [' "${client}" "${version}" "${entrypoint}" >> "${manifest}.tmp"
+    first_file="true"
+    for file in $(cd "${client_dir}" && find . -type f | sed 's|^\./||' | sort); do
+        sum=$(sha256sum "${client_dir}${file}" | awk '{print $1}')
+        mode="0644"
+        if [ -x "${client_dir}${file}" ]; then
+            mode="0755"
+        fi
+        if [ "${first_file}" = "false" ]; then
+            printf ',' >> "${manifest}.tmp"
+        fi
+        first_file="false"
+        printf '{"path": "%s", "sha256": "%s", "mode": "%s"}' "${file}" "${sum}" "${mode}" >> "${manifest}.tmp"
+    done
+    printf ']}' >> "${manifest}.tmp"
+done
+echo ']}' >> "${manifest}.tmp"
+mv "${manifest}.tmp" "${manifest}"
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sevlyar/go-daemon"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+)
+
+const (
+	// ControlSocketPath is where a running connector hands its listening sockets over to a new version
+	ControlSocketPath = "/etc/csi-tool/connector-control.sock"
+	// controlTimeout bounds a step of the handoff
+	controlTimeout = 10 * time.Second
+	// maxControlMessage is the size of the largest control message, the process list of a node
+	// with many mounts is large
+	maxControlMessage = 64 << 20
+	// maxControlFiles is the number of sockets a control message can pass
+	maxControlFiles = 16
+
+	// opHandoff asks the running connector for its listening sockets, it keeps serving until the
+	// new connector confirms with opListening
+	opHandoff = "handoff"
+	// opListening confirms the new connector accepts connections on the handed off sockets, the
+	// running connector releases its pid file, stops accepting and drains its requests
+	opListening = "listening"
+)
+
+// controlRequest is a request of a new connector on the control socket
+type controlRequest struct {
+	Op      string `json:"op"`
+	Version string `json:"version"`
+	PID     int    `json:"pid"`
+}
+
+// controlResponse answers a control request, the listening sockets of Sockets are passed
+// in the same order with SCM_RIGHTS. Processes is sent once the connector is drained.
+type controlResponse struct {
+	Version      string             `json:"version"`
+	PID          int                `json:"pid"`
+	Error        string             `json:"error,omitempty"`
+	Sockets      []string           `json:"sockets,omitempty"`
+	DrainTimeout time.Duration      `json:"drainTimeout,omitempty"`
+	Processes    []handedOffProcess `json:"processes,omitempty"`
+}
+
+// handedOffProcess is a fuse process supervised by the connector
+type handedOffProcess struct {
+	Process connector.Process       `json:"process"`
+	Mount   *connector.MountRequest `json:"mount"`
+	// Exited is set if the exit of the process is handled
+	Exited bool `json:"exited"`
+}
+
+var (
+	pidLock sync.Mutex
+	// pidFile is the locked pid file of the connector, nil before it is locked and after it is released
+	pidFile *daemon.LockFile
+)
+
+// lockPIDFile lock and write the pid file, it fails while another connector holds it
+func lockPIDFile() error {
+	pidLock.Lock()
+	defer pidLock.Unlock()
+	f, err := daemon.OpenLockFile(PIDFilename, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Lock(); err != nil {
+		// the file belongs to the running connector, it is not removed
+		f.Close()
+		return err
+	}
+	if err := f.WritePid(); err != nil {
+		f.Remove()
+		return err
+	}
+	pidFile = f
+	return nil
+}
+
+// releasePID remove the pid file at handoff or at exit, it return whether the file was held
+func releasePID() bool {
+	pidLock.Lock()
+	defer pidLock.Unlock()
+	if pidFile == nil {
+		return false
+	}
+	pidFile.Remove()
+	pidFile = nil
+	return true
+}
+
+// serveControl answer the control requests of new connectors
+func (s *server) serveControl() error {
+	ln, err := EnsureSocketPath(s.controlPath)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.control = ln.(*net.UnixListener)
+	s.lock.Unlock()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if s.isStopping() {
+					return
+				}
+				log.Printf("Control socket Accept error: %s", err.Error())
+				continue
+			}
+			s.handleControl(conn.(*net.UnixConn))
+		}
+	}()
+	return nil
+}
+
+// handleControl answer a control request, only a caller of the user of the connector may take over.
+// The connection is kept by a handoff to send the fuse processes after the drain.
+func (s *server) handleControl(conn *net.UnixConn) {
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	peer, err := connector.ReadPeer(conn, procRoot)
+	if err != nil || peer.UID != uint32(os.Geteuid()) {
+		conn.Close()
+		log.Printf("Reject control caller %v, err: %v", peer, err)
+		return
+	}
+	req := &controlRequest{}
+	files, err := readControl(conn, req)
+	closeFiles(files)
+	if err != nil {
+		conn.Close()
+		log.Printf("Read control request is failed, err: %v", err)
+		return
+	}
+	if req.Op != opHandoff {
+		writeControl(conn, &controlResponse{Version: VERSION, PID: os.Getpid(), Error: "unknown op " + req.Op}, nil)
+		conn.Close()
+		return
+	}
+	if !s.handoff(conn, req) {
+		conn.Close()
+	}
+}
+
+// handoff pass the listening sockets to the new connector. This connector keeps serving until the
+// new one confirms it accepts connections on them, then it releases the pid file and stops. The fuse
+// processes are passed after the drain, with the mounts completed by the drained requests.
+func (s *server) handoff(conn *net.UnixConn, req *controlRequest) bool {
+	resp := &controlResponse{Version: VERSION, PID: os.Getpid()}
+	s.lock.Lock()
+	if s.successor != nil || s.isStopping() {
+		s.lock.Unlock()
+		resp.Error = "connector is stopping"
+		writeControl(conn, resp, nil)
+		return false
+	}
+	var files []*os.File
+	defer func() { closeFiles(files) }()
+	for path, ln := range s.listeners {
+		f, err := ln.(*net.UnixListener).File()
+		if err != nil {
+			s.lock.Unlock()
+			resp.Error = fmt.Sprintf("dup socket %s: %v", path, err)
+			writeControl(conn, resp, nil)
+			return false
+		}
+		files = append(files, f)
+		resp.Sockets = append(resp.Sockets, path)
+	}
+	resp.DrainTimeout = s.drainTimeout
+	s.lock.Unlock()
+	if err := writeControl(conn, resp, files); err != nil {
+		log.Printf("Hand off sockets to connector %s is failed, keep serving, err: %v", req.Version, err)
+		return false
+	}
+
+	ack := &controlRequest{}
+	acked, err := readControl(conn, ack)
+	closeFiles(acked)
+	if err == nil && ack.Op != opListening {
+		err = fmt.Errorf("unexpected op %s", ack.Op)
+	}
+	if err != nil {
+		log.Printf("Connector %s did not take over the sockets, keep serving, err: %v", req.Version, err)
+		return false
+	}
+	held := releasePID()
+	if err := writeControl(conn, &controlResponse{Version: VERSION, PID: os.Getpid()}, nil); err != nil {
+		log.Printf("Confirm handoff to connector %s is failed, keep serving, err: %v", req.Version, err)
+		if held {
+			if err := lockPIDFile(); err != nil {
+				log.Printf("Lock pid file %s again is failed, err: %v", PIDFilename, err)
+			}
+		}
+		return false
+	}
+
+	now := time.Now()
+	s.lock.Lock()
+	s.handedOff, s.successor = true, conn
+	s.lifecycle.Handoff = &connector.Handoff{
+		Time: now, FromVersion: VERSION, FromPID: os.Getpid(), ToVersion: req.Version, ToPID: req.PID, Sockets: resp.Sockets,
+	}
+	s.lock.Unlock()
+	s.stop(fmt.Sprintf("handoff to connector %s (pid %d)", req.Version, req.PID))
+	return true
+}
+
+// handOffProcesses pass the fuse processes to the new connector once the requests are drained
+func (s *server) handOffProcesses() {
+	s.lock.Lock()
+	conn := s.successor
+	s.lock.Unlock()
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+	list := s.processes.handOff()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	if err := writeControl(conn, &controlResponse{Version: VERSION, PID: os.Getpid(), Processes: list}, nil); err != nil {
+		s.processes.resume()
+		log.Printf("Hand off %d fuse processes is failed, they are not supervised by the new connector, err: %v", len(list), err)
+		return
+	}
+	s.lock.Lock()
+	s.lifecycle.Handoff.Processes = len(list)
+	s.lock.Unlock()
+}
+
+// writeControl write a control message: its length and files passed as SCM_RIGHTS, then its body
+func writeControl(conn *net.UnixConn, v interface{}, files []*os.File) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(body) > maxControlMessage {
+		return fmt.Errorf("control message of %d bytes exceeds %d", len(body), maxControlMessage)
+	}
+	if len(files) > maxControlFiles {
+		return fmt.Errorf("%d files exceed %d", len(files), maxControlFiles)
+	}
+	var oob []byte
+	if len(files) > 0 {
+		fds := make([]int, 0, len(files))
+		for _, f := range files {
+			fds = append(fds, int(f.Fd()))
+		}
+		oob = syscall.UnixRights(fds...)
+	}
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(body)))
+	if _, _, err := conn.WriteMsgUnix(header, oob, nil); err != nil {
+		return err
+	}
+	_, err = conn.Write(body)
+	return err
+}
+
+// readControl read a control message into v and return the files passed with it,
+// the files are closed if it fails
+func readControl(conn *net.UnixConn, v interface{}) (files []*os.File, err error) {
+	header, oob := make([]byte, 4), make([]byte, syscall.CmsgSpace(maxControlFiles*4))
+	n, oobn, flags, _, err := conn.ReadMsgUnix(header, oob)
+	if oobn > 0 {
+		files, err = parseRights(oob[:oobn], err)
+	}
+	defer func() {
+		if err != nil {
+			closeFiles(files)
+			files = nil
+		}
+	}()
+	if err != nil {
+		return files, err
+	}
+	if flags&syscall.MSG_CTRUNC != 0 {
+		return files, errors.New("control message passes too many files")
+	}
+	if n < len(header) {
+		if _, err := io.ReadFull(conn, header[n:]); err != nil {
+			return files, err
+		}
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxControlMessage {
+		return files, fmt.Errorf("control message of %d bytes exceeds %d", size, maxControlMessage)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return files, err
+	}
+	return files, json.Unmarshal(body, v)
+}
+
+// parseRights return the files passed in oob, readErr is returned unless parsing fails
+func parseRights(oob []byte, readErr error) ([]*os.File, error) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	var files []*os.File
+	for i := range messages {
+		fds, err := syscall.ParseUnixRights(&messages[i])
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			files = append(files, os.NewFile(uintptr(fd), "handoff"))
+		}
+	}
+	return files, readErr
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// takeover is a handoff of the sockets of the running connector in progress
+type takeover struct {
+	conn         *net.UnixConn
+	listeners    map[string]net.Listener
+	handoff      *connector.Handoff
+	drainTimeout time.Duration
+}
+
+// takeOver ask the connector serving the control socket at path for its listening sockets, it return
+// nil if no connector runs. The running connector keeps serving until confirm.
+func takeOver(path string) (*takeover, error) {
+	c, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	conn := c.(*net.UnixConn)
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	if err := writeControl(conn, &controlRequest{Op: opHandoff, Version: VERSION, PID: os.Getpid()}, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp := &controlResponse{}
+	files, err := readControl(conn, resp)
+	// the listeners use duplicates of the files
+	defer closeFiles(files)
+	if err == nil && resp.Error != "" {
+		err = errors.New(resp.Error)
+	}
+	if err == nil && len(files) != len(resp.Sockets) {
+		err = fmt.Errorf("%d sockets are passed for %v", len(files), resp.Sockets)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t := &takeover{conn: conn, listeners: map[string]net.Listener{}, drainTimeout: resp.DrainTimeout}
+	for i, f := range files {
+		ln, err := net.FileListener(f)
+		if err != nil {
+			t.abort()
+			return nil, fmt.Errorf("use handed off socket %s: %v", resp.Sockets[i], err)
+		}
+		t.listeners[resp.Sockets[i]] = ln
+	}
+	t.handoff = &connector.Handoff{
+		Time: time.Now(), FromVersion: resp.Version, FromPID: resp.PID, ToVersion: VERSION, ToPID: os.Getpid(), Sockets: resp.Sockets,
+	}
+	return t, nil
+}
+
+// abort close the handed off sockets without removing them, the running connector keeps serving them
+func (t *takeover) abort() {
+	for _, ln := range t.listeners {
+		ln.Close()
+	}
+	t.conn.Close()
+}
+
+// confirm tell the running connector this one accepts connections on the sockets, it return once
+// the running connector released its pid file and stops accepting
+func (t *takeover) confirm() error {
+	t.conn.SetDeadline(time.Now().Add(controlTimeout))
+	if err := writeControl(t.conn, &controlRequest{Op: opListening, Version: VERSION, PID: os.Getpid()}, nil); err != nil {
+		return err
+	}
+	resp := &controlResponse{}
+	files, err := readControl(t.conn, resp)
+	closeFiles(files)
+	if err != nil {
+		return err
+	}
+	// the sockets are owned by this connector from now on
+	for _, ln := range t.listeners {
+		ln.(*net.UnixListener).SetUnlinkOnClose(true)
+	}
+	return nil
+}
+
+// receiveProcesses supervise the fuse processes of the previous connector once it is drained
+func (s *server) receiveProcesses(t *takeover) {
+	defer t.conn.Close()
+	t.conn.SetDeadline(time.Now().Add(t.drainTimeout + controlTimeout))
+	resp := &controlResponse{}
+	files, err := readControl(t.conn, resp)
+	closeFiles(files)
+	if err != nil {
+		log.Printf("Receive the fuse processes of connector %s is failed, they are not supervised, err: %v", t.handoff.FromVersion, err)
+		return
+	}
+	s.processes.takeOver(resp.Processes)
+	s.lock.Lock()
+	if s.lifecycle.Handoff != nil {
+		s.lifecycle.Handoff.Processes = len(resp.Processes)
+	}
+	s.lock.Unlock()
+	log.Printf("Took over %d fuse processes of connector %s (pid %d)", len(resp.Processes), resp.Version, resp.PID)
+}
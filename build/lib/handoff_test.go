@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+)
+
+// targets return the targets of the processes in the inventory
+func targets(i *processInventory) []string {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	var list []string
+	for target := range i.processes {
+		list = append(list, target)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestHandoff(t *testing.T) {
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "connector.sock")
+	old := newTestServer(dir)
+	old.processes.add(&connector.MountRequest{Target: "/mnt/a"})
+	release := make(chan struct{})
+	// a mount in flight completes during the drain
+	assert.Nil(t, old.listen(socketPath, func(c net.Conn, peer *connector.Peer) {
+		defer c.Close()
+		<-release
+		old.processes.add(&connector.MountRequest{Target: "/mnt/b"})
+		c.Write([]byte("old"))
+	}))
+	assert.Nil(t, old.serveControl())
+	oldDone := make(chan struct{})
+	go func() {
+		old.run()
+		close(oldDone)
+	}()
+	inflight := make(chan string)
+	go func() { inflight <- request(t, socketPath) }()
+	assert.True(t, waitFor(func() bool { return old.status().InFlight == 1 }))
+
+	takeover, err := takeOver(old.controlPath)
+	assert.Nil(t, err)
+	if !assert.NotNil(t, takeover) {
+		return
+	}
+	assert.Equal(t, []string{socketPath}, takeover.handoff.Sockets)
+	assert.Equal(t, old.drainTimeout, takeover.drainTimeout)
+	// the old connector keeps serving until the new one confirms
+	assert.False(t, old.isStopping())
+
+	s := newTestServer(dir)
+	s.controlPath = filepath.Join(dir, "control-new.sock")
+	s.inherit(takeover.listeners, takeover.handoff)
+	assert.Nil(t, s.listen(socketPath, replyServer("new", closedChannel())))
+	assert.Nil(t, takeover.confirm())
+	go s.receiveProcesses(takeover)
+
+	assert.True(t, waitFor(old.isStopping))
+	assert.Equal(t, "new", request(t, socketPath))
+	close(release)
+	assert.Equal(t, "old", <-inflight)
+	<-oldDone
+
+	// the socket is kept for the new connector, the processes are handed over after the drain
+	_, err = os.Stat(socketPath)
+	assert.Nil(t, err)
+	assert.True(t, waitFor(func() bool { return len(targets(s.processes)) == 2 }))
+	assert.Equal(t, []string{"/mnt/a", "/mnt/b"}, targets(s.processes))
+	assert.True(t, old.processes.handedOff)
+	assert.Equal(t, 2, old.status().Handoff.Processes)
+	assert.True(t, waitFor(func() bool { return s.status().Handoff.Processes == 2 }))
+	assert.Equal(t, os.Getpid(), s.status().Handoff.FromPID)
+	exit := readLastExit(old.lastExitFile)
+	if assert.NotNil(t, exit) {
+		assert.True(t, exit.Drained)
+		assert.True(t, strings.HasPrefix(exit.Reason, "handoff to connector"))
+	}
+
+	s.shutdown("test")
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestHandoffAborted(t *testing.T) {
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "connector.sock")
+	old := newTestServer(dir)
+	old.processes.add(&connector.MountRequest{Target: "/mnt/a"})
+	assert.Nil(t, old.listen(socketPath, replyServer("old", closedChannel())))
+	assert.Nil(t, old.serveControl())
+	defer old.shutdown("test")
+
+	// the new connector fails before it confirms, the old one keeps serving and supervising
+	takeover, err := takeOver(old.controlPath)
+	assert.Nil(t, err)
+	if !assert.NotNil(t, takeover) {
+		return
+	}
+	takeover.abort()
+	assert.Equal(t, "old", request(t, socketPath))
+	assert.False(t, old.isStopping())
+	assert.False(t, old.processes.handedOff)
+	assert.Nil(t, old.status().Handoff)
+
+	// it can be taken over again
+	takeover, err = takeOver(old.controlPath)
+	assert.Nil(t, err)
+	if assert.NotNil(t, takeover) {
+		takeover.abort()
+	}
+}
+
+func TestTakeOverWithoutConnector(t *testing.T) {
+	takeover, err := takeOver(filepath.Join(t.TempDir(), "control.sock"))
+	assert.Nil(t, err)
+	assert.Nil(t, takeover)
+}
+
+func TestControlMessage(t *testing.T) {
+	a, b, err := unixPair()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer a.Close()
+	defer b.Close()
+
+	// a message larger than the socket buffer is read whole, with its files
+	processes := make([]handedOffProcess, 0, 5000)
+	for i := 0; i < cap(processes); i++ {
+		target := fmt.Sprintf("/var/lib/kubelet/pods/%d/volumes/kubernetes.io~csi/pv/mount", i)
+		processes = append(processes, handedOffProcess{Mount: &connector.MountRequest{Target: target}})
+	}
+	f, err := os.Open(os.DevNull)
+	assert.Nil(t, err)
+	defer f.Close()
+	sent := make(chan error, 1)
+	go func() { sent <- writeControl(a, &controlResponse{Version: "v1", Processes: processes}, []*os.File{f}) }()
+	resp := &controlResponse{}
+	files, err := readControl(b, resp)
+	assert.Nil(t, err)
+	assert.Nil(t, <-sent)
+	assert.Equal(t, 1, len(files))
+	closeFiles(files)
+	assert.Equal(t, len(processes), len(resp.Processes))
+
+	// an invalid body closes the files passed with it
+	go func() {
+		header := []byte{0, 0, 0, 3}
+		a.WriteMsgUnix(header, nil, nil)
+		a.Write([]byte("{x}"))
+	}()
+	files, err = readControl(b, resp)
+	assert.NotNil(t, err)
+	assert.Nil(t, files)
+}
+
+func unixPair() (*net.UnixConn, *net.UnixConn, error) {
+	ln, err := net.Listen("unix", filepath.Join(os.TempDir(), fmt.Sprintf("control-test-%d.sock", os.Getpid())))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	a, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		return nil, nil, err
+	}
+	b := <-accepted
+	if b == nil {
+		a.Close()
+		return nil, nil, fmt.Errorf("accept failed")
+	}
+	a.(*net.UnixConn).SetDeadline(time.Now().Add(10 * time.Second))
+	b.(*net.UnixConn).SetDeadline(time.Now().Add(10 * time.Second))
+	return a.(*net.UnixConn), b.(*net.UnixConn), nil
+}
+
+func closedChannel() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+)
+
+const (
+	// CgroupRoot is the cgroup filesystem of the host
+	CgroupRoot = "/sys/fs/cgroup"
+	// connectorCgroup is the cgroup of the connector on hosts without systemd
+	connectorCgroup = "csiplugin-connector"
+	// reapPeriod is the interval to remove the cgroups of exited fuse processes
+	reapPeriod = 30 * time.Second
+)
+
+// launchParents records the cgroup parents used by the process launcher, to reap their cgroups
+var launchParents sync.Map
+
+// runLaunch start a fuse command of the process launcher: the fuse process joins its own cgroup
+// and runs detached in a new session, so it is not bound to the connector
+func runLaunch(cmd string) (string, error) {
+	c, cleanup, err := prepareLaunch(cmd)
+	if err != nil {
+		return "", err
+	}
+	c.Combined = true
+	result, err := commandExecutor.Run(context.Background(), c)
+	if err != nil {
+		cleanup()
+		return "", fmt.Errorf("Failed to run cmd: %s, with out: %s, with error: %v", cmd, result.Stdout, err)
+	}
+	return result.Stdout, nil
+}
+
+// prepareLaunch create the cgroup of a launch command and return the command joining it,
+// and the cleanup removing the cgroup after the command fails
+func prepareLaunch(cmd string) (*executor.Command, func(), error) {
+	l, err := fuse.ParseLaunch(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	procs, cleanup, err := createLaunchCgroup(l)
+	if err != nil {
+		return nil, nil, err
+	}
+	// join the cgroup before exec, a daemonizing fuse client forks right after start
+	var script []string
+	for _, p := range procs {
+		script = append(script, fmt.Sprintf("echo $$ > %s", p))
+	}
+	script = append(script, "exec "+l.Command)
+	c := executor.Shell(strings.Join(script, " && "))
+	c.Setsid = true
+	return c, cleanup, nil
+}
+
+// prepareMount return the command of a typed mount request and the cleanup after it fails. The fuse client
+// is run directly or by systemd-run, the process launcher joins its cgroup by re-executing the connector with fuse.JoinCgroupArg.
+func prepareMount(m *connector.MountRequest) (*executor.Command, func(), error) {
+	l, argv := m.Launch(), m.Argv()
+	env, err := m.Environ("/")
+	if err != nil {
+		return nil, nil, err
+	}
+	if l.Launcher != fuse.LauncherProcess {
+		c := executor.NewCommand(append(l.PrefixArgs(), argv...)...)
+		c.Env = env
+		return c, func() {}, nil
+	}
+	procs, cleanup, err := createLaunchCgroup(l)
+	if err != nil {
+		return nil, nil, err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	args := append([]string{self, fuse.JoinCgroupArg}, procs...)
+	c := executor.NewCommand(append(append(args, "--"), argv...)...)
+	c.Setsid, c.Env = true, env
+	return c, cleanup, nil
+}
+
+// createLaunchCgroup create the cgroup of a launch and return its cgroup.procs files,
+// and the cleanup removing the cgroup
+func createLaunchCgroup(l *fuse.Launch) ([]string, func(), error) {
+	procs, err := fuse.CreateScopeCgroup(CgroupRoot, l.CgroupParent, l.Unit, l.Resources)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, loaded := launchParents.LoadOrStore(l.CgroupParent, true); !loaded {
+		go reapScopeCgroups(l.CgroupParent)
+	}
+	return procs, func() { fuse.RemoveScopeCgroup(CgroupRoot, l.CgroupParent, l.Unit) }, nil
+}
+
+// reapScopeCgroups remove the cgroups of a parent whose fuse process has exited,
+// cgroups still holding processes cannot be removed
+func reapScopeCgroups(parent string) {
+	for {
+		fuse.ReapScopeCgroups(CgroupRoot, parent)
+		time.Sleep(reapPeriod)
+	}
+}
+
+// joinConnectorCgroup move the connector to its own cgroup under the fuse cgroup parent
+func joinConnectorCgroup() {
+	procs, err := fuse.CreateScopeCgroup(CgroupRoot, fuse.CgroupParent(), connectorCgroup, fuse.Resources{})
+	if err != nil {
+		log.Printf("Create connector cgroup is failed, err: %v", err)
+		return
+	}
+	for _, p := range procs {
+		if err := os.WriteFile(p, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+			log.Printf("Join connector cgroup %s is failed, err: %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+)
+
+const (
+	// LastExitFilename records why the connector exited, it is reported by the next connector
+	LastExitFilename = "/var/log/alicloud/connector_last_exit.json"
+	// drainTimeoutEnv is how long the connector waits for the requests in flight when it stops
+	drainTimeoutEnv     = "CONNECTOR_DRAIN_TIMEOUT"
+	defaultDrainTimeout = 30 * time.Second
+	// watchdogSocketsEnv are the sockets of the plugins, the connector exits when all of them are dead
+	watchdogSocketsEnv = "WATCHDOG_SOCKETS_PATH"
+	watchdogPeriod     = 10 * time.Second
+	// watchdogFailures is the number of consecutive failed checks of a dead socket
+	watchdogFailures = 6
+)
+
+// server accepts the connections of the sockets of the connector until it is stopped by a signal
+// or by the watchdog, and then drains the requests in flight before it exits
+type server struct {
+	configFile *connector.EnvFile
+	// controlPath is the control socket, lastExitFile records the exit for the next connector
+	controlPath  string
+	lastExitFile string
+	processes    *processInventory
+
+	lock      sync.Mutex
+	listeners map[string]net.Listener
+	// inherited are the listening sockets handed off by the previous connector
+	inherited map[string]net.Listener
+	control   *net.UnixListener
+	// handedOff is set once the sockets are handed off to a new connector, they are not removed at exit
+	handedOff bool
+	// successor is the control connection of the new connector, the fuse processes are passed to it after the drain
+	successor    *net.UnixConn
+	policy       *connector.PeerPolicy
+	drainTimeout time.Duration
+	lifecycle    connector.Lifecycle
+
+	// active counts the connections in flight under lock, idle is signaled when it drops to zero.
+	// Unlike a WaitGroup, a connection accepted while shutdown waits may still be counted.
+	active   int64
+	idle     *sync.Cond
+	stopping int32
+	stopped  chan string
+}
+
+// newServer load the configuration of the connector
+func newServer() (*server, error) {
+	s := &server{
+		configFile:   connector.NewEnvFile(connector.ConfigFile),
+		controlPath:  ControlSocketPath,
+		lastExitFile: LastExitFilename,
+		processes:    processes,
+		listeners:    map[string]net.Listener{},
+		lifecycle:    connector.Lifecycle{State: connector.StateServing, PreviousExit: readLastExit(LastExitFilename)},
+		stopped:      make(chan string, 1),
+	}
+	s.idle = sync.NewCond(&s.lock)
+	if err := s.loadConfig(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadConfig apply the config file and read the configuration from the environment
+func (s *server) loadConfig() error {
+	if err := s.configFile.Apply(); err != nil {
+		return err
+	}
+	policy, err := connector.PeerPolicyFromEnv()
+	if err != nil {
+		return fmt.Errorf("invalid peer policy: %v", err)
+	}
+	drainTimeout := defaultDrainTimeout
+	if value := strings.TrimSpace(os.Getenv(drainTimeoutEnv)); value != "" {
+		if drainTimeout, err = time.ParseDuration(value); err != nil || drainTimeout < 0 {
+			return fmt.Errorf("invalid %s %q", drainTimeoutEnv, value)
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.policy, s.drainTimeout = policy, drainTimeout
+	return nil
+}
+
+// reload apply the configuration again, a failed reload keeps the running configuration
+func (s *server) reload() {
+	err := s.loadConfig()
+	now := time.Now()
+	s.lock.Lock()
+	s.lifecycle.ReloadTime, s.lifecycle.ReloadError = &now, ""
+	if err != nil {
+		s.lifecycle.ReloadError = err.Error()
+	}
+	s.lock.Unlock()
+	if err != nil {
+		log.Printf("Reload is failed, keep the running configuration, err: %v", err)
+		return
+	}
+	log.Printf("Configuration is reloaded")
+}
+
+// inherit serve the sockets handed off by the previous connector instead of creating them
+func (s *server) inherit(listeners map[string]net.Listener, handoff *connector.Handoff) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.inherited = listeners
+	if handoff != nil {
+		s.lifecycle.Handoff = handoff
+	}
+}
+
+// listen accept the connections of socketPath and serve the authorized ones with serve. A connection
+// accepted while stopping is still served, the socket may be shared with the next connector.
+func (s *server) listen(socketPath string, serve func(net.Conn, *connector.Peer)) error {
+	s.lock.Lock()
+	ln, inherited := s.inherited[socketPath]
+	s.lock.Unlock()
+	if !inherited {
+		var err error
+		if ln, err = EnsureSocketPath(socketPath); err != nil {
+			return err
+		}
+	}
+	s.lock.Lock()
+	s.listeners[socketPath] = ln
+	s.lock.Unlock()
+	log.Printf("Socket path is ready: %s, handed off: %t", socketPath, inherited)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if s.isStopping() {
+					return
+				}
+				log.Printf("Server Accept error of %s: %s", socketPath, err.Error())
+				continue
+			}
+			s.lock.Lock()
+			s.active++
+			s.lock.Unlock()
+			go func() {
+				defer s.done()
+				serveAuthorized(s.peerPolicy(), socketPath, conn, serve)
+			}()
+		}
+	}()
+	return nil
+}
+
+// peerPolicy return the policy of the callers, it changes on reload
+func (s *server) peerPolicy() *connector.PeerPolicy {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.policy
+}
+
+// done count a connection out of flight and wake up the drain when none is left
+func (s *server) done() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.active--; s.active == 0 {
+		s.idle.Broadcast()
+	}
+}
+
+func (s *server) isStopping() bool {
+	return atomic.LoadInt32(&s.stopping) == 1
+}
+
+// stop make run return with reason, only the first reason is kept
+func (s *server) stop(reason string) {
+	select {
+	case s.stopped <- reason:
+	default:
+	}
+}
+
+// run handle signals until the connector is stopped: SIGHUP reloads the configuration,
+// SIGTERM, SIGINT and SIGQUIT stop it. It return after the requests in flight are drained.
+func (s *server) run() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	go s.watchdog()
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				log.Printf("Receive %s, reload configuration", sig)
+				s.reload()
+				continue
+			}
+			s.stop("signal " + sig.String())
+		case reason := <-s.stopped:
+			signal.Stop(signals)
+			s.shutdown(reason)
+			return
+		}
+	}
+}
+
+// shutdown stop accepting connections, wait for the requests in flight until the drain timeout,
+// remove the sockets or hand the fuse processes over, and record the exit for the next connector
+func (s *server) shutdown(reason string) {
+	log.Printf("Connector is stopping: %s", reason)
+	atomic.StoreInt32(&s.stopping, 1)
+	s.lock.Lock()
+	s.lifecycle.State = connector.StateDraining
+	for path, ln := range s.listeners {
+		if s.handedOff {
+			// the socket is served by the next connector
+			ln.(*net.UnixListener).SetUnlinkOnClose(false)
+			ln.Close()
+			continue
+		}
+		ln.Close()
+		os.Remove(path)
+	}
+	if s.control != nil {
+		s.control.SetUnlinkOnClose(!s.handedOff)
+		s.control.Close()
+	}
+	timeout := s.drainTimeout
+	s.lock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.lock.Lock()
+		for s.active > 0 {
+			s.idle.Wait()
+		}
+		s.lock.Unlock()
+		close(drained)
+	}()
+	exit := &connector.Exit{Reason: reason, Drained: true}
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		s.lock.Lock()
+		exit.Drained, exit.Abandoned = false, s.active
+		s.lock.Unlock()
+		log.Printf("Drain timeout %s is reached, abandon %d requests in flight", timeout, exit.Abandoned)
+	}
+	s.handOffProcesses()
+	exit.Time = time.Now()
+	writeLastExit(s.lastExitFile, exit)
+	releasePID()
+	log.Printf("Connector is stopped: %s, drained: %t", reason, exit.Drained)
+}
+
+// status return the lifecycle of the connector
+func (s *server) status() *connector.Lifecycle {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lifecycle := s.lifecycle
+	lifecycle.InFlight = s.active
+	return &lifecycle
+}
+
+// watchdog stop the connector when all the sockets of the plugins in WATCHDOG_SOCKETS_PATH
+// are dead for watchdogFailures checks, every check is recorded in the status
+func (s *server) watchdog() {
+	var paths []string
+	if value := os.Getenv(watchdogSocketsEnv); value != "" {
+		paths = strings.Split(value, ",")
+	}
+	if len(paths) == 0 {
+		return
+	}
+	failures := map[string]int{}
+	for {
+		dog := &connector.Watchdog{Time: time.Now()}
+		dead := 0
+		for _, path := range paths {
+			socket := connector.WatchdogSocket{Path: path, Alive: true}
+			if err := isUnixDomainSocketLive(path); err != nil {
+				log.Printf("socket %s is not alive: %v", path, err)
+				failures[path]++
+				socket.Alive, socket.Error = false, err.Error()
+			} else {
+				failures[path] = 0
+			}
+			socket.Failures = failures[path]
+			if failures[path] >= watchdogFailures {
+				dead++
+			}
+			dog.Sockets = append(dog.Sockets, socket)
+		}
+		exit := dead >= len(paths)
+		dog.Decision = fmt.Sprintf("%d of %d sockets are dead, keep serving", dead, len(paths))
+		if exit {
+			dog.Decision = fmt.Sprintf("all %d sockets are dead for %d checks, exit", len(paths), watchdogFailures)
+		}
+		s.lock.Lock()
+		s.lifecycle.Watchdog = dog
+		s.lock.Unlock()
+		if exit {
+			log.Printf("watchdog find too many dead sockets, csiplugin-connector will exit(0)")
+			s.stop("watchdog: " + dog.Decision)
+			return
+		}
+		time.Sleep(watchdogPeriod)
+	}
+}
+
+// readLastExit return the exit recorded by the previous connector
+func readLastExit(path string) *connector.Exit {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	exit := &connector.Exit{}
+	if err := json.Unmarshal(raw, exit); err != nil {
+		return nil
+	}
+	return exit
+}
+
+func writeLastExit(path string, exit *connector.Exit) {
+	raw, _ := json.Marshal(exit)
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		log.Printf("Record exit in %s is failed, err: %v", path, err)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/audit"
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+)
+
+// newTestServer return a server whose files are in dir, the caller of the test is allowed
+func newTestServer(dir string) *server {
+	s := &server{
+		configFile:   connector.NewEnvFile(filepath.Join(dir, "connector.env")),
+		controlPath:  filepath.Join(dir, "control.sock"),
+		lastExitFile: filepath.Join(dir, "last_exit.json"),
+		processes:    newProcessInventory(),
+		listeners:    map[string]net.Listener{},
+		lifecycle:    connector.Lifecycle{State: connector.StateServing},
+		policy:       &connector.PeerPolicy{UIDs: map[uint32]bool{uint32(os.Geteuid()): true}},
+		drainTimeout: 5 * time.Second,
+		stopped:      make(chan string, 1),
+	}
+	s.idle = sync.NewCond(&s.lock)
+	return s
+}
+
+// replyServer answer every connection with reply once release is closed
+func replyServer(reply string, release chan struct{}) func(net.Conn, *connector.Peer) {
+	return func(c net.Conn, peer *connector.Peer) {
+		defer c.Close()
+		<-release
+		c.Write([]byte(reply))
+	}
+}
+
+// request dial socketPath and return the reply
+func request(t *testing.T, socketPath string) string {
+	c, err := net.Dial("unix", socketPath)
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	reply, _ := ioutil.ReadAll(c)
+	return string(reply)
+}
+
+// waitFor poll cond for a few seconds
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+func TestShutdownDrain(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(dir)
+	socketPath := filepath.Join(dir, "connector.sock")
+	release := make(chan struct{})
+	assert.Nil(t, s.listen(socketPath, replyServer("ok", release)))
+
+	replies := make(chan string)
+	go func() { replies <- request(t, socketPath) }()
+	assert.True(t, waitFor(func() bool { return s.status().InFlight == 1 }))
+
+	stopped := make(chan struct{})
+	go func() {
+		s.shutdown("test")
+		close(stopped)
+	}()
+	// the socket is removed at once, the request in flight is still served
+	assert.True(t, waitFor(func() bool { _, err := os.Stat(socketPath); return os.IsNotExist(err) }))
+	assert.Equal(t, connector.StateDraining, s.status().State)
+	close(release)
+	assert.Equal(t, "ok", <-replies)
+	<-stopped
+
+	exit := readLastExit(s.lastExitFile)
+	if assert.NotNil(t, exit) {
+		assert.Equal(t, "test", exit.Reason)
+		assert.True(t, exit.Drained)
+		assert.Equal(t, int64(0), exit.Abandoned)
+	}
+}
+
+func TestShutdownDrainLateConnection(t *testing.T) {
+	s := newTestServer(t.TempDir())
+	s.active = 1
+	stopped := make(chan struct{})
+	go func() {
+		s.shutdown("test")
+		close(stopped)
+	}()
+	assert.True(t, waitFor(func() bool { return s.status().State == connector.StateDraining }))
+	// a connection accepted while stopping is counted and drained like the others
+	s.lock.Lock()
+	s.active++
+	s.lock.Unlock()
+	s.done()
+	select {
+	case <-stopped:
+		t.Fatal("shutdown did not wait for the late connection")
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.done()
+	<-stopped
+	assert.True(t, readLastExit(s.lastExitFile).Drained)
+}
+
+func TestShutdownDrainTimeout(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(dir)
+	s.drainTimeout = 100 * time.Millisecond
+	socketPath := filepath.Join(dir, "connector.sock")
+	release := make(chan struct{})
+	defer close(release)
+	assert.Nil(t, s.listen(socketPath, replyServer("ok", release)))
+
+	c, err := net.Dial("unix", socketPath)
+	assert.Nil(t, err)
+	defer c.Close()
+	assert.True(t, waitFor(func() bool { return s.status().InFlight == 1 }))
+
+	s.shutdown("test")
+	exit := readLastExit(s.lastExitFile)
+	if assert.NotNil(t, exit) {
+		assert.False(t, exit.Drained)
+		assert.Equal(t, int64(1), exit.Abandoned)
+	}
+}
+
+func TestRunStop(t *testing.T) {
+	s := newTestServer(t.TempDir())
+	done := make(chan struct{})
+	go func() {
+		s.run()
+		close(done)
+	}()
+	s.stop("first")
+	s.stop("second")
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not return")
+	}
+	assert.Equal(t, "first", readLastExit(s.lastExitFile).Reason)
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(dir)
+	t.Setenv(drainTimeoutEnv, "")
+	assert.Nil(t, s.loadConfig())
+	assert.Equal(t, defaultDrainTimeout, s.drainTimeout)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "connector.env"), []byte(drainTimeoutEnv+"=1m\n"), 0644))
+	s.reload()
+	assert.Equal(t, time.Minute, s.drainTimeout)
+	assert.Equal(t, "", s.status().ReloadError)
+
+	// an invalid configuration keeps the running one
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "connector.env"), []byte(drainTimeoutEnv+"=soon\n"), 0644))
+	s.reload()
+	assert.Equal(t, time.Minute, s.drainTimeout)
+	assert.NotEqual(t, "", s.status().ReloadError)
+	assert.NotNil(t, s.status().ReloadTime)
+}
+
+func TestLastExit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "last_exit.json")
+	assert.Nil(t, readLastExit(path))
+	writeLastExit(path, &connector.Exit{Reason: "signal terminated", Drained: true})
+	assert.Equal(t, &connector.Exit{Reason: "signal terminated", Drained: true}, readLastExit(path))
+}
+
+func TestProcessInventorySave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processes.json")
+	i := newProcessInventory()
+	i.path = path
+	i.add(&connector.MountRequest{Target: "/mnt/a", Unit: "oss-fuse-a"})
+	i.add(&connector.MountRequest{Target: "/mnt/b", Unit: "oss-fuse-b"})
+
+	// a connector started without a handoff supervises the saved processes
+	loaded := newProcessInventory()
+	loaded.path = path
+	loaded.load()
+	assert.Equal(t, []string{"/mnt/a", "/mnt/b"}, targets(loaded))
+	assert.Equal(t, "oss-fuse-a", loaded.processes["/mnt/a"].mount.Unit)
+
+	// a handed off inventory is saved by the new connector only
+	i.handOff()
+	i.add(&connector.MountRequest{Target: "/mnt/c"})
+	loaded = newProcessInventory()
+	loaded.path = path
+	loaded.load()
+	assert.Equal(t, []string{"/mnt/a", "/mnt/b"}, targets(loaded))
+}
+
+func TestServeAudit(t *testing.T) {
+	dir := t.TempDir()
+	logger, err := audit.NewLogger(filepath.Join(dir, "audit.log"))
+	assert.Nil(t, err)
+	previous := auditLog
+	auditLog = logger
+	defer func() { auditLog = previous }()
+	s := newTestServer(dir)
+	socketPath := filepath.Join(dir, "audit.sock")
+	go serveAudit(s, socketPath)
+	assert.True(t, waitFor(func() bool { _, err := os.Stat(socketPath); return err == nil }))
+
+	client := &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	resp, err := client.Get("http://connector" + audit.StreamPath)
+	if assert.Nil(t, err) {
+		logger.Log(&audit.Record{Source: audit.SourceConnector, Action: audit.ActionCommand, VolumeID: "pv-oss"})
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		assert.Nil(t, err)
+		assert.Contains(t, line, "pv-oss")
+		resp.Body.Close()
+	}
+
+	// a caller refused by the policy is not streamed the records
+	s.lock.Lock()
+	s.policy = &connector.PeerPolicy{UIDs: map[uint32]bool{uint32(os.Geteuid()) + 1: true}}
+	s.lock.Unlock()
+	client.CloseIdleConnections()
+	_, err = client.Get("http://connector" + audit.StreamPath)
+	assert.NotNil(t, err)
+	assert.True(t, waitFor(func() bool {
+		raw, _ := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+		return strings.Contains(string(raw), audit.ActionRejected)
+	}))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/audit"
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+)
+
+const (
+	// AuditLogFilename records the requests of the connector, rotated daily
+	AuditLogFilename = "/var/log/alicloud/csi_connector_audit.log"
+	// procRoot is where the connector reads the cgroups of its callers
+	procRoot = "/proc"
+	// socketMode lets only root connect to the sockets of the connector
+	socketMode = 0600
+	// socketDirMode is the mode of a socket directory created by the connector
+	socketDirMode = 0700
+)
+
+// auditLog records every request run and every caller refused by the connector as JSON lines,
+// nil if the audit log cannot be opened
+var auditLog *audit.Logger
+
+func openAuditLog() *audit.Logger {
+	logger, err := audit.NewLogger(AuditLogFilename)
+	if err != nil {
+		log.Printf("Open audit log %s is failed, requests are only logged, err: %v", AuditLogFilename, err)
+		return nil
+	}
+	return logger
+}
+
+// auditCaller return the caller of an audit record
+func auditCaller(peer *connector.Peer) *audit.Caller {
+	return &audit.Caller{PID: peer.PID, UID: peer.UID, GID: peer.GID, Cgroups: peer.Cgroups}
+}
+
+// serveAuthorized serve conn with serve if its peer is allowed by policy, and close it otherwise
+func serveAuthorized(policy *connector.PeerPolicy, socketPath string, conn net.Conn, serve func(net.Conn, *connector.Peer)) {
+	if peer, ok := authorizePeer(policy, socketPath, conn); ok {
+		serve(conn, peer)
+	}
+}
+
+// authorizePeer return the peer of conn if it is allowed by policy, a refused conn is closed and audited
+func authorizePeer(policy *connector.PeerPolicy, socketPath string, conn net.Conn) (*connector.Peer, bool) {
+	peer, err := connector.ReadPeer(conn, procRoot)
+	if err == nil {
+		err = policy.Authorize(peer)
+	} else {
+		peer = &connector.Peer{PID: -1}
+	}
+	if err != nil {
+		conn.Close()
+		log.Printf("Reject caller of %s: %s, err: %v", socketPath, peer, err)
+		record := &audit.Record{Source: audit.SourceConnector, Action: audit.ActionRejected, Caller: auditCaller(peer), ExitCode: -1}
+		record.Error = fmt.Sprintf("socket %s: %v", socketPath, err)
+		auditLog.Log(record)
+		return nil, false
+	}
+	return peer, true
+}
+
+// peerListener accept the connections of a unix socket whose peers are allowed by the policy
+// of the connector, the others are refused as on the sockets of requests
+type peerListener struct {
+	net.Listener
+	socketPath string
+	policy     func() *connector.PeerPolicy
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := authorizePeer(l.policy(), l.socketPath, conn); ok {
+			return conn, nil
+		}
+	}
+}
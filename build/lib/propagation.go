@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mountEntry is a line of mountinfo
+type mountEntry struct {
+	// device is the major:minor of the filesystem, the copies of a fuse mount share it
+	device     string
+	mountPoint string
+	// peerGroup is the shared:N tag, master the master:N tag, empty for a private mount
+	peerGroup string
+	master    string
+}
+
+// readMountInfo parse the mountinfo file at path
+func readMountInfo(path string) ([]mountEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []mountEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime shared:1 master:2 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+		e := mountEntry{device: fields[2], mountPoint: fields[4]}
+		for _, field := range fields[6:] {
+			if field == "-" {
+				break
+			}
+			if strings.HasPrefix(field, "shared:") {
+				e.peerGroup = strings.TrimPrefix(field, "shared:")
+			} else if strings.HasPrefix(field, "master:") {
+				e.master = strings.TrimPrefix(field, "master:")
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// verifyPropagation check that a mount over the disconnected mount at target reaches every copy of it:
+// the mount is shared and each copy, in any mount namespace, is its peer or its slave. Targets bound to
+// a shared mount point are its peers, containers hold slaves with mountPropagation HostToContainer.
+// A private copy keeps the disconnected mount whatever is mounted on the host.
+func verifyPropagation(procRoot, target string) error {
+	entries, err := readMountInfo(filepath.Join(procRoot, "self", "mountinfo"))
+	if err != nil {
+		return err
+	}
+	var top *mountEntry
+	for i := range entries {
+		if entries[i].mountPoint == target {
+			top = &entries[i]
+		}
+	}
+	if top == nil {
+		return fmt.Errorf("%s is not mounted", target)
+	}
+	if top.peerGroup == "" {
+		return fmt.Errorf("the mount at %s is not shared", target)
+	}
+	self, _ := os.Readlink(filepath.Join(procRoot, "self", "ns", "mnt"))
+	namespaces := map[string]string{self: "self"}
+	dir, err := os.Open(procRoot)
+	if err != nil {
+		return err
+	}
+	names, _ := dir.Readdirnames(-1)
+	dir.Close()
+	for _, name := range names {
+		if _, err := strconv.Atoi(name); err != nil {
+			continue
+		}
+		ns, err := os.Readlink(filepath.Join(procRoot, name, "ns", "mnt"))
+		if _, seen := namespaces[ns]; err != nil || seen {
+			continue
+		}
+		namespaces[ns] = name
+	}
+	for _, pid := range namespaces {
+		entries, err := readMountInfo(filepath.Join(procRoot, pid, "mountinfo"))
+		if err != nil {
+			// the process exited
+			continue
+		}
+		for _, e := range entries {
+			if e.device == top.device && e.peerGroup != top.peerGroup && e.master != top.peerGroup {
+				return fmt.Errorf("process %s holds a private copy of %s at %s", pid, target, e.mountPoint)
+			}
+		}
+	}
+	return nil
+}
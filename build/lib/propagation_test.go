@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const target = "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv-a/globalmount"
+
+// fakeProc write a proc tree whose processes have the mountinfo lines and mount namespace of their pid
+func fakeProc(t *testing.T, processes map[string][]string, namespaces map[string]string) string {
+	root := t.TempDir()
+	for pid, lines := range processes {
+		assert.Nil(t, os.MkdirAll(filepath.Join(root, pid, "ns"), 0755))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(root, pid, "mountinfo"), []byte(strings.Join(lines, "\n")+"\n"), 0644))
+		assert.Nil(t, os.Symlink(namespaces[pid], filepath.Join(root, pid, "ns", "mnt")))
+	}
+	return root
+}
+
+func TestVerifyPropagation(t *testing.T) {
+	host := []string{
+		"22 1 253:1 / / rw,relatime shared:1 - ext4 /dev/vda1 rw",
+		"600 22 0:80 / " + target + " rw,nosuid,nodev shared:300 - fuse.ossfs ossfs rw",
+		"610 22 0:80 / /var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/pv-a/mount rw,nosuid,nodev shared:300 - fuse.ossfs ossfs rw",
+	}
+	slave := []string{
+		"800 700 0:80 / /data rw,nosuid,nodev master:300 - fuse.ossfs ossfs rw",
+	}
+	private := []string{
+		"800 700 0:80 / /data rw,nosuid,nodev - fuse.ossfs ossfs rw",
+	}
+	namespaces := map[string]string{"self": "mnt:[1]", "1": "mnt:[1]", "100": "mnt:[2]"}
+
+	root := fakeProc(t, map[string][]string{"self": host, "1": host, "100": slave}, namespaces)
+	assert.Nil(t, verifyPropagation(root, target))
+
+	// a container without HostToContainer propagation keeps the disconnected mount
+	root = fakeProc(t, map[string][]string{"self": host, "1": host, "100": private}, namespaces)
+	err := verifyPropagation(root, target)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "process 100")
+	}
+
+	// a private mount point propagates nowhere
+	root = fakeProc(t, map[string][]string{"self": {strings.Replace(host[1], "shared:300 ", "", 1)}}, namespaces)
+	assert.NotNil(t, verifyPropagation(root, target))
+
+	assert.NotNil(t, verifyPropagation(root, "/mnt/none"))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/audit"
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+)
+
+const (
+	// mountInfoPath lists the mount points of the host, the connector runs in its mount namespace
+	mountInfoPath = "/proc/self/mountinfo"
+	// statusBindTimeout is how long the status endpoint waits for its address to be released
+	statusBindTimeout = 2 * time.Minute
+	// AuditSocketPath streams the audit records at audit.StreamPath, the records hold the commands
+	// and the callers of every request, so it is a socket of root behind the peer policy
+	AuditSocketPath = "/etc/csi-tool/connector-audit.sock"
+)
+
+var (
+	// VERSION is the version of the connector, set at build time
+	VERSION = "unknown"
+
+	startTime = time.Now()
+	stats     = connector.NewStats()
+	processes = newProcessInventory()
+	// connectorServer is the server of the sockets, its lifecycle is reported in the status
+	connectorServer *server
+)
+
+// currentStatus return the status of the connector
+func currentStatus() *connector.Status {
+	status := &connector.Status{
+		Version:       VERSION,
+		StartTime:     startTime,
+		UptimeSeconds: time.Since(startTime).Seconds(),
+		Requests:      stats.Snapshot(),
+		Processes:     processes.list(),
+	}
+	if connectorServer != nil {
+		status.Lifecycle = connectorServer.status()
+	}
+	return status
+}
+
+// serveStatus answer the status of the connector at address, a loopback address
+func serveStatus(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(connector.StatusPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(currentStatus())
+	})
+	// the previous connector keeps the address while it drains after a handoff
+	var ln net.Listener
+	var err error
+	for deadline := time.Now().Add(statusBindTimeout); ; time.Sleep(time.Second) {
+		if ln, err = net.Listen("tcp", address); err == nil || !errors.Is(err, syscall.EADDRINUSE) || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("Status endpoint is stopped, err: %v", err)
+		return
+	}
+	log.Printf("Status endpoint listening on address: %s", address)
+	if err := http.Serve(ln, mux); err != nil {
+		log.Printf("Status endpoint is stopped, err: %v", err)
+	}
+}
+
+// serveAudit stream the audit log at socketPath to the callers allowed by the peer policy of s
+func serveAudit(s *server, socketPath string) {
+	ln, err := EnsureSocketPath(socketPath)
+	if err != nil {
+		log.Printf("Audit stream is stopped, err: %v", err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(audit.StreamPath, auditLog)
+	log.Printf("Audit stream listening on socket: %s", socketPath)
+	if err := http.Serve(&peerListener{Listener: ln, socketPath: socketPath, policy: s.peerPolicy}, mux); err != nil {
+		log.Printf("Audit stream is stopped, err: %v", err)
+	}
+}
+
+// mountPoints return the mount points of the host
+func mountPoints() map[string]bool {
+	points := map[string]bool{}
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return points
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		if fields := strings.Fields(scanner.Text()); len(fields) > 4 {
+			points[fields[4]] = true
+		}
+	}
+	return points
+}
+
+// requestType return the type of a request counted in the status
+func requestType(req *connector.Request) string {
+	switch {
+	case req.Status:
+		return connector.RequestStatus
+	case req.Mount != nil:
+		return connector.RequestMount
+	case isFuseVersionCmd(loadRegistry(), req.Command):
+		return connector.RequestVersion
+	case strings.Contains(req.Command, "mount -t alinas"):
+		return connector.RequestNas
+	}
+	return connector.RequestCommand
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+	csilog "wujunyi792/oss-csi-lite-plugin/pkg/log"
+)
+
+const (
+	// supervisePeriod is the interval to check the fuse processes started by the connector
+	supervisePeriod = 10 * time.Second
+	// exitRetention keeps reporting a process which could not be restarted after its target is gone
+	exitRetention = 10 * time.Minute
+	// exitLogLines is the number of log lines collected from an exited fuse process
+	exitLogLines = 20
+	// exitResultUnknown is the result of an exit systemd does not know about
+	exitResultUnknown = "unknown"
+	// ProcessesFilename keeps the inventory of the fuse processes for a connector started after this one
+	// exited without a handoff, it is cleared at boot with the mounts
+	ProcessesFilename = "/var/run/oss-csi/connector_processes.json"
+	// RestartStageDir holds the mount of a restarted fuse process until it is bound over its target
+	RestartStageDir = "/var/run/oss-csi/restart"
+)
+
+// supervisedProcess is a fuse process and the mount request which started it
+type supervisedProcess struct {
+	connector.Process
+	mount *connector.MountRequest
+	// lastPIDs are the processes of the scope when it was last seen alive, to find their logs
+	lastPIDs []int
+	// exited is set once the exit of the process is handled, until it runs again
+	exited bool
+}
+
+// processInventory tracks the fuse processes started by typed mount requests by target,
+// and restarts those which exit while their target is mounted according to their policy
+type processInventory struct {
+	lock      sync.Mutex
+	processes map[string]*supervisedProcess
+	// handedOff is set once the processes are supervised by a new connector
+	handedOff bool
+	// path is the file the inventory is saved to on every change, none if empty
+	path string
+}
+
+func newProcessInventory() *processInventory {
+	return &processInventory{processes: map[string]*supervisedProcess{}}
+}
+
+// add record the fuse process of a mount which succeeded, it replaces the process of a remounted target
+func (i *processInventory) add(m *connector.MountRequest) {
+	p := &supervisedProcess{mount: m}
+	p.Client, p.Unit, p.Target, p.VolumeID, p.StartTime = m.Client, m.Unit, m.Target, m.VolumeID, time.Now()
+	p.RestartPolicy = connector.RestartNever
+	if m.Restart != nil {
+		p.RestartPolicy = m.Restart.Policy
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.processes[m.Target] = p
+	i.save()
+}
+
+// list return the processes with their liveness
+func (i *processInventory) list() []connector.Process {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.refresh()
+	list := make([]connector.Process, 0, len(i.processes))
+	for _, p := range i.processes {
+		list = append(list, p.Process)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Target < list[b].Target })
+	return list
+}
+
+// refresh update the liveness of the processes. Processes which exited after their target was
+// unmounted are forgotten, those which exited while still mounted are kept as dead.
+func (i *processInventory) refresh() {
+	mounted, forgotten := mountPoints(), false
+	for target, p := range i.processes {
+		p.PIDs = scopePIDs(p.mount)
+		p.Alive, p.Mounted = len(p.PIDs) > 0, mounted[target]
+		if p.Alive {
+			p.lastPIDs = p.PIDs
+		}
+		failedRecently := p.LastExit != nil && !p.LastExit.Restarted && time.Since(p.LastExit.Time) < exitRetention
+		if !p.Alive && !p.Mounted && !failedRecently {
+			delete(i.processes, target)
+			forgotten = true
+		}
+	}
+	if forgotten {
+		i.save()
+	}
+}
+
+// supervise handle the processes which exited while their target is mounted: their exit is
+// recorded and they are restarted if their policy allows
+func (i *processInventory) supervise() {
+	i.lock.Lock()
+	if i.handedOff {
+		i.lock.Unlock()
+		return
+	}
+	i.refresh()
+	var exited []*supervisedProcess
+	for _, p := range i.processes {
+		if !p.Alive && p.Mounted && !p.exited {
+			p.exited = true
+			exited = append(exited, p)
+		}
+	}
+	i.lock.Unlock()
+
+	for _, p := range exited {
+		exit := &connector.ProcessExit{Time: time.Now(), Result: unitResult(p.mount), Logs: processLogs(p.mount, p.lastPIDs)}
+		log.Printf("Fuse process of %s in %s exited, result: %s", p.Target, p.Unit, exit.Result)
+		restart := p.Restarts < p.mount.Restart.Limit()
+		if restart {
+			if err := restartProcess(p.mount); err != nil {
+				exit.Message = "restart is failed: " + err.Error()
+			} else {
+				exit.Restarted = true
+			}
+			log.Printf("Restart fuse process of %s, restarted: %t %s", p.Target, exit.Restarted, exit.Message)
+		} else if p.RestartPolicy == connector.RestartOnFailure {
+			exit.Message = "restart limit " + strconv.Itoa(p.mount.Restart.Limit()) + " is reached"
+		}
+		i.lock.Lock()
+		p.LastExit = exit
+		if restart {
+			p.Restarts++
+		}
+		if exit.Restarted {
+			p.StartTime, p.exited = time.Now(), false
+		}
+		i.save()
+		i.lock.Unlock()
+	}
+}
+
+// handOff stop supervising the processes and return them for the new connector
+func (i *processInventory) handOff() []handedOffProcess {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.handedOff = true
+	list := make([]handedOffProcess, 0, len(i.processes))
+	for _, p := range i.processes {
+		list = append(list, handedOffProcess{Process: p.Process, Mount: p.mount, Exited: p.exited})
+	}
+	return list
+}
+
+// resume supervise the processes again after a handoff failed
+func (i *processInventory) resume() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.handedOff = false
+}
+
+// takeOver supervise the processes handed off by the previous connector
+func (i *processInventory) takeOver(list []handedOffProcess) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for _, h := range list {
+		if h.Mount == nil {
+			continue
+		}
+		p := &supervisedProcess{Process: h.Process, mount: h.Mount, lastPIDs: h.Process.PIDs, exited: h.Exited}
+		i.processes[p.Target] = p
+	}
+	i.save()
+}
+
+// save write the inventory to its file, the caller holds the lock. A handed off inventory is
+// saved by the new connector.
+func (i *processInventory) save() {
+	if i.path == "" || i.handedOff {
+		return
+	}
+	list := make([]handedOffProcess, 0, len(i.processes))
+	for _, p := range i.processes {
+		list = append(list, handedOffProcess{Process: p.Process, Mount: p.mount, Exited: p.exited})
+	}
+	raw, err := json.Marshal(list)
+	if err == nil {
+		err = writeFileAtomic(i.path, raw)
+	}
+	if err != nil {
+		log.Printf("Save fuse processes to %s is failed, err: %v", i.path, err)
+	}
+}
+
+// load supervise the processes saved by the previous connector
+func (i *processInventory) load() {
+	raw, err := ioutil.ReadFile(i.path)
+	if err != nil {
+		return
+	}
+	var list []handedOffProcess
+	if err := json.Unmarshal(raw, &list); err != nil {
+		log.Printf("Load fuse processes from %s is failed, err: %v", i.path, err)
+		return
+	}
+	i.takeOver(list)
+	log.Printf("Loaded %d fuse processes of the previous connector from %s", len(list), i.path)
+}
+
+// writeFileAtomic replace the file at path with raw, readers never see a partial file
+func writeFileAtomic(path string, raw []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// superviseProcesses check the fuse processes periodically
+func superviseProcesses() {
+	for range time.Tick(supervisePeriod) {
+		processes.supervise()
+	}
+}
+
+// scopePIDs return the processes in the scope of a mount
+func scopePIDs(m *connector.MountRequest) []int {
+	if m.Unit == "" {
+		return nil
+	}
+	parent := fuse.FuseSlice
+	if m.Launcher == fuse.LauncherProcess {
+		parent = m.CgroupParent
+	}
+	return fuse.ScopePIDs(CgroupRoot, parent, m.Unit)
+}
+
+// unitResult return how the scope of a mount ended as recorded by systemd
+func unitResult(m *connector.MountRequest) string {
+	if m.Launcher != fuse.LauncherSystemd {
+		return exitResultUnknown
+	}
+	out, err := commandExecutor.Run(context.Background(), executor.NewCommand("systemctl", "show", m.Unit, "--property=Result", "--value"))
+	if result := strings.TrimSpace(out.Stdout); err == nil && result != "" {
+		return result
+	}
+	return exitResultUnknown
+}
+
+// processLogs return the last journal lines of the scope of a mount, or of its last processes
+// for the process launcher, fuse clients log to syslog
+func processLogs(m *connector.MountRequest, pids []int) []string {
+	args := []string{"--no-pager", "--output=cat", "--lines=" + strconv.Itoa(exitLogLines)}
+	if m.Launcher == fuse.LauncherSystemd {
+		args = append(args, "--unit="+m.Unit)
+	} else if len(pids) > 0 {
+		for index, pid := range pids {
+			if index > 0 {
+				args = append(args, "+")
+			}
+			args = append(args, "_PID="+strconv.Itoa(pid))
+		}
+	} else {
+		return nil
+	}
+	out, err := commandExecutor.Run(context.Background(), executor.NewCommand(append([]string{"journalctl"}, args...)...))
+	if err != nil {
+		return nil
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(out.Stdout), "\n") {
+		if line != "" {
+			lines = append(lines, csilog.Redact(line))
+		}
+	}
+	return lines
+}
+
+// restartProcess run the mount of a crashed fuse process again and bind it over the disconnected mount,
+// in the scope of the same name. Containers hold copies of the disconnected mount, which a remount of the
+// target would not reach: the new mount is bound over it only if it propagates to every copy. The target
+// is locked against its unmount by the plugin.
+func restartProcess(m *connector.MountRequest) error {
+	unlock, err := fuse.LockTarget("/", m.Target, connector.DefaultTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if !mountPoints()[m.Target] {
+		return fmt.Errorf("%s is unmounted", m.Target)
+	}
+	if err := verifyPropagation(procRoot, m.Target); err != nil {
+		return fmt.Errorf("the disconnected mount is kept, a new mount would not reach the pods: %v", err)
+	}
+	if m.Launcher == fuse.LauncherSystemd {
+		// a failed scope keeps its name until it is reset
+		commandExecutor.Run(context.Background(), executor.NewCommand("systemctl", "reset-failed", m.Unit))
+	} else if m.Launcher == fuse.LauncherProcess {
+		fuse.RemoveScopeCgroup(CgroupRoot, m.CgroupParent, m.Unit)
+	}
+	stage := filepath.Join(RestartStageDir, m.Unit)
+	if err := os.MkdirAll(stage, 0700); err != nil {
+		return err
+	}
+	defer os.Remove(stage)
+	staged := *m
+	staged.Target = stage
+	ctx, cancel := context.WithTimeout(context.Background(), connector.DefaultTimeout)
+	defer cancel()
+	c, cleanup, err := prepareMount(&staged)
+	if err != nil {
+		return err
+	}
+	if resp := waitCommand(ctx, c, cleanup); resp.Code != connector.CodeOK {
+		return resp.Err()
+	}
+	// the bind over the disconnected mount propagates to its peers and slaves, the stage is detached
+	// and the fuse process serves the target only
+	err = syscall.Mount(stage, m.Target, "", syscall.MS_BIND, "")
+	syscall.Unmount(stage, syscall.MNT_DETACH)
+	if err != nil {
+		return fmt.Errorf("bind %s over %s: %v", stage, m.Target, err)
+	}
+	return nil
+}
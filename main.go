@@ -29,6 +29,8 @@ import (
 	"time"
 
 	"github.com/prometheus/common/version"
+	"wujunyi792/oss-csi-lite-plugin/pkg/audit"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
 	csilog "wujunyi792/oss-csi-lite-plugin/pkg/log"
 	"wujunyi792/oss-csi-lite-plugin/pkg/metric"
 	"wujunyi792/oss-csi-lite-plugin/pkg/om"
@@ -81,6 +83,11 @@ type globalMetricConfig struct {
 
 // Nas CSI Plugin
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == fuse.JoinCgroupArg {
+		// the plugin starts fuse processes of the process launcher in nsenter mode like the connector
+		fuse.JoinCgroupAndExec(os.Args[2:])
+		return
+	}
 	flag.Parse()
 	serviceType := os.Getenv(utils.ServiceType)
 
@@ -95,6 +102,9 @@ func main() {
 		logAttribute = TypePluginSuffix
 	}
 	csilog.NewLogger(logAttribute)
+	if _, err := audit.SetupDefault(); err != nil {
+		csilog.Log.Errorf("Open audit log is failed, host commands are not audited, err: %v", err)
+	}
 
 	// When serviceType is neither plugin nor provisioner, the program will exits.
 	if serviceType != utils.PluginService && serviceType != utils.ProvisionerService {
@@ -165,6 +175,9 @@ func main() {
 
 	http.HandleFunc("/healthz", healthHandler)
 	csilog.Log.Infof("Metric listening on address: /healthz")
+	if address := audit.StreamAddress(); address != "" && audit.Default() != nil {
+		go serveAuditStream(address, audit.Default())
+	}
 	if metricConfig.enableMetric {
 		metricHandler := metric.NewMetricHandler(metricConfig.serviceType)
 		http.Handle("/metrics", metricHandler)
@@ -188,5 +201,20 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	time := time.Now()
 	message := "Liveness probe is OK, time:" + time.String()
+	state := utils.HostExecState()
+	message += fmt.Sprintf("\nHost exec mode: %s, executor: %s", state.Mode, state.Executor)
+	if state.Reason != "" {
+		message += fmt.Sprintf(", reason: %s, since: %s", state.Reason, state.Time)
+	}
 	w.Write([]byte(message))
 }
+
+// serveAuditStream stream the audit records at audit.StreamPath of address, a loopback address
+func serveAuditStream(address string, logger *audit.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle(audit.StreamPath, logger)
+	csilog.Log.Infof("Audit stream listening on address: %s%s", address, audit.StreamPath)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		csilog.Log.Errorf("Audit stream listen and serve err: %s", err.Error())
+	}
+}
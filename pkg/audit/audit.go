@@ -0,0 +1,253 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+	csilog "wujunyi792/oss-csi-lite-plugin/pkg/log"
+)
+
+const (
+	// SourceConnector is an action run by the host connector
+	SourceConnector = "connector"
+	// SourcePlugin is an action run by the plugin itself, on the host through nsenter or in its container
+	SourcePlugin = "plugin"
+
+	// ActionMount is a typed fuse mount
+	ActionMount = "mount"
+	// ActionCommand is a command line
+	ActionCommand = "command"
+	// ActionLegacy is a command line of the legacy connector protocol
+	ActionLegacy = "legacy"
+	// ActionDisk is a filesystem freeze command of the disk socket
+	ActionDisk = "disk"
+	// ActionRejected is a caller refused by the connector
+	ActionRejected = "rejected"
+
+	// StreamPath is where the records are streamed as JSON lines
+	StreamPath = "/audit"
+	// streamBuffer is the number of records buffered for a slow stream before records are dropped
+	streamBuffer = 256
+)
+
+// Caller is the process which asked for an action
+type Caller struct {
+	PID     int32    `json:"pid"`
+	UID     uint32   `json:"uid"`
+	GID     uint32   `json:"gid"`
+	Cgroups []string `json:"cgroups,omitempty"`
+}
+
+// Record is a privileged action run on the node
+type Record struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Action   string    `json:"action"`
+	Caller   *Caller   `json:"caller,omitempty"`
+	VolumeID string    `json:"volumeId,omitempty"`
+	// Pod is the namespace/name of the pod the action is for
+	Pod string `json:"pod,omitempty"`
+	// PodUID is the uid of the pod the action is for, when only its volume target is known
+	PodUID string `json:"podUid,omitempty"`
+	// Argv is the redacted command
+	Argv []string `json:"argv,omitempty"`
+	// Code is the result of a connector request
+	Code     string `json:"code,omitempty"`
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+	// DurationSeconds is how long the command ran
+	DurationSeconds float64 `json:"durationSeconds"`
+	// OutputBytes and OutputDigest are the size and the sha256 of the stdout, which is not recorded
+	OutputBytes  int    `json:"outputBytes"`
+	OutputDigest string `json:"outputDigest,omitempty"`
+	// StderrBytes and StderrDigest are the size and the sha256 of the stderr
+	StderrBytes  int    `json:"stderrBytes,omitempty"`
+	StderrDigest string `json:"stderrDigest,omitempty"`
+}
+
+// SetArgv set the command of the record with its secrets redacted
+func (r *Record) SetArgv(argv ...string) {
+	r.Argv = make([]string, len(argv))
+	for i, arg := range argv {
+		r.Argv[i] = csilog.Redact(arg)
+	}
+}
+
+// SetOutput set the size and the digest of each output stream of the command
+func (r *Record) SetOutput(stdout, stderr string) {
+	r.OutputBytes, r.OutputDigest = len(stdout), digest(stdout)
+	r.StderrBytes, r.StderrDigest = len(stderr), digest(stderr)
+}
+
+// digest return the sha256 of an output, empty for no output
+func digest(out string) string {
+	if out == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(out))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Finish set the duration since start, the exit code and the error of the record
+func (r *Record) Finish(start time.Time, exitCode int, err error) {
+	r.Time, r.DurationSeconds, r.ExitCode = start, time.Since(start).Seconds(), exitCode
+	if err != nil {
+		r.Error = csilog.Redact(err.Error())
+	}
+}
+
+// Logger write records as JSON lines to a file rotated daily, and to the streams subscribed
+type Logger struct {
+	lock    sync.Mutex
+	writer  io.Writer
+	streams map[chan []byte]bool
+}
+
+// NewLogger create a logger writing to path, rotated files are path-<date> and path links to the current one
+func NewLogger(path string) (*Logger, error) {
+	writer, err := rotatelogs.New(
+		path+"-%Y-%m-%d",
+		rotatelogs.WithLinkName(path),
+		rotatelogs.WithMaxAge(30*24*time.Hour),
+		rotatelogs.WithRotationTime(24*time.Hour),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterLogger(writer), nil
+}
+
+// NewWriterLogger create a logger writing to w
+func NewWriterLogger(w io.Writer) *Logger {
+	return &Logger{writer: w, streams: map[chan []byte]bool{}}
+}
+
+// Log write a record, a nil logger discards it
+func (l *Logger) Log(r *Record) {
+	if l == nil {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.writer.Write(line)
+	for stream := range l.streams {
+		select {
+		case stream <- line:
+		default:
+			// a slow stream misses records rather than blocking the actions
+		}
+	}
+}
+
+// subscribe return a stream of the records logged from now on, and the function to stop it
+func (l *Logger) subscribe() (<-chan []byte, func()) {
+	stream := make(chan []byte, streamBuffer)
+	l.lock.Lock()
+	l.streams[stream] = true
+	l.lock.Unlock()
+	return stream, func() {
+		l.lock.Lock()
+		delete(l.streams, stream)
+		l.lock.Unlock()
+	}
+}
+
+// ServeHTTP stream the records logged while the request is open as JSON lines
+func (l *Logger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	stream, stop := l.subscribe()
+	defer stop()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case line := <-stream:
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+const (
+	// LogFileEnv is the audit log of the plugin, empty to disable it
+	LogFileEnv = "AUDIT_LOG_FILE"
+	// DefaultLogFile is the audit log of the plugin on the host log directory
+	DefaultLogFile = "/var/log/alicloud/csi_plugin_audit.log"
+	// StreamEnv set to true serves the stream of the plugin at StreamPath of StreamAddress
+	StreamEnv = "AUDIT_STREAM"
+	// StreamPortEnv is the loopback port of the stream of the plugin
+	StreamPortEnv = "AUDIT_STREAM_PORT"
+	// defaultStreamPort is the stream port of the plugin by default
+	defaultStreamPort = "11262"
+)
+
+// defaultLogger is the audit logger of the plugin, set by SetupDefault
+var defaultLogger *Logger
+
+// SetupDefault create the audit logger of the plugin configured by LogFileEnv, nothing is audited until
+// it is called or if LogFileEnv is set empty
+func SetupDefault() (*Logger, error) {
+	path, set := os.LookupEnv(LogFileEnv)
+	if !set {
+		path = DefaultLogFile
+	}
+	if path = strings.TrimSpace(path); path == "" {
+		return nil, nil
+	}
+	logger, err := NewLogger(path)
+	if err != nil {
+		return nil, err
+	}
+	defaultLogger = logger
+	return logger, nil
+}
+
+// Default return the audit logger of the plugin, nil before SetupDefault
+func Default() *Logger {
+	return defaultLogger
+}
+
+// SetDefault replace the audit logger of the plugin and return the previous one, for tests
+func SetDefault(logger *Logger) *Logger {
+	previous := defaultLogger
+	defaultLogger = logger
+	return previous
+}
+
+// StreamAddress return the address the plugin serves the stream of its records at, empty if it is disabled.
+// It is a loopback address as the stream is not authenticated and the plugin runs in the host network.
+func StreamAddress() string {
+	if strings.TrimSpace(os.Getenv(StreamEnv)) != "true" {
+		return ""
+	}
+	port := strings.TrimSpace(os.Getenv(StreamPortEnv))
+	if port == "" {
+		port = defaultStreamPort
+	}
+	return "127.0.0.1:" + port
+}
@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	r := &Record{Source: SourcePlugin, Action: ActionMount}
+	r.SetArgv("/usr/local/bin/ossfs", "bucket:/", "/mnt", "-oakSecret=secret")
+	assert.Equal(t, "-oakSecret=******", r.Argv[3])
+	r.SetOutput("", "")
+	assert.Equal(t, 0, r.OutputBytes)
+	assert.Equal(t, "", r.OutputDigest)
+	assert.Equal(t, "", r.StderrDigest)
+	r.SetOutput("out", "err")
+	assert.Equal(t, 3, r.OutputBytes)
+	assert.Equal(t, 3, r.StderrBytes)
+	stdout, stderr := r.OutputDigest, r.StderrDigest
+	assert.Equal(t, len("sha256:")+64, len(stdout))
+	assert.NotEqual(t, stdout, stderr)
+	// the streams are hashed apart, moving bytes from one to the other changes the digests
+	r.SetOutput("o", "uterr")
+	assert.NotEqual(t, stdout, r.OutputDigest)
+	assert.NotEqual(t, stderr, r.StderrDigest)
+	start := time.Now().Add(-time.Second)
+	r.Finish(start, 1, errors.New("token=abc"))
+	assert.Equal(t, start, r.Time)
+	assert.True(t, r.DurationSeconds >= 1)
+	assert.Equal(t, "token=******", r.Error)
+}
+
+func TestLogger(t *testing.T) {
+	var nilLogger *Logger
+	nilLogger.Log(&Record{})
+
+	var buf bytes.Buffer
+	logger := NewWriterLogger(&buf)
+	server := httptest.NewServer(logger)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	logger.Log(&Record{Source: SourceConnector, Action: ActionCommand, Argv: []string{"echo"}})
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, buf.String(), line)
+	r := &Record{}
+	assert.Nil(t, json.Unmarshal([]byte(line), r))
+	assert.Equal(t, ActionCommand, r.Action)
+	assert.False(t, r.Time.IsZero())
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+}
+
+func TestStreamAddress(t *testing.T) {
+	t.Setenv(StreamEnv, "")
+	assert.Equal(t, "", StreamAddress())
+	t.Setenv(StreamEnv, "true")
+	assert.Equal(t, "127.0.0.1:"+defaultStreamPort, StreamAddress())
+	t.Setenv(StreamPortEnv, "12000")
+	assert.Equal(t, "127.0.0.1:12000", StreamAddress())
+}
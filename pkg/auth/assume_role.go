@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+)
+
+const (
+	// STSEndpointEnv overrides the STS endpoint, a host name or a URL such as http://127.0.0.1:8080
+	STSEndpointEnv = "STS_ENDPOINT"
+	// DefaultSTSEndpoint is the public endpoint of STS
+	DefaultSTSEndpoint = "sts.aliyuncs.com"
+	// DefaultRoleDuration is how long an assumed role credential is valid
+	DefaultRoleDuration = time.Hour
+	// RoleSessionName is the session name of roles assumed by the plugin
+	RoleSessionName = "oss-csi-plugin"
+	// stsRegion only initializes the sdk client, requests are sent to the configured endpoint
+	stsRegion = "cn-hangzhou"
+)
+
+// STSEndpoint return the STS endpoint configured by STSEndpointEnv
+func STSEndpoint() string {
+	if endpoint := strings.TrimSpace(os.Getenv(STSEndpointEnv)); endpoint != "" {
+		return endpoint
+	}
+	return DefaultSTSEndpoint
+}
+
+// AssumeRole call STS AssumeRole at endpoint with a base AccessKey
+func AssumeRole(endpoint, akID, akSecret, roleArn string, duration time.Duration) (*Credential, error) {
+	client, err := sts.NewClientWithAccessKey(stsRegion, akID, akSecret)
+	if err != nil {
+		return nil, err
+	}
+	request := sts.CreateAssumeRoleRequest()
+	request.Scheme = "https"
+	request.Domain = endpoint
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		request.Scheme = u.Scheme
+		request.Domain = u.Host
+	}
+	request.RoleArn = roleArn
+	request.RoleSessionName = RoleSessionName
+	request.DurationSeconds = requests.NewInteger(int(duration.Seconds()))
+	response, err := client.AssumeRole(request)
+	if err != nil {
+		return nil, fmt.Errorf("assume role %s: %v", roleArn, err)
+	}
+	c := response.Credentials
+	if c.AccessKeyId == "" || c.SecurityToken == "" {
+		return nil, fmt.Errorf("assume role %s: empty credential in response %s", roleArn, response.RequestId)
+	}
+	expiration, err := time.Parse(time.RFC3339, c.Expiration)
+	if err != nil {
+		return nil, fmt.Errorf("assume role %s: invalid expiration %q", roleArn, c.Expiration)
+	}
+	return &Credential{AccessKeyID: c.AccessKeyId, AccessKeySecret: c.AccessKeySecret, SecurityToken: c.SecurityToken, Expiration: expiration}, nil
+}
+
+// assumeRoleSource assume a ram role with a base AccessKey
+type assumeRoleSource struct {
+	endpoint string
+	akID     string
+	akSecret string
+	roleArn  string
+	duration time.Duration
+}
+
+// NewAssumeRoleSource return the source of the credential of roleArn assumed with a base AccessKey
+func NewAssumeRoleSource(akID, akSecret, roleArn string) CredentialSource {
+	return &assumeRoleSource{endpoint: STSEndpoint(), akID: akID, akSecret: akSecret, roleArn: roleArn, duration: DefaultRoleDuration}
+}
+
+func (s *assumeRoleSource) Key() string {
+	return "role/" + s.akID + "/" + s.roleArn
+}
+
+func (s *assumeRoleSource) Mode() string {
+	return ModeRAMRoleArn
+}
+
+func (s *assumeRoleSource) Load() (*Credential, error) {
+	if s.akID == "" || s.akSecret == "" || s.roleArn == "" {
+		return nil, errors.New("assume role: AccessKey and roleArn are required")
+	}
+	return AssumeRole(s.endpoint, s.akID, s.akSecret, s.roleArn, s.duration)
+}
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssumeRole(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "AssumeRole", r.URL.Query().Get("Action"))
+		assert.Equal(t, "acs:ram::123:role/oss", r.URL.Query().Get("RoleArn"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"RequestId": "1", "Credentials": {"AccessKeyId": "STS.id", "AccessKeySecret": "secret", "SecurityToken": "token", "Expiration": "%s"}}`, expiration)
+	}))
+	defer stub.Close()
+
+	cred, err := AssumeRole(stub.URL, "ak", "sk", "acs:ram::123:role/oss", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.id", cred.AccessKeyID)
+	assert.Equal(t, "token", cred.SecurityToken)
+	assert.Equal(t, expiration, cred.Expiration.Format(time.RFC3339))
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// ModeAccessKey is a static AccessKey of a secret or the volume attributes
+	ModeAccessKey = "accesskey"
+	// ModeEnvAccessKey is the static AccessKey of the plugin environment
+	ModeEnvAccessKey = "env"
+	// ModeSTS is the managed token of the addon secret or the ram role of the node
+	ModeSTS = "sts"
+	// ModeRAMRoleArn is a ram role assumed with a base AccessKey
+	ModeRAMRoleArn = "ramrolearn"
+	// ModeOIDC is a ram role assumed with a service account token
+	ModeOIDC = "oidc"
+
+	// RenewPeriod is the interval to renew credentials about to expire in the background
+	RenewPeriod = time.Minute
+	// refreshBefore is how long before its expiration a temporary credential is renewed
+	refreshBefore = 15 * time.Minute
+	// refreshJitter spreads the renewals of credentials loaded at the same time
+	refreshJitter = 5 * time.Minute
+	// idleTimeout evicts credentials no volume asked for in a while
+	idleTimeout = 2 * time.Hour
+)
+
+// Credential is the credential of an auth mode, temporary credentials have an expiration
+type Credential struct {
+	AccessKeyID     string
+	AccessKeySecret string
+	SecurityToken   string
+	Expiration      time.Time
+}
+
+// Temporary return if the credential expires
+func (c *Credential) Temporary() bool {
+	return !c.Expiration.IsZero()
+}
+
+// CredentialSource load the credential of an auth mode
+type CredentialSource interface {
+	// Key identify the credential in the cache, by the secret, role ARN or service account it comes from
+	Key() string
+	// Mode return the auth mode of the credential
+	Mode() string
+	// Load fetch a new credential
+	Load() (*Credential, error)
+}
+
+type cacheEntry struct {
+	// source is the latest source of the key, it carries the latest base AccessKey or token
+	source     CredentialSource
+	credential *Credential
+	renewAt    time.Time
+	lastUsed   time.Time
+}
+
+type inflight struct {
+	wg         sync.WaitGroup
+	credential *Credential
+	err        error
+}
+
+// CredentialCache keep temporary credentials by key and renew them before they expire.
+// Concurrent loads of one key share a single call of its source.
+type CredentialCache struct {
+	lock    sync.Mutex
+	entries map[string]*cacheEntry
+	loading map[string]*inflight
+	now     func() time.Time
+	jitter  func() time.Duration
+}
+
+// NewCredentialCache create an empty credential cache
+func NewCredentialCache() *CredentialCache {
+	return &CredentialCache{
+		entries: map[string]*cacheEntry{},
+		loading: map[string]*inflight{},
+		now:     time.Now,
+		jitter: func() time.Duration {
+			return time.Duration(rand.Int63n(int64(refreshJitter)))
+		},
+	}
+}
+
+var defaultCache = NewCredentialCache()
+
+// DefaultCache return the credential cache shared by the plugin
+func DefaultCache() *CredentialCache {
+	return defaultCache
+}
+
+// Get return the credential of source, static credentials are loaded every time as their sources are cheap
+func (c *CredentialCache) Get(source CredentialSource) (*Credential, error) {
+	key := source.Key()
+	c.lock.Lock()
+	if entry, ok := c.entries[key]; ok {
+		entry.source = source
+		entry.lastUsed = c.now()
+		if entry.credential != nil && entry.credential.Temporary() && c.now().Before(entry.renewAt) {
+			credential := entry.credential
+			c.lock.Unlock()
+			return credential, nil
+		}
+	}
+	c.lock.Unlock()
+	return c.load(key, source)
+}
+
+func (c *CredentialCache) load(key string, source CredentialSource) (*Credential, error) {
+	c.lock.Lock()
+	if call, ok := c.loading[key]; ok {
+		c.lock.Unlock()
+		call.wg.Wait()
+		return call.credential, call.err
+	}
+	call := &inflight{}
+	call.wg.Add(1)
+	c.loading[key] = call
+	c.lock.Unlock()
+
+	credential, err := source.Load()
+
+	c.lock.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &cacheEntry{source: source, lastUsed: c.now()}
+		c.entries[key] = entry
+	}
+	if err == nil {
+		entry.credential = credential
+		entry.renewAt = credential.Expiration.Add(-refreshBefore - c.jitter())
+	} else if entry.credential != nil && c.now().Before(entry.credential.Expiration) {
+		// keep serving the cached credential while it is still valid
+		credential, err = entry.credential, nil
+	}
+	call.credential, call.err = credential, err
+	delete(c.loading, key)
+	c.lock.Unlock()
+	call.wg.Done()
+	return credential, err
+}
+
+// Renew renew the temporary credentials about to expire and evict the ones unused for a while
+func (c *CredentialCache) Renew() {
+	due := map[string]CredentialSource{}
+	c.lock.Lock()
+	for key, entry := range c.entries {
+		if c.now().Sub(entry.lastUsed) > idleTimeout {
+			delete(c.entries, key)
+			continue
+		}
+		if entry.credential != nil && entry.credential.Temporary() && !c.now().Before(entry.renewAt) {
+			due[key] = entry.source
+		}
+	}
+	c.lock.Unlock()
+	for key, source := range due {
+		c.load(key, source)
+	}
+}
+
+// Forget drop the credential of key
+func (c *CredentialCache) Forget(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, key)
+}
+
+// CredentialLifetime is the remaining lifetime of a cached temporary credential
+type CredentialLifetime struct {
+	Key       string
+	Mode      string
+	Remaining time.Duration
+}
+
+// Lifetimes return the remaining lifetime of every cached temporary credential
+func (c *CredentialCache) Lifetimes() []CredentialLifetime {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var lifetimes []CredentialLifetime
+	for key, entry := range c.entries {
+		if entry.credential == nil || !entry.credential.Temporary() {
+			continue
+		}
+		lifetimes = append(lifetimes, CredentialLifetime{Key: key, Mode: entry.source.Mode(), Remaining: entry.credential.Expiration.Sub(c.now())})
+	}
+	sort.Slice(lifetimes, func(i, j int) bool { return lifetimes[i].Key < lifetimes[j].Key })
+	return lifetimes
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource struct {
+	calls int32
+	fail  bool
+	ttl   time.Duration
+	delay time.Duration
+}
+
+func (s *fakeSource) Key() string  { return "fake" }
+func (s *fakeSource) Mode() string { return ModeRAMRoleArn }
+func (s *fakeSource) Load() (*Credential, error) {
+	n := atomic.AddInt32(&s.calls, 1)
+	time.Sleep(s.delay)
+	if s.fail {
+		return nil, errors.New("sts is unavailable")
+	}
+	return &Credential{AccessKeyID: fmt.Sprintf("STS.%d", n), Expiration: time.Now().Add(s.ttl)}, nil
+}
+
+func TestCredentialCache(t *testing.T) {
+	c := NewCredentialCache()
+	c.jitter = func() time.Duration { return 0 }
+	source := &fakeSource{ttl: time.Hour}
+
+	cred, err := c.Get(source)
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.1", cred.AccessKeyID)
+	cred, _ = c.Get(source)
+	assert.Equal(t, "STS.1", cred.AccessKeyID)
+
+	// renewed in the background once it is about to expire
+	c.Renew()
+	assert.Equal(t, int32(1), source.calls)
+	c.now = func() time.Time { return time.Now().Add(50 * time.Minute) }
+	c.Renew()
+	assert.Equal(t, int32(2), source.calls)
+
+	// a failed renewal keeps the credential which is still valid
+	source.fail = true
+	c.Renew()
+	cred, err = c.Get(source)
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.2", cred.AccessKeyID)
+
+	lifetimes := c.Lifetimes()
+	assert.Equal(t, 1, len(lifetimes))
+	assert.Equal(t, ModeRAMRoleArn, lifetimes[0].Mode)
+
+	// evicted when no volume asks for it
+	c.now = func() time.Time { return time.Now().Add(3 * time.Hour) }
+	c.Renew()
+	assert.Equal(t, 0, len(c.Lifetimes()))
+}
+
+func TestCredentialCacheSingleFlight(t *testing.T) {
+	c := NewCredentialCache()
+	source := &fakeSource{ttl: time.Hour, delay: 50 * time.Millisecond}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cred, err := c.Get(source)
+			assert.Nil(t, err)
+			assert.Equal(t, "STS.1", cred.AccessKeyID)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), source.calls)
+}
+
+func TestAccessKeySource(t *testing.T) {
+	c := NewCredentialCache()
+	cred, err := c.Get(NewAccessKeySource("ak", "sk1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "sk1", cred.AccessKeySecret)
+	// static credentials follow the secret at once
+	cred, _ = c.Get(NewAccessKeySource("ak", "sk2"))
+	assert.Equal(t, "sk2", cred.AccessKeySecret)
+	assert.Equal(t, 0, len(c.Lifetimes()))
+	_, err = c.Get(NewAccessKeySource("", ""))
+	assert.NotNil(t, err)
+}
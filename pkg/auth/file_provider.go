@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// CredentialFileDirEnv is the directory of the key files of the file provider
+	CredentialFileDirEnv = "CREDENTIAL_FILE_DIR"
+	// DefaultCredentialFileDir is where the key files are mounted, usually from a Secret
+	DefaultCredentialFileDir = "/etc/oss-csi/keys"
+	// defaultKeyFile is the key file of buckets without their own
+	defaultKeyFile = "default"
+)
+
+// FileProviderDir return the directory of key files configured by CredentialFileDirEnv
+func FileProviderDir() string {
+	if dir := strings.TrimSpace(os.Getenv(CredentialFileDirEnv)); dir != "" {
+		return dir
+	}
+	return DefaultCredentialFileDir
+}
+
+// fileProvider supply the AccessKeys of the key files in a directory. The file named after
+// the bucket holds akId:akSecret of the bucket, the file named default is used for other buckets.
+// The files are read again whenever the directory changes, rotated keys apply to the next publish.
+type fileProvider struct {
+	dir string
+
+	lock sync.RWMutex
+	keys map[string]*AccessKey
+}
+
+// NewFileProvider create a provider of the key files in dir and watch dir for changes
+func NewFileProvider(dir string) (CredentialProvider, error) {
+	p := &fileProvider{dir: dir}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	if err := watchDir(dir, func() {
+		if err := p.reload(); err != nil {
+			log.Errorf("FileProvider: reload key files in %s is failed, err: %v", dir, err)
+		}
+	}); err != nil {
+		return nil, fmt.Errorf("watch key files in %s: %v", dir, err)
+	}
+	return p, nil
+}
+
+func (p *fileProvider) Name() string {
+	return ProviderFile
+}
+
+func (p *fileProvider) Provide(req *VolumeRequest) (*AccessKey, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if ak, ok := p.keys[req.Bucket]; ok {
+		return ak, nil
+	}
+	return p.keys[defaultKeyFile], nil
+}
+
+// reload read every key file of the directory, files starting with a dot are skipped as
+// the Secret volumes of kubelet keep their data in hidden directories behind symlinks
+func (p *fileProvider) reload() error {
+	files, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return err
+	}
+	keys := map[string]*AccessKey{}
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		raw, err := ioutil.ReadFile(filepath.Join(p.dir, name))
+		if err != nil {
+			// a directory or a file removed since it was listed
+			continue
+		}
+		ak, err := parseKeyFile(raw)
+		if err != nil {
+			log.Warnf("FileProvider: skip key file %s, err: %v", name, err)
+			continue
+		}
+		keys[name] = ak
+	}
+	p.lock.Lock()
+	p.keys = keys
+	p.lock.Unlock()
+	log.Infof("FileProvider: loaded %d key files from %s", len(keys), p.dir)
+	return nil
+}
+
+// parseKeyFile parse the akId:akSecret content of a key file
+func parseKeyFile(raw []byte) (*AccessKey, error) {
+	parts := strings.SplitN(strings.TrimSpace(string(raw)), ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return nil, fmt.Errorf("content is not akId:akSecret")
+	}
+	return &AccessKey{ID: strings.TrimSpace(parts[0]), Secret: strings.TrimSpace(parts[1])}, nil
+}
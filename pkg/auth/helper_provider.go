@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// CredentialHelperURLEnv is the url of the credential helper, such as http://127.0.0.1:8181/credentials
+	CredentialHelperURLEnv = "CREDENTIAL_HELPER_URL"
+
+	helperTimeout = 10 * time.Second
+)
+
+// helperRequest is the body the credential helper is posted for each volume. Volume attributes are not sent,
+// they may carry keys of other providers.
+type helperRequest struct {
+	VolumeID string `json:"volumeId"`
+	Bucket   string `json:"bucket"`
+	URL      string `json:"url"`
+	Path     string `json:"path"`
+}
+
+// helperResponse is the AccessKey answered by the credential helper
+type helperResponse struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+}
+
+// helperProvider ask a local credential helper for the AccessKey of a volume. The helper answers
+// 200 with the AccessKey, or 404 when it has none and the next provider is looked up.
+type helperProvider struct {
+	url    string
+	client *http.Client
+}
+
+// NewHelperProvider create a provider asking the credential helper at url
+func NewHelperProvider(url string) CredentialProvider {
+	return &helperProvider{url: url, client: &http.Client{Timeout: helperTimeout}}
+}
+
+func (p *helperProvider) Name() string {
+	return ProviderHelper
+}
+
+func (p *helperProvider) Provide(req *VolumeRequest) (*AccessKey, error) {
+	body, _ := json.Marshal(helperRequest{VolumeID: req.VolumeID, Bucket: req.Bucket, URL: req.attribute("url"), Path: req.attribute("path")})
+	resp, err := p.client.Post(p.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d, %s", resp.StatusCode, string(raw))
+	}
+	answer := helperResponse{}
+	if err := json.Unmarshal(raw, &answer); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	if answer.AccessKeyID == "" || answer.AccessKeySecret == "" {
+		return nil, fmt.Errorf("empty AccessKey in response")
+	}
+	return &AccessKey{ID: answer.AccessKeyID, Secret: answer.AccessKeySecret}, nil
+}
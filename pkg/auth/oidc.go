@@ -1,11 +1,35 @@
 package auth
 
 import (
-	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+)
+
+const (
+	// OIDCTokenFileEnv is the projected service account token of the plugin itself
+	OIDCTokenFileEnv = "ALIBABA_CLOUD_OIDC_TOKEN_FILE"
+	// DefaultOIDCTokenFile is where the service account token of the plugin is projected
+	DefaultOIDCTokenFile = "/var/run/secrets/ack.alibabacloud.com/rrsa-tokens/token"
+	// OIDCTokenAudience is the audience of the service account tokens exchanged with STS
+	OIDCTokenAudience = "sts.aliyuncs.com"
+
+	stsAPIVersion = "2015-04-01"
+	oidcTimeout   = 10 * time.Second
 )
 
 var _ Provider = &oidcProvider{}
+var _ CredentialSource = &oidcProvider{}
 
 type Provider interface {
 	AssumeRoleWithOIDC() (*sts.AssumeRoleWithOIDCResponse, error)
@@ -14,38 +38,204 @@ type Provider interface {
 	GetClient() (*Client, error)
 }
 
-type oidcProvider struct{}
+type oidcProvider struct {
+	region      string
+	endpoint    string
+	roleArn     string
+	providerArn string
+	sessionName string
+	// subject is the service account whose token is exchanged
+	subject  string
+	duration time.Duration
+	// token return the service account token exchanged for role credentials
+	token func() (string, error)
+
+	lock       sync.Mutex
+	response   *sts.AssumeRoleWithOIDCResponse
+	expiration time.Time
+	client     *Client
+	// clientAccessKeyID is the AccessKey of the credential client is created with
+	clientAccessKeyID string
+}
 
 // NewOIDCProvider create a new OIDC Provider
 func NewOIDCProvider(region, appName, providerName, roleName, UUID, network string, durationSeconds time.Duration) *oidcProvider {
-	return nil
+	endpoint := STSEndpoint()
+	if network == "vpc" && os.Getenv(STSEndpointEnv) == "" {
+		endpoint = fmt.Sprintf("sts-vpc.%s.aliyuncs.com", region)
+	}
+	roleArn := fmt.Sprintf("acs:ram::%s:role/%s", UUID, roleName)
+	providerArn := fmt.Sprintf("acs:ram::%s:oidc-provider/%s", UUID, providerName)
+	return NewOIDCProviderWithToken(region, endpoint, roleArn, providerArn, appName, appName, durationSeconds, tokenFile)
 }
 
 // NewOIDCProviderVPC create a new OIDC Provider with VPC network
 func NewOIDCProviderVPC(region, appName, providerName, roleName, UUID string, durationSeconds time.Duration) *oidcProvider {
-	return nil
+	return NewOIDCProvider(region, appName, providerName, roleName, UUID, "vpc", durationSeconds)
+}
+
+// NewOIDCProviderWithToken create a new OIDC Provider exchanging the tokens of the service account subject
+// returned by token, such as the service account tokens of pods delivered to NodePublishVolume
+func NewOIDCProviderWithToken(region, endpoint, roleArn, providerArn, sessionName, subject string, durationSeconds time.Duration, token func() (string, error)) *oidcProvider {
+	if durationSeconds <= 0 {
+		durationSeconds = DefaultRoleDuration
+	}
+	return &oidcProvider{
+		region:      region,
+		endpoint:    endpoint,
+		roleArn:     roleArn,
+		providerArn: providerArn,
+		sessionName: sessionName,
+		subject:     subject,
+		duration:    durationSeconds,
+		token:       token,
+	}
 }
 
-// AssumeRoleWithOIDC assume role with OIDC provider, return sts token
+// tokenFile read the service account token of the plugin
+func tokenFile() (string, error) {
+	path := os.Getenv(OIDCTokenFileEnv)
+	if path == "" {
+		path = DefaultOIDCTokenFile
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// AssumeRoleWithOIDC assume role with OIDC provider, return sts token.
+// The request is anonymous, the service account token is the proof of identity.
 func (p *oidcProvider) AssumeRoleWithOIDC() (*sts.AssumeRoleWithOIDCResponse, error) {
-	return nil, nil
+	token, err := p.token()
+	if err != nil {
+		return nil, fmt.Errorf("read oidc token: %v", err)
+	}
+	if token == "" {
+		return nil, errors.New("read oidc token: empty token")
+	}
+	scheme, host := "https", p.endpoint
+	if u, err := url.Parse(p.endpoint); err == nil && u.Host != "" {
+		scheme, host = u.Scheme, u.Host
+	}
+	form := url.Values{
+		"Action":          {"AssumeRoleWithOIDC"},
+		"Format":          {"JSON"},
+		"Version":         {stsAPIVersion},
+		"Timestamp":       {time.Now().UTC().Format("2006-01-02T15:04:05Z")},
+		"RoleArn":         {p.roleArn},
+		"OIDCProviderArn": {p.providerArn},
+		"OIDCToken":       {token},
+		"RoleSessionName": {p.sessionName},
+		"DurationSeconds": {strconv.Itoa(int(p.duration.Seconds()))},
+	}
+	client := &http.Client{Timeout: oidcTimeout}
+	resp, err := client.PostForm(fmt.Sprintf("%s://%s/", scheme, host), form)
+	if err != nil {
+		return nil, fmt.Errorf("assume role %s with oidc: %v", p.roleArn, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("assume role %s with oidc: status %d, %s", p.roleArn, resp.StatusCode, string(body))
+	}
+	response := sts.CreateAssumeRoleWithOIDCResponse()
+	if err := json.Unmarshal(body, response); err != nil {
+		return nil, fmt.Errorf("assume role %s with oidc: %v", p.roleArn, err)
+	}
+	if response.Credentials.AccessKeyId == "" {
+		return nil, fmt.Errorf("assume role %s with oidc: empty credential in response %s", p.roleArn, response.RequestId)
+	}
+	return response, nil
 }
 
 // GetStsTokenWithCache get sts token with OIDC provider, return assume role response
 func (p *oidcProvider) GetStsTokenWithCache() (*sts.AssumeRoleWithOIDCResponse, error) {
-	return nil, nil
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+	return p.response, nil
 }
 
 // NewClientWithStsToken create a new alibaba cloud client with sts token
 func (p *oidcProvider) NewClientWithStsToken(response *sts.AssumeRoleWithOIDCResponse) (*Client, error) {
-	return nil, nil
+	c := response.Credentials
+	return NewClientWithStsToken(p.region, c.AccessKeyId, c.AccessKeySecret, c.SecurityToken)
 }
 
 // GetClient get the alibaba cloud client, Note: it automatically refreshes the client
 func (p *oidcProvider) GetClient() (*Client, error) {
-	return nil, nil
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+	if err := p.refreshClient(); err != nil {
+		return nil, err
+	}
+	return p.client, nil
+}
+
+// refresh renew the cached credential shortly before it expires, a failed renewal
+// keeps the cached credential while it is still valid. The caller holds the lock.
+func (p *oidcProvider) refresh() error {
+	if p.response != nil && time.Until(p.expiration) > refreshBefore {
+		return nil
+	}
+	response, err := p.AssumeRoleWithOIDC()
+	if err == nil {
+		var expiration time.Time
+		if expiration, err = time.Parse(time.RFC3339, response.Credentials.Expiration); err == nil {
+			p.response, p.expiration = response, expiration
+			return nil
+		}
+		err = fmt.Errorf("assume role %s with oidc: invalid expiration %q", p.roleArn, response.Credentials.Expiration)
+	}
+	if p.response != nil && time.Now().Before(p.expiration) {
+		return nil
+	}
+	return err
 }
 
-func (p *oidcProvider) refresh() {}
+// refreshClient switch the client to the cached credential. The caller holds the lock.
+func (p *oidcProvider) refreshClient() error {
+	if p.client != nil && p.clientAccessKeyID == p.response.Credentials.AccessKeyId {
+		return nil
+	}
+	client, err := p.NewClientWithStsToken(p.response)
+	if err != nil {
+		return err
+	}
+	p.client, p.clientAccessKeyID = client, p.response.Credentials.AccessKeyId
+	return nil
+}
 
-func (p *oidcProvider) refreshClient() {}
+// Key return the key of the credential of the role and the service account in the credential cache
+func (p *oidcProvider) Key() string {
+	return ModeOIDC + "/" + p.roleArn + "/" + p.subject
+}
+
+// Mode return ModeOIDC
+func (p *oidcProvider) Mode() string {
+	return ModeOIDC
+}
+
+// Load exchange the service account token for a new credential of the role
+func (p *oidcProvider) Load() (*Credential, error) {
+	response, err := p.AssumeRoleWithOIDC()
+	if err != nil {
+		return nil, err
+	}
+	c := response.Credentials
+	expiration, err := time.Parse(time.RFC3339, c.Expiration)
+	if err != nil {
+		return nil, fmt.Errorf("assume role %s with oidc: invalid expiration %q", p.roleArn, c.Expiration)
+	}
+	return &Credential{AccessKeyID: c.AccessKeyId, AccessKeySecret: c.AccessKeySecret, SecurityToken: c.SecurityToken, Expiration: expiration}, nil
+}
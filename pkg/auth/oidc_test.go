@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOIDCProvider(t *testing.T) {
+	calls := 0
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Nil(t, r.ParseForm())
+		assert.Equal(t, "AssumeRoleWithOIDC", r.Form.Get("Action"))
+		assert.Equal(t, "acs:ram::123:oidc-provider/ack-rrsa-c1", r.Form.Get("OIDCProviderArn"))
+		assert.Equal(t, "pod-token", r.Form.Get("OIDCToken"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"RequestId": "1", "Credentials": {"AccessKeyId": "STS.%d", "AccessKeySecret": "secret", "SecurityToken": "token", "Expiration": "%s"}}`,
+			calls, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer stub.Close()
+
+	token := func() (string, error) { return "pod-token", nil }
+	p := NewOIDCProviderWithToken("cn-hangzhou", stub.URL, "acs:ram::123:role/oss", "acs:ram::123:oidc-provider/ack-rrsa-c1", "app", "default/app", time.Hour, token)
+	response, err := p.GetStsTokenWithCache()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.1", response.Credentials.AccessKeyId)
+	client, err := p.GetClient()
+	assert.Nil(t, err)
+	assert.NotNil(t, client)
+	assert.Equal(t, 1, calls)
+
+	// renewed shortly before it expires
+	p.expiration = time.Now().Add(time.Minute)
+	response, err = p.GetStsTokenWithCache()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.2", response.Credentials.AccessKeyId)
+	assert.Equal(t, "oidc/acs:ram::123:role/oss/default/app", p.Key())
+	cred, err := p.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.3", cred.AccessKeyID)
+
+	stub.Close()
+	p.expiration = time.Now().Add(time.Minute)
+	response, err = p.GetStsTokenWithCache()
+	assert.Nil(t, err, "a failed renewal keeps the valid credential")
+	assert.Equal(t, "STS.2", response.Credentials.AccessKeyId)
+}
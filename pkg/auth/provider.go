@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
+)
+
+const (
+	// CredentialProvidersEnv is the ordered, comma separated list of providers looked up for the AccessKey of a volume
+	CredentialProvidersEnv = "CREDENTIAL_PROVIDERS"
+	// DefaultCredentialProviders is the lookup order of the plugin before the providers were configurable
+	DefaultCredentialProviders = "attributes,secrets,env,managed"
+
+	// ProviderAttributes supplies the akId and akSecret of the volume attributes
+	ProviderAttributes = "attributes"
+	// ProviderSecrets supplies the akId and akSecret of the nodePublishSecretRef of the volume
+	ProviderSecrets = "secrets"
+	// ProviderEnv supplies the AccessKey in ACCESS_KEY_ID and ACCESS_KEY_SECRET of the plugin
+	ProviderEnv = "env"
+	// ProviderManaged supplies the temporary credential of the addon token secret, see utils.GetManagedToken
+	ProviderManaged = "managed"
+	// ProviderFile supplies the AccessKeys of the files in CREDENTIAL_FILE_DIR
+	ProviderFile = "file"
+	// ProviderKubernetes supplies the AccessKey of a Secret fetched by the node plugin
+	ProviderKubernetes = "kubernetes"
+	// ProviderHelper supplies the AccessKey answered by the credential helper at CREDENTIAL_HELPER_URL
+	ProviderHelper = "helper"
+
+	akIDKey     = "akId"
+	akSecretKey = "akSecret"
+)
+
+// VolumeRequest is what a credential provider knows about the volume asking for an AccessKey
+type VolumeRequest struct {
+	VolumeID string
+	Bucket   string
+	// Attributes is the volume context of the volume
+	Attributes map[string]string
+	// Secrets is the nodePublishSecretRef of the volume delivered by kubelet
+	Secrets map[string]string
+}
+
+// attribute return the volume attribute key, which is case insensitive like the other volume attributes
+func (r *VolumeRequest) attribute(key string) string {
+	for k, v := range r.Attributes {
+		if strings.EqualFold(k, key) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// AccessKey is the AccessKey of a volume, SecurityToken is only set for a temporary credential
+type AccessKey struct {
+	ID            string
+	Secret        string
+	SecurityToken string
+}
+
+// CredentialProvider supply the AccessKey of a volume. Provide return nil without an error
+// when the provider has no AccessKey of the volume, so that the next provider is looked up.
+type CredentialProvider interface {
+	Name() string
+	Provide(req *VolumeRequest) (*AccessKey, error)
+}
+
+// ProviderChain look up the providers in order, the first AccessKey found is used
+type ProviderChain struct {
+	providers []CredentialProvider
+}
+
+// NewProviderChain create a chain of providers
+func NewProviderChain(providers ...CredentialProvider) *ProviderChain {
+	return &ProviderChain{providers: providers}
+}
+
+// Names return the names of the providers in lookup order
+func (c *ProviderChain) Names() []string {
+	names := make([]string, 0, len(c.providers))
+	for _, p := range c.providers {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// Lookup return the AccessKey of the volume and the name of the provider supplying it, the AccessKey
+// is nil if no provider has one. An error of a provider fails the lookup instead of falling through,
+// a volume does not silently mount with a different AccessKey when an external source is unavailable.
+func (c *ProviderChain) Lookup(req *VolumeRequest) (*AccessKey, string, error) {
+	for _, p := range c.providers {
+		ak, err := p.Provide(req)
+		if err != nil {
+			return nil, p.Name(), fmt.Errorf("credential provider %s: %v", p.Name(), err)
+		}
+		if ak != nil && ak.ID != "" && ak.Secret != "" {
+			return ak, p.Name(), nil
+		}
+	}
+	return nil, "", nil
+}
+
+// ProvidersFromEnv create the provider chain configured by CredentialProvidersEnv,
+// clientSet is used by the kubernetes provider to fetch Secrets
+func ProvidersFromEnv(clientSet kubernetes.Interface) (*ProviderChain, error) {
+	names := strings.TrimSpace(os.Getenv(CredentialProvidersEnv))
+	if names == "" {
+		names = DefaultCredentialProviders
+	}
+	var providers []CredentialProvider
+	seen := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s: provider %s is listed twice", CredentialProvidersEnv, name)
+		}
+		seen[name] = true
+		switch name {
+		case ProviderAttributes:
+			providers = append(providers, attributesProvider{})
+		case ProviderSecrets:
+			providers = append(providers, secretsProvider{})
+		case ProviderEnv:
+			providers = append(providers, envProvider{})
+		case ProviderManaged:
+			providers = append(providers, managedProvider{tokens: utils.GetManagedToken})
+		case ProviderFile:
+			p, err := NewFileProvider(FileProviderDir())
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, p)
+		case ProviderKubernetes:
+			providers = append(providers, NewSecretProvider(clientSet, SecretProviderNamespace()))
+		case ProviderHelper:
+			url := strings.TrimSpace(os.Getenv(CredentialHelperURLEnv))
+			if url == "" {
+				return nil, fmt.Errorf("%s: provider %s requires %s", CredentialProvidersEnv, name, CredentialHelperURLEnv)
+			}
+			providers = append(providers, NewHelperProvider(url))
+		default:
+			return nil, fmt.Errorf("%s: unknown provider %s", CredentialProvidersEnv, name)
+		}
+	}
+	return NewProviderChain(providers...), nil
+}
+
+type attributesProvider struct{}
+
+func (attributesProvider) Name() string {
+	return ProviderAttributes
+}
+
+func (attributesProvider) Provide(req *VolumeRequest) (*AccessKey, error) {
+	return &AccessKey{ID: req.attribute(akIDKey), Secret: req.attribute(akSecretKey)}, nil
+}
+
+type secretsProvider struct{}
+
+func (secretsProvider) Name() string {
+	return ProviderSecrets
+}
+
+func (secretsProvider) Provide(req *VolumeRequest) (*AccessKey, error) {
+	return &AccessKey{ID: strings.TrimSpace(req.Secrets[akIDKey]), Secret: strings.TrimSpace(req.Secrets[akSecretKey])}, nil
+}
+
+type envProvider struct{}
+
+func (envProvider) Name() string {
+	return ProviderEnv
+}
+
+func (envProvider) Provide(req *VolumeRequest) (*AccessKey, error) {
+	return &AccessKey{ID: strings.TrimSpace(os.Getenv("ACCESS_KEY_ID")), Secret: strings.TrimSpace(os.Getenv("ACCESS_KEY_SECRET"))}, nil
+}
+
+// managedProvider supplies the temporary credential of the addon token secret, it is rotated in place
+type managedProvider struct {
+	tokens func() utils.ManageTokens
+}
+
+func (managedProvider) Name() string {
+	return ProviderManaged
+}
+
+func (p managedProvider) Provide(req *VolumeRequest) (*AccessKey, error) {
+	tokens := p.tokens()
+	return &AccessKey{ID: tokens.AccessKeyID, Secret: tokens.AccessKeySecret, SecurityToken: tokens.SecurityToken}, nil
+}
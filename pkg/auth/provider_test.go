@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
+)
+
+func TestProviderChain(t *testing.T) {
+	chain := NewProviderChain(attributesProvider{}, secretsProvider{})
+	tests := []struct {
+		name     string
+		req      *VolumeRequest
+		provider string
+		akID     string
+	}{
+		{"attributes", &VolumeRequest{Attributes: map[string]string{"akid": "a", "akSecret": "b"}, Secrets: map[string]string{"akId": "c", "akSecret": "d"}}, ProviderAttributes, "a"},
+		{"secrets", &VolumeRequest{Attributes: map[string]string{"akId": "a"}, Secrets: map[string]string{"akId": "c", "akSecret": "d"}}, ProviderSecrets, "c"},
+		{"none", &VolumeRequest{}, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ak, provider, err := chain.Lookup(test.req)
+			assert.Nil(t, err)
+			assert.Equal(t, test.provider, provider)
+			if test.akID == "" {
+				assert.Nil(t, ak)
+			} else {
+				assert.Equal(t, test.akID, ak.ID)
+			}
+		})
+	}
+}
+
+func TestManagedProvider(t *testing.T) {
+	managed := managedProvider{tokens: func() utils.ManageTokens {
+		return utils.ManageTokens{AccessKeyID: "sts-id", AccessKeySecret: "sts-secret", SecurityToken: "token"}
+	}}
+	os.Setenv("ACCESS_KEY_ID", "env-id")
+	os.Setenv("ACCESS_KEY_SECRET", "env-secret")
+	defer os.Unsetenv("ACCESS_KEY_ID")
+	defer os.Unsetenv("ACCESS_KEY_SECRET")
+	chain := NewProviderChain(attributesProvider{}, secretsProvider{}, envProvider{}, managed)
+
+	// the managed token is only looked up after the AccessKey of the plugin environment
+	ak, provider, err := chain.Lookup(&VolumeRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, ProviderEnv, provider)
+	assert.Equal(t, "env-id", ak.ID)
+
+	os.Unsetenv("ACCESS_KEY_ID")
+	ak, provider, err = chain.Lookup(&VolumeRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, ProviderManaged, provider)
+	assert.Equal(t, &AccessKey{ID: "sts-id", Secret: "sts-secret", SecurityToken: "token"}, ak)
+
+	// without the addon token secret no provider has an AccessKey
+	chain = NewProviderChain(managedProvider{tokens: func() utils.ManageTokens { return utils.ManageTokens{} }})
+	ak, _, err = chain.Lookup(&VolumeRequest{})
+	assert.Nil(t, err)
+	assert.Nil(t, ak)
+}
+
+func TestProvidersFromEnv(t *testing.T) {
+	defer os.Unsetenv(CredentialProvidersEnv)
+	chain, err := ProvidersFromEnv(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"attributes", "secrets", "env", "managed"}, chain.Names())
+
+	os.Setenv(CredentialProvidersEnv, "kubernetes, attributes")
+	chain, err = ProvidersFromEnv(fake.NewSimpleClientset())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"kubernetes", "attributes"}, chain.Names())
+
+	for _, invalid := range []string{"attributes,attributes", "vault", "helper"} {
+		os.Setenv(CredentialProvidersEnv, invalid)
+		_, err = ProvidersFromEnv(nil)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "default"), []byte("id:secret\n"), 0600))
+
+	p, err := NewFileProvider(dir)
+	assert.Nil(t, err)
+	ak, err := p.Provide(&VolumeRequest{Bucket: "bucket"})
+	assert.Nil(t, err)
+	assert.Equal(t, &AccessKey{ID: "id", Secret: "secret"}, ak)
+
+	// the key file of the bucket is picked up without restarting the provider
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "bucket"), []byte("bucket-id:bucket-secret"), 0600))
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if ak, _ = p.Provide(&VolumeRequest{Bucket: "bucket"}); ak.ID == "bucket-id" {
+			break
+		}
+	}
+	assert.Equal(t, "bucket-id", ak.ID)
+}
+
+func TestSecretProvider(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "oss", Namespace: "kube-system"},
+		Data:       map[string][]byte{"akId": []byte("id"), "akSecret": []byte("secret")},
+	})
+	p := NewSecretProvider(clientSet, "kube-system")
+
+	ak, err := p.Provide(&VolumeRequest{})
+	assert.Nil(t, err)
+	assert.Nil(t, ak)
+
+	ak, err = p.Provide(&VolumeRequest{Attributes: map[string]string{"credentialSecretName": "oss"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "id", ak.ID)
+
+	ak, err = p.Provide(&VolumeRequest{Attributes: map[string]string{"credentialSecretName": "oss", "credentialSecretNamespace": "kube-system"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "id", ak.ID)
+
+	// the Secrets of other namespaces are not read
+	_, err = p.Provide(&VolumeRequest{Attributes: map[string]string{"credentialSecretName": "oss", "credentialSecretNamespace": "default"}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "not in namespace")
+	_, err = p.Provide(&VolumeRequest{Attributes: map[string]string{"credentialSecretName": "missing"}})
+	assert.NotNil(t, err)
+}
+
+func TestHelperProvider(t *testing.T) {
+	helper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := helperRequest{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		switch req.Bucket {
+		case "known":
+			fmt.Fprint(w, `{"AccessKeyId": "id", "AccessKeySecret": "secret"}`)
+		case "unknown":
+			http.NotFound(w, r)
+		default:
+			http.Error(w, "broken", http.StatusInternalServerError)
+		}
+	}))
+	defer helper.Close()
+	p := NewHelperProvider(helper.URL)
+
+	ak, err := p.Provide(&VolumeRequest{Bucket: "known"})
+	assert.Nil(t, err)
+	assert.Equal(t, &AccessKey{ID: "id", Secret: "secret"}, ak)
+
+	ak, err = p.Provide(&VolumeRequest{Bucket: "unknown"})
+	assert.Nil(t, err)
+	assert.Nil(t, ak)
+
+	_, err = p.Provide(&VolumeRequest{Bucket: "broken"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// CredentialSecretNamespaceEnv is the namespace of the Secrets of volumes not setting credentialSecretNamespace
+	CredentialSecretNamespaceEnv = "CREDENTIAL_SECRET_NAMESPACE"
+	// DefaultCredentialSecretNamespace is the default namespace of the Secrets of volumes
+	DefaultCredentialSecretNamespace = "kube-system"
+	// CredentialSecretNameKey is the volume attribute naming the Secret of the AccessKey
+	CredentialSecretNameKey = "credentialSecretName"
+	// CredentialSecretNamespaceKey is the volume attribute of the namespace of the Secret, it must be the
+	// namespace of the provider
+	CredentialSecretNamespaceKey = "credentialSecretNamespace"
+
+	secretTimeout = 10 * time.Second
+)
+
+// SecretProviderNamespace return the default namespace of Secrets configured by CredentialSecretNamespaceEnv
+func SecretProviderNamespace() string {
+	if namespace := strings.TrimSpace(os.Getenv(CredentialSecretNamespaceEnv)); namespace != "" {
+		return namespace
+	}
+	return DefaultCredentialSecretNamespace
+}
+
+// secretProvider fetch the akId and akSecret of the Secret named by the volume attributes.
+// Unlike nodePublishSecretRef, the Secret is read by the node plugin every time the volume is
+// published, so a rotated Secret applies when kubelet republishes the volume. Only the Secrets of
+// its namespace are read, the plugin is granted to get the Secrets of that namespace only.
+type secretProvider struct {
+	clientSet kubernetes.Interface
+	namespace string
+}
+
+// NewSecretProvider create a provider of the Secrets read with clientSet, in namespace by default
+func NewSecretProvider(clientSet kubernetes.Interface, namespace string) CredentialProvider {
+	return &secretProvider{clientSet: clientSet, namespace: namespace}
+}
+
+func (p *secretProvider) Name() string {
+	return ProviderKubernetes
+}
+
+func (p *secretProvider) Provide(req *VolumeRequest) (*AccessKey, error) {
+	name := req.attribute(CredentialSecretNameKey)
+	if name == "" {
+		return nil, nil
+	}
+	namespace := p.namespace
+	if ns := req.attribute(CredentialSecretNamespaceKey); ns != "" && ns != namespace {
+		return nil, fmt.Errorf("secret %s/%s is not in namespace %s of the credential secrets, see %s", ns, name, namespace, CredentialSecretNamespaceEnv)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+	secret, err := p.clientSet.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get secret %s/%s: %v", namespace, name, err)
+	}
+	ak := &AccessKey{ID: strings.TrimSpace(string(secret.Data[akIDKey])), Secret: strings.TrimSpace(string(secret.Data[akSecretKey]))}
+	if ak.ID == "" || ak.Secret == "" {
+		return nil, fmt.Errorf("secret %s/%s has no %s and %s", namespace, name, akIDKey, akSecretKey)
+	}
+	return ak, nil
+}
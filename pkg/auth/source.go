@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+type accessKeySource struct {
+	akID     string
+	akSecret string
+}
+
+// NewAccessKeySource return the source of a static AccessKey
+func NewAccessKeySource(akID, akSecret string) CredentialSource {
+	return &accessKeySource{akID: akID, akSecret: akSecret}
+}
+
+func (s *accessKeySource) Key() string {
+	return ModeAccessKey + "/" + s.akID
+}
+
+func (s *accessKeySource) Mode() string {
+	return ModeAccessKey
+}
+
+func (s *accessKeySource) Load() (*Credential, error) {
+	if s.akID == "" || s.akSecret == "" {
+		return nil, errors.New("empty AccessKey")
+	}
+	return &Credential{AccessKeyID: s.akID, AccessKeySecret: s.akSecret}, nil
+}
+
+type envAccessKeySource struct{}
+
+// NewEnvAccessKeySource return the source of the AccessKey in ACCESS_KEY_ID and ACCESS_KEY_SECRET
+func NewEnvAccessKeySource() CredentialSource {
+	return envAccessKeySource{}
+}
+
+func (envAccessKeySource) Key() string {
+	return ModeEnvAccessKey
+}
+
+func (envAccessKeySource) Mode() string {
+	return ModeEnvAccessKey
+}
+
+func (envAccessKeySource) Load() (*Credential, error) {
+	return NewAccessKeySource(strings.TrimSpace(os.Getenv("ACCESS_KEY_ID")), strings.TrimSpace(os.Getenv("ACCESS_KEY_SECRET"))).Load()
+}
+
+type sourceFunc struct {
+	key  string
+	mode string
+	load func() (*Credential, error)
+}
+
+// NewSourceFunc return a source loading the credential with load, for auth modes implemented by callers
+func NewSourceFunc(key, mode string, load func() (*Credential, error)) CredentialSource {
+	return &sourceFunc{key: key, mode: mode, load: load}
+}
+
+func (s *sourceFunc) Key() string {
+	return s.key
+}
+
+func (s *sourceFunc) Mode() string {
+	return s.mode
+}
+
+func (s *sourceFunc) Load() (*Credential, error) {
+	return s.load()
+}
@@ -0,0 +1,53 @@
+//go:build linux
+// +build linux
+
+package auth
+
+import (
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// watchMask is the changes of a directory which replace its files, including the
+// symlink swap kubelet does to update Secret volumes atomically
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM
+
+// watchDir call onChange whenever the files in dir change, until dir is removed
+func watchDir(dir string, onChange func()) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, watchMask); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				log.Errorf("WatchDir: read inotify events of %s is failed, err: %v", dir, err)
+				return
+			}
+			removed := false
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				removed = removed || event.Mask&syscall.IN_IGNORED != 0
+				offset += syscall.SizeofInotifyEvent + int(event.Len)
+			}
+			if removed {
+				log.Warnf("WatchDir: %s is removed, its changes are not watched any more", dir)
+				return
+			}
+			onChange()
+		}
+	}()
+	return nil
+}
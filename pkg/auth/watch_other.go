@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package auth
+
+import (
+	"time"
+)
+
+// watchPeriod is the interval to reload the files of a directory where inotify is not available
+const watchPeriod = time.Minute
+
+// watchDir call onChange periodically, inotify is only available on linux
+func watchDir(dir string, onChange func()) error {
+	go func() {
+		for range time.Tick(watchPeriod) {
+			onChange()
+		}
+	}()
+	return nil
+}
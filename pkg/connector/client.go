@@ -0,0 +1,145 @@
+package connector
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// deadlineMargin is the time the connector has to answer after the deadline of a command
+	deadlineMargin = 5 * time.Second
+	// legacyBufferSize is the size of the only read of a legacy response
+	legacyBufferSize = 2048
+	legacySuccess    = "Success"
+	// legacyRetryPeriod is the time requests use the legacy protocol before the framed one is tried again,
+	// the connector may have been upgraded
+	legacyRetryPeriod = time.Minute
+)
+
+// Client run commands with the connector listening on a unix socket
+type Client struct {
+	socketPath string
+	// legacy is the unix time in nanoseconds the connector last answered a framed request with the
+	// legacy protocol, zero if it never did
+	legacy int64
+}
+
+// NewClient create a client of the connector at socketPath
+func NewClient(socketPath string) *Client {
+	return &Client{socketPath: socketPath}
+}
+
+// Run run cmd on the host within timeout, zero for DefaultTimeout. The error is an *Error
+// for commands the connector refused or which failed, and a transport error otherwise.
+func (c *Client) Run(cmd string, timeout time.Duration) (*Response, error) {
+	return c.do(&Request{Command: cmd}, cmd, timeout)
+}
+
+// Mount run the fuse mount m on the host within timeout, connectors of the legacy
+// protocol receive its command line instead. They cannot pass a credential token to ossfs,
+// nor arguments which are not single shell words.
+func (c *Client) Mount(m *MountRequest, timeout time.Duration) (*Response, error) {
+	legacyCmd := m.LegacyCommandLine()
+	if m.CredentialTokenFile != "" {
+		legacyCmd = ""
+	}
+	return c.do(&Request{Mount: m}, legacyCmd, timeout)
+}
+
+// Status return the status of the connector, connectors of the legacy protocol report none
+func (c *Client) Status(timeout time.Duration) (*Status, error) {
+	resp, err := c.do(&Request{Status: true}, "", timeout)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status == nil {
+		return nil, errors.New("the connector reports no status")
+	}
+	return resp.Status, nil
+}
+
+// do send req, or legacyCmd once the connector is known to speak the legacy protocol
+func (c *Client) do(req *Request, legacyCmd string, timeout time.Duration) (*Response, error) {
+	if since := atomic.LoadInt64(&c.legacy); since != 0 && time.Since(time.Unix(0, since)) < legacyRetryPeriod {
+		return c.runLegacy(legacyCmd, timeout)
+	}
+	req.Version, req.ID, req.TimeoutMillis = Version, newID(), timeout.Milliseconds()
+	conn, err := c.dial(req.Timeout())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := WriteFrame(conn, req); err != nil {
+		return nil, fmt.Errorf("write request %s: %v", req.ID, err)
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("read response %s: %v", req.ID, err)
+	}
+	if !IsFramed(header[0]) {
+		// a connector of the legacy protocol answered, it refuses the frame as a shell command
+		atomic.StoreInt64(&c.legacy, time.Now().UnixNano())
+		return c.runLegacy(legacyCmd, timeout)
+	}
+	atomic.StoreInt64(&c.legacy, 0)
+	resp := &Response{}
+	if err := readBody(conn, header, resp); err != nil {
+		return nil, fmt.Errorf("read response %s: %v", req.ID, err)
+	}
+	if resp.ID != req.ID {
+		return nil, fmt.Errorf("response %s does not match request %s", resp.ID, req.ID)
+	}
+	return resp, resp.Err()
+}
+
+func (c *Client) dial(timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	conn, err := net.Dial("unix", c.socketPath)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout + deadlineMargin))
+	return conn, nil
+}
+
+// runLegacy run cmd with the legacy protocol: the raw command, answered by Success or Fail in one read
+func (c *Client) runLegacy(cmd string, timeout time.Duration) (*Response, error) {
+	if cmd == "" {
+		return nil, errors.New("the request is not supported by connectors of the legacy protocol")
+	}
+	conn, err := c.dial(timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, legacyBufferSize)
+	n, err := conn.Read(buf)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	out := string(buf[:n])
+	if strings.HasPrefix(out, legacySuccess) {
+		resp := &Response{Code: CodeOK, Stdout: strings.TrimPrefix(strings.TrimPrefix(out, legacySuccess), ":")}
+		return resp, nil
+	}
+	resp := &Response{Code: CodeExecFailed, ExitCode: -1, Message: out}
+	return resp, resp.Err()
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,87 @@
+package connector
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ConfigFile is the file of the CONNECTOR_ variables of the connector on the host, written by the plugin
+const ConfigFile = "/etc/csi-tool/connector.env"
+
+var configKeyPattern = regexp.MustCompile(`^CONNECTOR_[A-Z0-9_]+$`)
+
+// EnvFile applies the CONNECTOR_ variables of a file over the environment of the connector,
+// so that they are changed by rewriting the file and reloading the connector with SIGHUP
+type EnvFile struct {
+	path string
+	// base is the environment at start of the variables set by the file, restored when they are
+	// removed from the file, nil for a variable which was not set
+	base map[string]*string
+}
+
+// NewEnvFile create the EnvFile of path
+func NewEnvFile(path string) *EnvFile {
+	return &EnvFile{path: path, base: map[string]*string{}}
+}
+
+// Apply read the file and set its variables, a missing file sets none. Nothing is applied if a line is invalid.
+func (f *EnvFile) Apply() error {
+	vars, err := readEnvFile(f.path)
+	if err != nil {
+		return err
+	}
+	for key, value := range f.base {
+		if _, ok := vars[key]; ok {
+			continue
+		}
+		if value == nil {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, *value)
+		}
+		delete(f.base, key)
+	}
+	for key, value := range vars {
+		if _, ok := f.base[key]; !ok {
+			if old, set := os.LookupEnv(key); set {
+				f.base[key] = &old
+			} else {
+				f.base[key] = nil
+			}
+		}
+		os.Setenv(key, value)
+	}
+	return nil
+}
+
+// readEnvFile parse the KEY=VALUE lines of path, blank lines and lines starting with # are skipped
+func readEnvFile(path string) (map[string]string, error) {
+	vars := map[string]string{}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return vars, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || !configKeyPattern.MatchString(parts[0]) {
+			return nil, fmt.Errorf("%s:%d: expect CONNECTOR_<NAME>=<value>", path, n)
+		}
+		value := parts[1]
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		vars[parts[0]] = value
+	}
+	return vars, scanner.Err()
+}
@@ -0,0 +1,483 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+)
+
+type stubHandler struct{}
+
+func (stubHandler) Handle(req *Request) *Response {
+	if req.Status {
+		return &Response{Code: CodeOK, Status: &Status{Version: "v1", Requests: map[string]RequestStats{RequestMount: {Count: 1}}}}
+	}
+	if req.Mount != nil {
+		return &Response{Code: CodeOK, Stdout: strings.Join(req.Mount.Argv(), " ")}
+	}
+	switch req.Command {
+	case "large":
+		return &Response{Code: CodeOK, Stdout: strings.Repeat("x", 10000)}
+	case "fail":
+		return &Response{Code: CodeExecFailed, ExitCode: 2, Stderr: "no such file", Message: "exit status 2"}
+	}
+	return &Response{Code: CodeOK, Stdout: req.Command}
+}
+
+func (stubHandler) HandleLegacy(cmd string) string {
+	return "Success:" + cmd
+}
+
+// serve answer the connections of a unix socket in a temporary directory with serveConn
+func serve(t *testing.T, serveConn func(net.Conn)) (string, func()) {
+	dir, err := ioutil.TempDir("", "connector")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "connector.sock")
+	ln, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn)
+		}
+	}()
+	return path, func() {
+		ln.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFramedProtocol(t *testing.T) {
+	path, stop := serve(t, func(conn net.Conn) { ServeConn(conn, stubHandler{}) })
+	defer stop()
+	client := NewClient(path)
+
+	resp, err := client.Run("echo", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "echo", resp.Stdout)
+
+	resp, err = client.Run("large", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, len(resp.Stdout))
+
+	_, err = client.Run("fail", 0)
+	connErr, ok := err.(*Error)
+	assert.True(t, ok)
+	assert.Equal(t, CodeExecFailed, connErr.Code)
+	assert.Equal(t, 2, connErr.ExitCode)
+	assert.Equal(t, "no such file", connErr.Stderr)
+}
+
+func TestLegacyProtocol(t *testing.T) {
+	// a connector of the legacy protocol refuses frames as shell commands
+	path, stop := serve(t, func(conn net.Conn) {
+		buf := make([]byte, 2048)
+		n, _ := conn.Read(buf)
+		if buf[0] == 0 {
+			conn.Write([]byte("Fail: invalid command"))
+			return
+		}
+		conn.Write([]byte("Success:" + string(buf[:n])))
+	})
+	defer stop()
+	client := NewClient(path)
+
+	resp, err := client.Run("echo", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "echo", resp.Stdout)
+	assert.NotEqual(t, int64(0), client.legacy)
+
+	// the server answers legacy clients too
+	path, stop = serve(t, func(conn net.Conn) { ServeConn(conn, stubHandler{}) })
+	defer stop()
+	resp, err = legacyClient(path).Run("echo", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "echo", resp.Stdout)
+
+	// the framed protocol is tried again after a while, the connector may have been upgraded
+	client = legacyClient(path)
+	client.legacy = time.Now().Add(-legacyRetryPeriod).UnixNano()
+	_, err = client.Status(0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), client.legacy)
+}
+
+// legacyClient return a client which found the connector at path speaking the legacy protocol
+func legacyClient(path string) *Client {
+	return &Client{socketPath: path, legacy: time.Now().UnixNano()}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	client, server := net.Pipe()
+	go ServeConn(server, stubHandler{})
+	defer client.Close()
+
+	assert.Nil(t, WriteFrame(client, &Request{Version: Version + 1, ID: "1", Command: "echo"}))
+	resp := &Response{}
+	assert.Nil(t, ReadFrame(client, resp))
+	assert.Equal(t, CodeUnsupportedVersion, resp.Code)
+	assert.Equal(t, "1", resp.ID)
+}
+
+func ossfsMount() *MountRequest {
+	return &MountRequest{
+		Client:         fuse.OssfsClient,
+		Binary:         fuse.DefaultOssfsBinary,
+		Bucket:         "aliyun",
+		Path:           "/data",
+		URL:            "oss-cn-hangzhou.aliyuncs.com",
+		Target:         "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount",
+		Options:        []string{"allow_other", "max_stat_cache_size=0"},
+		CredentialFile: fuse.CredentialDir + "/key.passwd",
+		Launcher:       fuse.LauncherSystemd,
+		Unit:           "ossplugin-pv-key.scope",
+	}
+}
+
+func TestMountRequestArgv(t *testing.T) {
+	m := ossfsMount()
+	assert.Equal(t, []string{fuse.DefaultOssfsBinary, "aliyun:/data", m.Target, "-ourl=oss-cn-hangzhou.aliyuncs.com",
+		"-oallow_other", "-omax_stat_cache_size=0", "-opasswd_file=/etc/oss-csi/credentials/key.passwd"}, m.Argv())
+	assert.True(t, strings.HasPrefix(m.CommandLine(), "systemd-run --scope --slice=ossplugin.slice --unit=ossplugin-pv-key.scope -- /usr/local/bin/ossfs aliyun:/data "))
+
+	m = &MountRequest{Client: fuse.JindofsClient, Binary: fuse.DefaultJindofsBinary, Bucket: "aliyun", Path: "/", URL: "oss-cn-hangzhou.aliyuncs.com",
+		Target: "/mnt/oss", CredentialFile: fuse.CredentialDir + "/key.json"}
+	assert.Equal(t, []string{fuse.DefaultJindofsBinary, "/mnt/oss", "-ouri=oss://aliyun/", "-ofs.oss.endpoint=oss-cn-hangzhou.aliyuncs.com",
+		"-ofs.oss.provider.endpoint=LOCAL:///etc/oss-csi/credentials/key.json", "-ofs.oss.provider.format=JSON"}, m.Argv())
+	assert.Equal(t, strings.Join(m.Argv(), " "), m.CommandLine())
+}
+
+func TestMountRequestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(m *MountRequest)
+		valid  bool
+	}{
+		{"valid", func(m *MountRequest) {}, true},
+		{"ram role", func(m *MountRequest) {
+			m.CredentialFile, m.CredentialURL = "", "http://100.100.100.200/latest/meta-data/ram/security-credentials/role"
+		}, true},
+		{"credential server", func(m *MountRequest) {
+			m.CredentialFile, m.CredentialURL = "", "http://127.0.0.1:18080/credentials/key"
+		}, true},
+		{"credential token", func(m *MountRequest) {
+			m.CredentialFile, m.CredentialURL, m.CredentialTokenFile = "", "http://127.0.0.1:18080/credentials/key", fuse.CredentialDir+"/key.token"
+		}, true},
+		{"credential token outside", func(m *MountRequest) {
+			m.CredentialFile, m.CredentialURL, m.CredentialTokenFile = "", "http://127.0.0.1:18080/credentials/key", "/etc/shadow"
+		}, false},
+		{"credential token without url", func(m *MountRequest) { m.CredentialTokenFile = fuse.CredentialDir + "/key.token" }, false},
+		{"process launcher", func(m *MountRequest) { m.Launcher, m.CgroupParent = fuse.LauncherProcess, "ossplugin.slice" }, true},
+		{"debug flag", func(m *MountRequest) { m.Flags = []string{"-d"} }, true},
+		{"cache dir", func(m *MountRequest) { m.CacheDir = "/var/lib/kubelet/csi-plugins/cache/pv-oss/key" }, true},
+		{"unknown client", func(m *MountRequest) { m.Client = "s3fs" }, false},
+		{"unknown binary", func(m *MountRequest) { m.Binary = "/bin/sh" }, false},
+		{"binary of other client", func(m *MountRequest) { m.Binary = fuse.DefaultJindofsBinary }, false},
+		{"invalid bucket", func(m *MountRequest) { m.Bucket = "aliyun;reboot" }, false},
+		{"relative path", func(m *MountRequest) { m.Path = "data" }, false},
+		{"url with options", func(m *MountRequest) { m.URL = "oss-cn-hangzhou.aliyuncs.com,passwd_file=/etc/passwd" }, false},
+		{"relative target", func(m *MountRequest) { m.Target = "mnt/../etc" }, false},
+		{"forbidden option", func(m *MountRequest) { m.Options = []string{"passwd_file=/etc/passwd-ossfs"} }, false},
+		{"smuggled option", func(m *MountRequest) { m.Options = []string{"allow_other,passwd_file=/etc/passwd-ossfs"} }, false},
+		{"unknown option", func(m *MountRequest) { m.Options = []string{"allow_other", "mount_prefix=/"} }, false},
+		{"path option", func(m *MountRequest) { m.Options = []string{"use_cache=/etc"} }, false},
+		{"relative cache dir", func(m *MountRequest) { m.CacheDir = "cache/../../etc" }, false},
+		{"unknown flag", func(m *MountRequest) { m.Flags = []string{"-f"} }, false},
+		{"credential file outside", func(m *MountRequest) { m.CredentialFile = "/etc/oss-csi/credentials/../../shadow" }, false},
+		{"credential file and url", func(m *MountRequest) { m.CredentialURL = "http://127.0.0.1:18080/credentials/key" }, false},
+		{"remote credential url", func(m *MountRequest) { m.CredentialFile, m.CredentialURL = "", "http://example.com/credentials" }, false},
+		{"invalid unit", func(m *MountRequest) { m.Unit = "sshd.service" }, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := ossfsMount()
+			c.modify(m)
+			err := m.Validate(&fuse.Registry{})
+			assert.Equal(t, c.valid, err == nil, err)
+		})
+	}
+}
+
+func TestClientMount(t *testing.T) {
+	path, stop := serve(t, func(conn net.Conn) { ServeConn(conn, stubHandler{}) })
+	defer stop()
+	m := ossfsMount()
+
+	resp, err := NewClient(path).Mount(m, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Join(m.Argv(), " "), resp.Stdout)
+
+	// connectors of the legacy protocol receive the command line
+	resp, err = legacyClient(path).Mount(m, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, m.CommandLine(), resp.Stdout)
+
+	// nor arguments a shell splits
+	spaced := ossfsMount()
+	spaced.Target = "/mnt/oss data"
+	assert.Equal(t, "", spaced.LegacyCommandLine())
+	_, err = legacyClient(path).Mount(spaced, 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, m.CommandLine(), m.LegacyCommandLine())
+
+	// they cannot pass the credential token to ossfs
+	m.CredentialFile, m.CredentialURL, m.CredentialTokenFile = "", "http://127.0.0.1:18080/credentials/key", fuse.CredentialDir+"/key.token"
+	_, err = legacyClient(path).Mount(m, 0)
+	assert.NotNil(t, err)
+}
+
+func TestMountRequestEnviron(t *testing.T) {
+	root := t.TempDir()
+	m := ossfsMount()
+	env, err := m.Environ(root)
+	assert.Nil(t, err)
+	assert.Nil(t, env)
+
+	m.CredentialTokenFile = fuse.CredentialDir + "/key.token"
+	_, err = m.Environ(root)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, fuse.CredentialDir), 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, m.CredentialTokenFile), []byte("secret\n"), 0600))
+	env, err = m.Environ(root)
+	assert.Nil(t, err)
+	assert.Contains(t, env, fuse.CredentialTokenEnv+"=secret")
+	// the token is never on the command line
+	assert.NotContains(t, m.CommandLine(), "secret")
+}
+
+func TestReadPeer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_PEERCRED is only supported on linux")
+	}
+	peers := make(chan *Peer, 1)
+	path, stop := serve(t, func(conn net.Conn) {
+		defer conn.Close()
+		peer, err := ReadPeer(conn, "/proc")
+		assert.Nil(t, err)
+		peers <- peer
+	})
+	defer stop()
+	conn, err := net.Dial("unix", path)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	peer := <-peers
+	assert.Equal(t, int32(os.Getpid()), peer.PID)
+	assert.Equal(t, uint32(os.Getuid()), peer.UID)
+	assert.NotEmpty(t, peer.Cgroups)
+}
+
+func TestParseCgroups(t *testing.T) {
+	assert.Equal(t, []string{"/kubepods/pod1/c1"}, parseCgroups("0::/kubepods/pod1/c1\n"))
+	assert.Equal(t, []string{"/system.slice/sshd.service", "/"}, parseCgroups("12:memory:/system.slice/sshd.service\n11:cpu,cpuacct:/system.slice/sshd.service\n1:name=systemd:/\n"))
+}
+
+func TestPeerPolicy(t *testing.T) {
+	os.Setenv(AllowedGIDsEnv, "1000")
+	os.Setenv(AllowedCgroupsEnv, "/kubepods*, /system.slice/csiplugin-connector.service")
+	defer os.Unsetenv(AllowedGIDsEnv)
+	defer os.Unsetenv(AllowedCgroupsEnv)
+	policy, err := PeerPolicyFromEnv()
+	assert.Nil(t, err)
+
+	cases := []struct {
+		name    string
+		peer    *Peer
+		allowed bool
+	}{
+		{"root in pod", &Peer{UID: 0, GID: 0, Cgroups: []string{"/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-podabc.slice/cri-containerd-1.scope"}}, true},
+		{"allowed gid", &Peer{UID: 1000, GID: 1000, Cgroups: []string{"/system.slice/csiplugin-connector.service"}}, true},
+		{"other uid", &Peer{UID: 1001, GID: 1001, Cgroups: []string{"/kubepods/pod1/c1"}}, false},
+		{"root in other cgroup", &Peer{UID: 0, GID: 0, Cgroups: []string{"/user.slice/user-0.slice/session-1.scope"}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := policy.Authorize(c.peer)
+			assert.Equal(t, c.allowed, err == nil, err)
+		})
+	}
+
+	os.Setenv(AllowedUIDsEnv, "root")
+	defer os.Unsetenv(AllowedUIDsEnv)
+	_, err = PeerPolicyFromEnv()
+	assert.NotNil(t, err)
+}
+
+func TestStatus(t *testing.T) {
+	stats := NewStats()
+	stats.Observe(RequestMount, time.Second, false)
+	stats.Observe(RequestMount, 3*time.Second, true)
+	assert.Equal(t, map[string]RequestStats{RequestMount: {Count: 2, Errors: 1, DurationSeconds: 4, MaxDurationSeconds: 3}}, stats.Snapshot())
+
+	path, stop := serve(t, func(conn net.Conn) { ServeConn(conn, stubHandler{}) })
+	defer stop()
+	status, err := NewClient(path).Status(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", status.Version)
+	assert.Equal(t, uint64(1), status.Requests[RequestMount].Count)
+
+	_, err = legacyClient(path).Status(0)
+	assert.NotNil(t, err)
+}
+
+func TestStatusAddress(t *testing.T) {
+	defer os.Unsetenv(StatusAddressEnv)
+	for address, valid := range map[string]bool{"": true, "127.0.0.1:18081": true, "localhost:18081": true, "[::1]:18081": true, "0.0.0.0:18081": false, "18081": false} {
+		os.Setenv(StatusAddressEnv, address)
+		got, err := StatusAddress()
+		assert.Equal(t, valid, err == nil, address)
+		if valid {
+			assert.Equal(t, address, got)
+		}
+	}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	var none *RestartPolicy
+	assert.Nil(t, none.Validate())
+	assert.Equal(t, 0, none.Limit())
+	assert.Equal(t, 0, (&RestartPolicy{Policy: RestartNever, MaxRestarts: 5}).Limit())
+	assert.Equal(t, DefaultMaxRestarts, (&RestartPolicy{Policy: RestartOnFailure}).Limit())
+	assert.Equal(t, 5, (&RestartPolicy{Policy: RestartOnFailure, MaxRestarts: 5}).Limit())
+	assert.NotNil(t, (&RestartPolicy{Policy: "always"}).Validate())
+	assert.NotNil(t, (&RestartPolicy{Policy: RestartOnFailure, MaxRestarts: -1}).Validate())
+	assert.NotNil(t, (&RestartPolicy{Policy: RestartOnFailure, MaxRestarts: maxRestartsLimit + 1}).Validate())
+}
+
+// stubHost answer every request with its name
+type stubHost string
+
+func (h stubHost) Run(cmd string, timeout time.Duration) (*Response, error) {
+	return &Response{Code: CodeOK, Stdout: string(h)}, nil
+}
+
+func (h stubHost) Mount(m *MountRequest, timeout time.Duration) (*Response, error) {
+	return &Response{Code: CodeOK, Stdout: string(h)}, nil
+}
+
+func (h stubHost) Status(timeout time.Duration) (*Status, error) {
+	return &Status{Version: string(h)}, nil
+}
+
+func TestSelector(t *testing.T) {
+	path, stop := serve(t, func(conn net.Conn) { ServeConn(conn, stubHandler{}) })
+	defer stop()
+	missing := NewClient(filepath.Join(filepath.Dir(path), "missing.sock"))
+	cases := []struct {
+		name      string
+		mode      string
+		connector Host
+		executor  string
+		stdout    string
+	}{
+		{"auto with connector", ExecModeAuto, NewClient(path), ExecModeConnector, "echo"},
+		{"auto without connector", ExecModeAuto, missing, ExecModeNsenter, "nsenter"},
+		{"nsenter", ExecModeNsenter, NewClient(path), ExecModeNsenter, "nsenter"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewSelector(c.mode, c.connector, stubHost("nsenter"))
+			resp, err := s.Run("echo", time.Second)
+			assert.Nil(t, err)
+			assert.Equal(t, c.stdout, resp.Stdout)
+			state := s.State()
+			assert.Equal(t, c.mode, state.Mode)
+			assert.Equal(t, c.executor, state.Executor)
+			assert.Equal(t, c.mode == ExecModeAuto && c.executor == ExecModeNsenter, state.Reason != "")
+		})
+	}
+
+	s := NewSelector(ExecModeConnector, missing, stubHost("nsenter"))
+	_, err := s.Run("echo", time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, ExecModeConnector, s.State().Executor)
+}
+
+func TestExecModeFromEnv(t *testing.T) {
+	defer os.Unsetenv(ExecModeEnv)
+	for value, mode := range map[string]string{"": ExecModeAuto, "Nsenter": ExecModeNsenter, "connector": ExecModeConnector, "shell": ""} {
+		os.Setenv(ExecModeEnv, value)
+		got, err := ExecModeFromEnv()
+		assert.Equal(t, mode == "", err != nil, value)
+		assert.Equal(t, mode, got)
+	}
+}
+
+func TestEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "connector")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "connector.env")
+	os.Setenv(AllowedUIDsEnv, "0")
+	defer os.Unsetenv(AllowedUIDsEnv)
+	defer os.Unsetenv(AllowedGIDsEnv)
+
+	f := NewEnvFile(path)
+	assert.Nil(t, f.Apply())
+	assert.Equal(t, "0", os.Getenv(AllowedUIDsEnv))
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("# callers\nCONNECTOR_ALLOWED_UIDS=0,1000\n\nCONNECTOR_ALLOWED_GIDS=\"10\"\n"), 0600))
+	assert.Nil(t, f.Apply())
+	assert.Equal(t, "0,1000", os.Getenv(AllowedUIDsEnv))
+	assert.Equal(t, "10", os.Getenv(AllowedGIDsEnv))
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("PATH=/tmp\n"), 0600))
+	assert.NotNil(t, f.Apply())
+	assert.Equal(t, "0,1000", os.Getenv(AllowedUIDsEnv))
+
+	// removed variables are restored to the environment at start
+	assert.Nil(t, ioutil.WriteFile(path, []byte(""), 0600))
+	assert.Nil(t, f.Apply())
+	assert.Equal(t, "0", os.Getenv(AllowedUIDsEnv))
+	_, set := os.LookupEnv(AllowedGIDsEnv)
+	assert.False(t, set)
+}
+
+func TestNsenterRun(t *testing.T) {
+	fake := executor.NewFake().On("nsenter --mount=/proc/1/ns/mnt --net=/proc/1/ns/net --uts=/proc/1/ns/uts -- ossfs --version", "V1.91.2", 0)
+	n := &Nsenter{Executor: fake}
+	resp, err := n.Run("ossfs --version", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "V1.91.2", resp.Stdout)
+
+	fake.On("nsenter", "", 2)
+	resp, err = n.Run("mount", time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, CodeExecFailed, resp.Code)
+	assert.Equal(t, 2, resp.ExitCode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	resp = CommandResponse(fake.Run(ctx, executor.NewCommand("sleep", "1")))
+	assert.Equal(t, CodeTimeout, resp.Code)
+	assert.Equal(t, -1, resp.ExitCode)
+}
+
+func TestFrameSize(t *testing.T) {
+	// a frame of MaxFrameSize bytes would start with a non zero byte, as the legacy protocol
+	buf := &bytes.Buffer{}
+	assert.NotNil(t, WriteFrame(buf, strings.Repeat("a", MaxFrameSize-2)))
+	assert.Nil(t, WriteFrame(buf, strings.Repeat("a", MaxFrameSize-3)))
+	assert.True(t, IsFramed(buf.Bytes()[0]))
+
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header, MaxFrameSize)
+	var v string
+	assert.NotNil(t, ReadFrame(bytes.NewReader(header), &v))
+}
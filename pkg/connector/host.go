@@ -0,0 +1,123 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ExecModeEnv selects how the plugin runs requests on the host
+	ExecModeEnv = "HOST_EXEC_MODE"
+	// ExecModeConnector runs requests with the connector daemon only
+	ExecModeConnector = "connector"
+	// ExecModeNsenter runs requests by entering the host namespaces, without the connector
+	ExecModeNsenter = "nsenter"
+	// ExecModeAuto runs requests with the connector and falls back to nsenter when it cannot be reached
+	ExecModeAuto = "auto"
+)
+
+// Host run requests on the host
+type Host interface {
+	Run(cmd string, timeout time.Duration) (*Response, error)
+	Mount(m *MountRequest, timeout time.Duration) (*Response, error)
+	Status(timeout time.Duration) (*Status, error)
+}
+
+// ExecModeFromEnv return the mode configured by ExecModeEnv, ExecModeAuto by default
+func ExecModeFromEnv() (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv(ExecModeEnv)))
+	switch mode {
+	case "":
+		return ExecModeAuto, nil
+	case ExecModeConnector, ExecModeNsenter, ExecModeAuto:
+		return mode, nil
+	}
+	return "", fmt.Errorf("%s: unknown mode %q, should be %s, %s or %s", ExecModeEnv, mode, ExecModeConnector, ExecModeNsenter, ExecModeAuto)
+}
+
+// ExecState is the executor chosen by a Selector, reported on /healthz
+type ExecState struct {
+	// Mode is the configured mode
+	Mode string `json:"mode"`
+	// Executor is ExecModeConnector or ExecModeNsenter, the executor of the last request
+	Executor string `json:"executor"`
+	// Reason is why the last request fell back to nsenter
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time,omitempty"`
+}
+
+// Selector run requests with the connector or nsenter according to its mode. In ExecModeAuto every
+// request goes to the connector first, and to nsenter only if the connector socket cannot be dialed,
+// a request the connector has received is never run twice.
+type Selector struct {
+	mode      string
+	connector Host
+	nsenter   Host
+
+	lock  sync.Mutex
+	state ExecState
+}
+
+// NewSelector create a selector of mode between connector and nsenter
+func NewSelector(mode string, connector, nsenter Host) *Selector {
+	executor := ExecModeConnector
+	if mode == ExecModeNsenter {
+		executor = ExecModeNsenter
+	}
+	return &Selector{mode: mode, connector: connector, nsenter: nsenter, state: ExecState{Mode: mode, Executor: executor}}
+}
+
+// State return the executor of the last request
+func (s *Selector) State() ExecState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.state
+}
+
+// Run run cmd on the host with the executor of the mode
+func (s *Selector) Run(cmd string, timeout time.Duration) (*Response, error) {
+	return s.do(func(h Host) (*Response, error) { return h.Run(cmd, timeout) })
+}
+
+// Mount run the fuse mount m on the host with the executor of the mode
+func (s *Selector) Mount(m *MountRequest, timeout time.Duration) (*Response, error) {
+	return s.do(func(h Host) (*Response, error) { return h.Mount(m, timeout) })
+}
+
+// Status return the status of the connector, there is none in ExecModeNsenter
+func (s *Selector) Status(timeout time.Duration) (*Status, error) {
+	if s.mode == ExecModeNsenter {
+		return s.nsenter.Status(timeout)
+	}
+	return s.connector.Status(timeout)
+}
+
+func (s *Selector) do(request func(h Host) (*Response, error)) (*Response, error) {
+	if s.mode == ExecModeNsenter {
+		return request(s.nsenter)
+	}
+	resp, err := request(s.connector)
+	if s.mode == ExecModeConnector || !isDialError(err) {
+		s.setState(ExecModeConnector, "")
+		return resp, err
+	}
+	s.setState(ExecModeNsenter, "connector is unavailable: "+err.Error())
+	return request(s.nsenter)
+}
+
+func (s *Selector) setState(executor, reason string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.state.Executor, s.state.Reason, s.state.Time = executor, reason, time.Now()
+}
+
+// isDialError tell whether err is the failure to connect to the connector, before any request is sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
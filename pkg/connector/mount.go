@@ -0,0 +1,255 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+)
+
+const (
+	// metadataHost is the metadata server, which serves the credentials of the ram role of the node
+	metadataHost = "100.100.100.200"
+
+	// RestartNever leaves a crashed fuse process and its disconnected mount point alone
+	RestartNever = "never"
+	// RestartOnFailure restarts a fuse process which exits while its target is still mounted
+	RestartOnFailure = "on-failure"
+	// DefaultMaxRestarts is how many times a crashed fuse process is restarted if the policy sets no limit
+	DefaultMaxRestarts = 3
+	// maxRestartsLimit bounds the restarts a policy may ask for
+	maxRestartsLimit = 100
+)
+
+var (
+	bucketPattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,61}[a-z0-9]$`)
+	endpointPattern = regexp.MustCompile(`^[A-Za-z0-9.:/_-]+$`)
+	volumeIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{0,253}$`)
+	podPattern      = regexp.MustCompile(`^([a-z0-9.-]{1,253}/[a-z0-9.-]{1,253})?$`)
+	// shellWordPattern matches the arguments a shell reads as they are
+	shellWordPattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./~-]+$`)
+)
+
+// RestartPolicy tells the connector what to do when the fuse process of a mount exits
+type RestartPolicy struct {
+	// Policy is RestartNever or RestartOnFailure
+	Policy string `json:"policy"`
+	// MaxRestarts limits the restarts of the fuse process, 0 for DefaultMaxRestarts
+	MaxRestarts int `json:"maxRestarts,omitempty"`
+}
+
+// Limit return the number of restarts allowed by the policy
+func (p *RestartPolicy) Limit() int {
+	if p == nil || p.Policy != RestartOnFailure {
+		return 0
+	}
+	if p.MaxRestarts <= 0 {
+		return DefaultMaxRestarts
+	}
+	return p.MaxRestarts
+}
+
+// Validate check the policy and its limit
+func (p *RestartPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.Policy != RestartNever && p.Policy != RestartOnFailure {
+		return fmt.Errorf("unknown restart policy %q, should be %s or %s", p.Policy, RestartNever, RestartOnFailure)
+	}
+	if p.MaxRestarts < 0 || p.MaxRestarts > maxRestartsLimit {
+		return fmt.Errorf("max restarts %d is not within 0 and %d", p.MaxRestarts, maxRestartsLimit)
+	}
+	return nil
+}
+
+// MountRequest is a fuse mount whose command the connector builds and runs itself without a shell,
+// so no part of the request is ever interpreted by a shell or split into more arguments
+type MountRequest struct {
+	// Client is fuse.OssfsClient or fuse.JindofsClient
+	Client string `json:"client"`
+	// Binary is the host path of the fuse client, one of the binaries of the registry
+	Binary string `json:"binary"`
+	Bucket string `json:"bucket"`
+	Path   string `json:"path"`
+	// URL is the endpoint of the bucket
+	URL    string `json:"url"`
+	Target string `json:"target"`
+	// Options are the -o options of the client, each is name or name=value
+	Options []string `json:"options,omitempty"`
+	// Flags are the other flags of the client, such as -d of ossfs
+	Flags []string `json:"flags,omitempty"`
+	// CacheDir is the use_cache directory of ossfs prepared by the plugin, otherOpts cannot set it
+	CacheDir string `json:"cacheDir,omitempty"`
+	// CredentialFile is the credential file of the mount point under fuse.CredentialDir,
+	// the passwd file of ossfs or the JSON file of jindo-fuse
+	CredentialFile string `json:"credentialFile,omitempty"`
+	// CredentialURL is the ram_role url ossfs polls for temporary credentials,
+	// on the metadata server or the credential server of the plugin
+	CredentialURL string `json:"credentialUrl,omitempty"`
+	// CredentialTokenFile is the file under fuse.CredentialDir holding the secret of a credential url of the
+	// plugin, it is read into the environment of ossfs as fuse.CredentialTokenEnv
+	CredentialTokenFile string `json:"credentialTokenFile,omitempty"`
+
+	// Launcher, Unit, CgroupParent and Resources select the scope of the fuse process, see fuse.Launch.
+	// Unit is named after the volume and the target, a restarted process keeps it.
+	Launcher     string         `json:"launcher,omitempty"`
+	Unit         string         `json:"unit,omitempty"`
+	CgroupParent string         `json:"cgroupParent,omitempty"`
+	Resources    fuse.Resources `json:"resources"`
+
+	// VolumeID is the volume mounted, it is reported with the fuse process
+	VolumeID string `json:"volumeId,omitempty"`
+	// Restart is the restart policy of the fuse process, it is never restarted if nil
+	Restart *RestartPolicy `json:"restart,omitempty"`
+	// Pod is the namespace/name of the pod of a mount point serving a single pod, it is audited with the mount
+	Pod string `json:"pod,omitempty"`
+}
+
+// Argv return the command of the fuse client, without the launcher
+func (m *MountRequest) Argv() []string {
+	var argv []string
+	if m.Client == fuse.JindofsClient {
+		argv = []string{m.Binary, m.Target, "-ouri=oss://" + m.Bucket + m.Path, "-ofs.oss.endpoint=" + m.URL}
+	} else {
+		argv = []string{m.Binary, m.Bucket + ":" + m.Path, m.Target, "-ourl=" + m.URL}
+	}
+	for _, opt := range m.Options {
+		argv = append(argv, "-o"+opt)
+	}
+	if m.CacheDir != "" {
+		argv = append(argv, "-ouse_cache="+m.CacheDir)
+	}
+	argv = append(argv, m.Flags...)
+	switch {
+	case m.Client == fuse.JindofsClient:
+		argv = append(argv, "-ofs.oss.provider.endpoint=LOCAL://"+m.CredentialFile, "-ofs.oss.provider.format=JSON")
+	case m.CredentialFile != "":
+		argv = append(argv, "-opasswd_file="+m.CredentialFile)
+	case m.CredentialURL != "":
+		argv = append(argv, "-oram_role="+m.CredentialURL)
+	}
+	return argv
+}
+
+// Launch return the launch of the fuse command in its scope
+func (m *MountRequest) Launch() *fuse.Launch {
+	return &fuse.Launch{
+		Launcher:     m.Launcher,
+		Unit:         m.Unit,
+		CgroupParent: m.CgroupParent,
+		Resources:    m.Resources,
+		Command:      strings.Join(m.Argv(), " "),
+	}
+}
+
+// Environ return the environment of the fuse process, with the secret of CredentialTokenFile read under
+// root, the host root directory. It return nil for the environment of the caller.
+func (m *MountRequest) Environ(root string) ([]string, error) {
+	if m.CredentialTokenFile == "" {
+		return nil, nil
+	}
+	token, err := ioutil.ReadFile(filepath.Join(root, m.CredentialTokenFile))
+	if err != nil {
+		return nil, fmt.Errorf("read credential token: %v", err)
+	}
+	return append(os.Environ(), fuse.CredentialTokenEnv+"="+strings.TrimSpace(string(token))), nil
+}
+
+// CommandLine return the whole command line of the mount
+func (m *MountRequest) CommandLine() string {
+	return m.Launch().String()
+}
+
+// LegacyCommandLine return the command line sent to connectors of the legacy protocol, which run it
+// with a shell. It is empty if an argument is not a single shell word, e.g. a target with a space.
+func (m *MountRequest) LegacyCommandLine() string {
+	for _, arg := range m.Argv() {
+		if !shellWordPattern.MatchString(arg) {
+			return ""
+		}
+	}
+	return m.CommandLine()
+}
+
+// Validate check every field of the request against registry and the option allow-list of the
+// plugin, the existence of the target is left to the connector
+func (m *MountRequest) Validate(registry *fuse.Registry) error {
+	if m.Client != fuse.OssfsClient && m.Client != fuse.JindofsClient {
+		return fmt.Errorf("unknown fuse client %q", m.Client)
+	}
+	if client, binary := registry.MatchBinary(m.Binary); client != m.Client || binary != m.Binary {
+		return fmt.Errorf("%s is not a binary of %s in the registry", m.Binary, m.Client)
+	}
+	if !bucketPattern.MatchString(m.Bucket) {
+		return fmt.Errorf("invalid bucket %q", m.Bucket)
+	}
+	if !strings.HasPrefix(m.Path, "/") || strings.ContainsAny(m.Path, ",\x00") {
+		return fmt.Errorf("invalid path %q", m.Path)
+	}
+	if !endpointPattern.MatchString(m.URL) {
+		return fmt.Errorf("invalid url %q", m.URL)
+	}
+	if !filepath.IsAbs(m.Target) || filepath.Clean(m.Target) != m.Target || strings.ContainsAny(m.Target, ",\x00") {
+		return fmt.Errorf("invalid target %q", m.Target)
+	}
+	if err := fuse.CheckOptions(m.Client, m.Options, m.Flags); err != nil {
+		return err
+	}
+	if m.CacheDir != "" && (m.Client != fuse.OssfsClient || !filepath.IsAbs(m.CacheDir) || filepath.Clean(m.CacheDir) != m.CacheDir || strings.ContainsAny(m.CacheDir, ",\x00")) {
+		return fmt.Errorf("invalid cache dir %q", m.CacheDir)
+	}
+	if err := m.validateCredential(); err != nil {
+		return err
+	}
+	if !volumeIDPattern.MatchString(m.VolumeID) {
+		return fmt.Errorf("invalid volume id %q", m.VolumeID)
+	}
+	if !podPattern.MatchString(m.Pod) {
+		return fmt.Errorf("invalid pod %q", m.Pod)
+	}
+	if err := m.Restart.Validate(); err != nil {
+		return err
+	}
+	return m.Launch().Validate()
+}
+
+// validateCredential check the credential reference: jindo-fuse reads a credential file,
+// ossfs reads a passwd file, polls a ram_role url or mounts a public bucket without any
+func (m *MountRequest) validateCredential() error {
+	for _, file := range []string{m.CredentialFile, m.CredentialTokenFile} {
+		if file != "" && (filepath.Clean(file) != file || filepath.Dir(file) != fuse.CredentialDir || strings.ContainsAny(file, ",\x00")) {
+			return fmt.Errorf("credential file %q is not in %s", file, fuse.CredentialDir)
+		}
+	}
+	if m.CredentialTokenFile != "" && (m.Client != fuse.OssfsClient || m.CredentialURL == "") {
+		return errors.New("a credential token requires the credential url of ossfs")
+	}
+	if m.Client == fuse.JindofsClient {
+		if m.CredentialFile == "" || m.CredentialURL != "" {
+			return errors.New("jindofs requires a credential file and no credential url")
+		}
+		return nil
+	}
+	if m.CredentialURL == "" {
+		return nil
+	}
+	if m.CredentialFile != "" {
+		return errors.New("ossfs accepts either a credential file or a credential url")
+	}
+	u, err := url.Parse(m.CredentialURL)
+	if err != nil || u.Scheme != "http" || u.User != nil || strings.ContainsAny(m.CredentialURL, ", \x00") {
+		return fmt.Errorf("invalid credential url %q", m.CredentialURL)
+	}
+	if ip := net.ParseIP(u.Hostname()); u.Hostname() != metadataHost && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("credential url %q is neither the metadata server nor local", m.CredentialURL)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/audit"
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+)
+
+// hostNamespaces are the namespaces of the host init process entered by nsenter,
+// the plugin runs with hostPID so /proc/1 is the init process of the host
+var hostNamespaces = []string{"--mount=/proc/1/ns/mnt", "--net=/proc/1/ns/net", "--uts=/proc/1/ns/uts"}
+
+// Nsenter run requests on the host by entering the namespaces of the host init process,
+// without the connector. Mount requests are validated the same way as by the connector.
+type Nsenter struct {
+	// Registry return the fuse client registry validating the binaries of mount requests
+	Registry func() *fuse.Registry
+	// HostRoot is where the root of the host is seen, to check the targets of mount requests
+	HostRoot string
+	// CgroupRoot is where the cgroup filesystem of the host is seen, for the process launcher
+	CgroupRoot string
+	// Audit return the logger recording the commands run on the host, nil to record none
+	Audit func() *audit.Logger
+	// Executor runs nsenter
+	Executor executor.Executor
+}
+
+// NewNsenter create an executor entering the host namespaces through /proc/1
+func NewNsenter(registry func() *fuse.Registry) *Nsenter {
+	return &Nsenter{Registry: registry, HostRoot: fuse.HostProcRoot, CgroupRoot: fuse.HostCgroupRoot, Audit: audit.Default, Executor: executor.New()}
+}
+
+// Run run cmd on the host within timeout. cmd is split on spaces and run without a shell,
+// the plugin only runs simple commands such as the version probe of fuse clients.
+func (n *Nsenter) Run(cmd string, timeout time.Duration) (*Response, error) {
+	argv := strings.Fields(cmd)
+	if len(argv) == 0 {
+		resp := &Response{Code: CodeRejected, ExitCode: -1, Message: "empty command"}
+		return resp, resp.Err()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutOrDefault(timeout))
+	defer cancel()
+	start := time.Now()
+	resp := n.wait(ctx, executor.NewCommand(append([]string{"nsenter"}, n.args(argv)...)...), func() {})
+	n.audit(&audit.Record{Action: audit.ActionCommand}, argv, start, resp)
+	return resp, resp.Err()
+}
+
+// Mount run the fuse mount m on the host within timeout
+func (n *Nsenter) Mount(m *MountRequest, timeout time.Duration) (*Response, error) {
+	record, start := &audit.Record{Action: audit.ActionMount, VolumeID: m.VolumeID, Pod: m.Pod}, time.Now()
+	argv := append(m.Launch().PrefixArgs(), m.Argv()...)
+	err := m.Validate(n.Registry())
+	if err == nil {
+		if _, statErr := os.Stat(filepath.Join(n.HostRoot, m.Target)); statErr != nil {
+			err = errors.New("mount target not exist " + m.Target)
+		}
+	}
+	if err != nil {
+		resp := &Response{Code: CodeRejected, ExitCode: -1, Message: err.Error()}
+		n.audit(record, argv, start, resp)
+		return resp, resp.Err()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutOrDefault(timeout))
+	defer cancel()
+	c, cleanup, err := n.prepareMount(m)
+	if err != nil {
+		resp := &Response{Code: CodeExecFailed, ExitCode: -1, Message: err.Error()}
+		n.audit(record, argv, start, resp)
+		return resp, resp.Err()
+	}
+	resp := n.wait(ctx, c, cleanup)
+	n.audit(record, argv, start, resp)
+	return resp, resp.Err()
+}
+
+// audit complete record with argv and the response of the command started at start, and log it
+func (n *Nsenter) audit(record *audit.Record, argv []string, start time.Time, resp *Response) {
+	record.Source, record.Code = audit.SourcePlugin, string(resp.Code)
+	record.SetArgv(argv...)
+	record.SetOutput(resp.Stdout, resp.Stderr)
+	record.Finish(start, resp.ExitCode, resp.Err())
+	if n.Audit != nil {
+		n.Audit().Log(record)
+	}
+}
+
+// Status fails, only the connector reports a status
+func (n *Nsenter) Status(timeout time.Duration) (*Status, error) {
+	return nil, errors.New("no connector reports the status in nsenter mode")
+}
+
+// args return the arguments of nsenter running argv in the host namespaces
+func (n *Nsenter) args(argv []string) []string {
+	return append(append(append([]string{}, hostNamespaces...), "--"), argv...)
+}
+
+// prepareMount return the command of a mount and the cleanup after it fails. Like the connector, the
+// process launcher joins the cgroup of the fuse process by re-executing the plugin with fuse.JoinCgroupArg
+// before it enters the host namespaces, so the fuse process does not belong to the plugin pod.
+func (n *Nsenter) prepareMount(m *MountRequest) (*executor.Command, func(), error) {
+	l, argv := m.Launch(), m.Argv()
+	// nsenter and the launchers keep the environment, the token reaches ossfs
+	env, err := m.Environ(fuse.HostPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if l.Launcher != fuse.LauncherProcess {
+		c := executor.NewCommand(append([]string{"nsenter"}, n.args(append(l.PrefixArgs(), argv...))...)...)
+		c.Env = env
+		return c, func() {}, nil
+	}
+	// the plugin does not watch the cgroups of the fuse processes it started, reap them on the next mount
+	fuse.ReapScopeCgroups(n.CgroupRoot, l.CgroupParent)
+	procs, err := fuse.CreateScopeCgroup(n.CgroupRoot, l.CgroupParent, l.Unit, l.Resources)
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { fuse.RemoveScopeCgroup(n.CgroupRoot, l.CgroupParent, l.Unit) }
+	self, err := os.Executable()
+	if err == nil {
+		var nsenter string
+		if nsenter, err = exec.LookPath("nsenter"); err == nil {
+			args := append(append([]string{fuse.JoinCgroupArg}, procs...), "--", nsenter)
+			c := executor.NewCommand(append(append([]string{self}, args...), n.args(argv)...)...)
+			c.Setsid, c.Env = true, env
+			return c, cleanup, nil
+		}
+	}
+	cleanup()
+	return nil, nil, err
+}
+
+// wait run c until ctx is done, with separate stdout and stderr, and call cleanup if it fails
+func (n *Nsenter) wait(ctx context.Context, c *executor.Command, cleanup func()) *Response {
+	result, err := n.Executor.Run(ctx, c)
+	if err != nil {
+		cleanup()
+	}
+	resp := CommandResponse(result, err)
+	resp.Version = Version
+	return resp
+}
+
+func timeoutOrDefault(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return DefaultTimeout
+	}
+	return timeout
+}
@@ -0,0 +1,142 @@
+package connector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AllowedUIDsEnv is the comma separated uids allowed to send requests to the connector
+	AllowedUIDsEnv = "CONNECTOR_ALLOWED_UIDS"
+	// AllowedGIDsEnv is the comma separated gids allowed to send requests to the connector
+	AllowedGIDsEnv = "CONNECTOR_ALLOWED_GIDS"
+	// AllowedCgroupsEnv is the comma separated cgroup paths allowed to send requests, * matches any
+	// characters, e.g. /kubepods* for pods. Callers in any cgroup are allowed if it is empty.
+	AllowedCgroupsEnv = "CONNECTOR_ALLOWED_CGROUPS"
+	// DefaultAllowedUIDs allows root, the csi-plugin runs as root
+	DefaultAllowedUIDs = "0"
+)
+
+// Peer is the process at the other end of a unix socket connection, as reported by SO_PEERCRED
+type Peer struct {
+	PID int32
+	UID uint32
+	GID uint32
+	// Cgroups are the cgroup paths of the process
+	Cgroups []string
+}
+
+func (p *Peer) String() string {
+	return fmt.Sprintf("pid=%d uid=%d gid=%d cgroup=%s", p.PID, p.UID, p.GID, strings.Join(p.Cgroups, ","))
+}
+
+// ReadPeer return the peer of a unix socket connection, procRoot is where /proc is mounted
+func ReadPeer(conn net.Conn, procRoot string) (*Peer, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a unix socket connection", conn)
+	}
+	peer, err := peerCredentials(unixConn)
+	if err != nil {
+		return nil, fmt.Errorf("get peer credentials: %v", err)
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(int(peer.PID)), "cgroup"))
+	if err != nil {
+		return nil, fmt.Errorf("read cgroup of peer %d: %v", peer.PID, err)
+	}
+	peer.Cgroups = parseCgroups(string(raw))
+	return peer, nil
+}
+
+// parseCgroups return the distinct paths of a /proc/<pid>/cgroup file, one line per hierarchy: id:controllers:path
+func parseCgroups(raw string) []string {
+	var paths []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(raw, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(parts) != 3 || seen[parts[2]] {
+			continue
+		}
+		seen[parts[2]] = true
+		paths = append(paths, parts[2])
+	}
+	return paths
+}
+
+// PeerPolicy decides which peers may send requests: the uid or the gid of a peer must be allowed,
+// and one of its cgroups must match an allowed cgroup if any is configured
+type PeerPolicy struct {
+	UIDs    map[uint32]bool
+	GIDs    map[uint32]bool
+	Cgroups []*regexp.Regexp
+}
+
+// PeerPolicyFromEnv return the policy configured by AllowedUIDsEnv, AllowedGIDsEnv and AllowedCgroupsEnv
+func PeerPolicyFromEnv() (*PeerPolicy, error) {
+	uids := os.Getenv(AllowedUIDsEnv)
+	if strings.TrimSpace(uids) == "" {
+		uids = DefaultAllowedUIDs
+	}
+	p := &PeerPolicy{}
+	var err error
+	if p.UIDs, err = parseIDs(uids); err != nil {
+		return nil, fmt.Errorf("%s: %v", AllowedUIDsEnv, err)
+	}
+	if p.GIDs, err = parseIDs(os.Getenv(AllowedGIDsEnv)); err != nil {
+		return nil, fmt.Errorf("%s: %v", AllowedGIDsEnv, err)
+	}
+	for _, pattern := range strings.Split(os.Getenv(AllowedCgroupsEnv), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			p.Cgroups = append(p.Cgroups, globPattern(pattern))
+		}
+	}
+	return p, nil
+}
+
+func parseIDs(s string) (map[uint32]bool, error) {
+	ids := map[uint32]bool{}
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %s", field)
+		}
+		ids[uint32(id)] = true
+	}
+	return ids, nil
+}
+
+// globPattern return the regexp of a cgroup pattern where * matches any characters, including /
+func globPattern(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// Authorize return why peer is not allowed, nil if it is
+func (p *PeerPolicy) Authorize(peer *Peer) error {
+	if !p.UIDs[peer.UID] && !p.GIDs[peer.GID] {
+		return fmt.Errorf("uid %d and gid %d are not allowed", peer.UID, peer.GID)
+	}
+	if len(p.Cgroups) == 0 {
+		return nil
+	}
+	for _, cgroup := range peer.Cgroups {
+		for _, pattern := range p.Cgroups {
+			if pattern.MatchString(cgroup) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("cgroup %s is not allowed", strings.Join(peer.Cgroups, ","))
+}
@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package connector
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials return the pid, uid and gid of the process which connected conn
+func peerCredentials(conn *net.UnixConn) (*Peer, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	return &Peer{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package connector
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials is only supported on linux, where SO_PEERCRED is available
+func peerCredentials(conn *net.UnixConn) (*Peer, error) {
+	return nil, errors.New("SO_PEERCRED is not supported on this platform")
+}
@@ -0,0 +1,162 @@
+package connector
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
+	csilog "wujunyi792/oss-csi-lite-plugin/pkg/log"
+)
+
+// Version is the version of the framed protocol
+const Version = 1
+
+const (
+	// headerSize is the size of the big endian length in front of each frame
+	headerSize = 4
+	// MaxFrameSize limits the size of a frame. It keeps the first byte of a frame zero,
+	// which tells framed requests from the raw shell commands of the legacy protocol.
+	MaxFrameSize = 16 << 20
+	// DefaultTimeout is the deadline of a command when the request does not set one
+	DefaultTimeout = 2 * time.Minute
+)
+
+// Code is the typed result of a request
+type Code string
+
+const (
+	// CodeOK is a command exiting with 0
+	CodeOK Code = "OK"
+	// CodeInvalidRequest is a request the connector cannot decode
+	CodeInvalidRequest Code = "InvalidRequest"
+	// CodeUnsupportedVersion is a request of a protocol version the connector does not speak
+	CodeUnsupportedVersion Code = "UnsupportedVersion"
+	// CodeRejected is a command refused by the checks of the connector
+	CodeRejected Code = "Rejected"
+	// CodeExecFailed is a command exiting with a non-zero code or failing to start
+	CodeExecFailed Code = "ExecFailed"
+	// CodeTimeout is a command killed at its deadline
+	CodeTimeout Code = "Timeout"
+	// CodeInternal is a failure of the connector itself
+	CodeInternal Code = "Internal"
+)
+
+// Request is a command run on the host by the connector, either a Command line
+// or a typed Mount the connector builds the command of, or asks for the Status of the connector
+type Request struct {
+	Version int           `json:"version"`
+	ID      string        `json:"id"`
+	Command string        `json:"command,omitempty"`
+	Mount   *MountRequest `json:"mount,omitempty"`
+	Status  bool          `json:"status,omitempty"`
+	// TimeoutMillis is how long the command may run
+	TimeoutMillis int64 `json:"timeoutMillis,omitempty"`
+}
+
+// Timeout return the deadline of the request
+func (r *Request) Timeout() time.Duration {
+	if r.TimeoutMillis <= 0 {
+		return DefaultTimeout
+	}
+	return time.Duration(r.TimeoutMillis) * time.Millisecond
+}
+
+// Response is the result of a request
+type Response struct {
+	Version  int    `json:"version"`
+	ID       string `json:"id"`
+	Code     Code   `json:"code"`
+	ExitCode int    `json:"exitCode"`
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	// Message describes why a request is not OK
+	Message string `json:"message,omitempty"`
+	// Status answers a status request
+	Status *Status `json:"status,omitempty"`
+}
+
+// Err return the error of a response which is not OK
+func (r *Response) Err() error {
+	if r.Code == CodeOK {
+		return nil
+	}
+	return &Error{Code: r.Code, ExitCode: r.ExitCode, Message: r.Message, Stderr: r.Stderr}
+}
+
+// CommandResponse return the response of a command run with err, its stderr and message are redacted
+func CommandResponse(result *executor.Result, err error) *Response {
+	resp := &Response{Code: CodeOK, Stdout: result.Stdout, Stderr: csilog.Redact(result.Stderr)}
+	if err == nil {
+		return resp
+	}
+	resp.Code, resp.ExitCode, resp.Message = CodeExecFailed, result.ExitCode, csilog.Redact(err.Error())
+	if result.TimedOut {
+		resp.Code, resp.Message = CodeTimeout, "command is killed at its deadline"
+	}
+	return resp
+}
+
+// Error is the error of a response which is not OK
+type Error struct {
+	Code     Code
+	ExitCode int
+	Message  string
+	Stderr   string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("connector %s: %s", e.Code, e.Message)
+	if e.Code == CodeExecFailed {
+		msg += fmt.Sprintf(", exit code %d", e.ExitCode)
+	}
+	if e.Stderr != "" {
+		msg += ", stderr: " + e.Stderr
+	}
+	return msg
+}
+
+// WriteFrame write v as a length-prefixed json frame
+func WriteFrame(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(body) >= MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds %d", len(body), MaxFrameSize)
+	}
+	frame := make([]byte, headerSize+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	copy(frame[headerSize:], body)
+	_, err = w.Write(frame)
+	return err
+}
+
+// ReadFrame read a length-prefixed json frame into v
+func ReadFrame(r io.Reader, v interface{}) error {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	return readBody(r, header, v)
+}
+
+func readBody(r io.Reader, header []byte, v interface{}) error {
+	size := binary.BigEndian.Uint32(header)
+	if size >= MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds %d", size, MaxFrameSize)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// IsFramed return if a connection starting with b speaks the framed protocol,
+// the legacy protocol sends shell commands which never start with a zero byte
+func IsFramed(b byte) bool {
+	return b == 0
+}
@@ -0,0 +1,44 @@
+package connector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Handler run the requests a connector receives
+type Handler interface {
+	// Handle run a request of the framed protocol
+	Handle(req *Request) *Response
+	// HandleLegacy run a raw command of the legacy protocol and return the Success or Fail reply
+	HandleLegacy(cmd string) string
+}
+
+// ServeConn answer the request of a connection with the protocol of the client and close it
+func ServeConn(conn net.Conn, h Handler) error {
+	defer conn.Close()
+	// a legacy request is what the first read returns
+	buf := make([]byte, legacyBufferSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	if !IsFramed(buf[0]) {
+		_, err := conn.Write([]byte(h.HandleLegacy(string(buf[:n]))))
+		return err
+	}
+	req := &Request{}
+	if err := ReadFrame(io.MultiReader(bytes.NewReader(buf[:n]), conn), req); err != nil {
+		WriteFrame(conn, &Response{Version: Version, Code: CodeInvalidRequest, ExitCode: -1, Message: err.Error()})
+		return fmt.Errorf("read request: %v", err)
+	}
+	var resp *Response
+	if req.Version != Version {
+		resp = &Response{Code: CodeUnsupportedVersion, ExitCode: -1, Message: fmt.Sprintf("version %d is not supported, the connector speaks version %d", req.Version, Version)}
+	} else {
+		resp = h.Handle(req)
+	}
+	resp.Version, resp.ID = Version, req.ID
+	return WriteFrame(conn, resp)
+}
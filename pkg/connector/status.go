@@ -0,0 +1,200 @@
+package connector
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of requests counted in the status of the connector
+const (
+	RequestMount   = "mount"
+	RequestVersion = "version"
+	RequestNas     = "nas"
+	RequestCommand = "command"
+	RequestLegacy  = "legacy"
+	RequestStatus  = "status"
+)
+
+const (
+	// StatusAddressEnv is the localhost address of the optional status endpoint of the connector,
+	// GET /status answers the Status as JSON. The endpoint is disabled if it is empty.
+	StatusAddressEnv = "CONNECTOR_STATUS_ADDRESS"
+	// StatusPath is the path of the status endpoint
+	StatusPath = "/status"
+)
+
+// Status is what a connector reports about itself
+type Status struct {
+	Version       string                  `json:"version"`
+	StartTime     time.Time               `json:"startTime"`
+	UptimeSeconds float64                 `json:"uptimeSeconds"`
+	Requests      map[string]RequestStats `json:"requests"`
+	// Processes are the fuse processes started by typed mount requests
+	Processes []Process `json:"processes"`
+	// Lifecycle is the serving state of the connector
+	Lifecycle *Lifecycle `json:"lifecycle,omitempty"`
+}
+
+const (
+	// StateServing is a connector accepting requests
+	StateServing = "serving"
+	// StateDraining is a connector which stopped accepting requests and waits for those in flight
+	StateDraining = "draining"
+)
+
+// Lifecycle is the serving state of a connector, its reloads, its watchdog and how the previous connector exited
+type Lifecycle struct {
+	State    string `json:"state"`
+	InFlight int64  `json:"inFlight"`
+	// ReloadTime is the time of the last reload on SIGHUP, ReloadError is set if it failed
+	ReloadTime  *time.Time `json:"reloadTime,omitempty"`
+	ReloadError string     `json:"reloadError,omitempty"`
+	Watchdog    *Watchdog  `json:"watchdog,omitempty"`
+	// PreviousExit is how the previous connector on the host exited
+	PreviousExit *Exit `json:"previousExit,omitempty"`
+	// Handoff is the takeover of the sockets of the previous connector, or by the next one while draining
+	Handoff *Handoff `json:"handoff,omitempty"`
+}
+
+// Handoff is the switch of the listening sockets from a running connector to a new version
+type Handoff struct {
+	Time        time.Time `json:"time"`
+	FromVersion string    `json:"fromVersion"`
+	FromPID     int       `json:"fromPid"`
+	ToVersion   string    `json:"toVersion"`
+	ToPID       int       `json:"toPid"`
+	Sockets     []string  `json:"sockets"`
+	// Processes is the number of fuse processes whose supervision is handed over
+	Processes int `json:"processes"`
+}
+
+// Watchdog is the last check of the sockets of the plugins by the watchdog of the connector,
+// the connector exits when all of them are dead
+type Watchdog struct {
+	Time     time.Time        `json:"time"`
+	Sockets  []WatchdogSocket `json:"sockets"`
+	Decision string           `json:"decision"`
+}
+
+// WatchdogSocket is the liveness of a socket watched by the watchdog
+type WatchdogSocket struct {
+	Path  string `json:"path"`
+	Alive bool   `json:"alive"`
+	// Failures is the number of consecutive failed checks
+	Failures int    `json:"failures"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Exit is why and how a connector exited
+type Exit struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	// Drained is set if every request in flight finished before the drain deadline
+	Drained bool `json:"drained"`
+	// Abandoned is the number of requests still running at the deadline
+	Abandoned int64 `json:"abandoned,omitempty"`
+}
+
+// RequestStats are the counters of one type of requests
+type RequestStats struct {
+	Count  uint64 `json:"count"`
+	Errors uint64 `json:"errors"`
+	// DurationSeconds is the total time spent on the requests
+	DurationSeconds    float64 `json:"durationSeconds"`
+	MaxDurationSeconds float64 `json:"maxDurationSeconds"`
+}
+
+// Process is a fuse process started by the connector
+type Process struct {
+	Client    string    `json:"client"`
+	Unit      string    `json:"unit"`
+	Target    string    `json:"target"`
+	VolumeID  string    `json:"volumeId,omitempty"`
+	StartTime time.Time `json:"startTime"`
+	// PIDs are the processes in the scope of the fuse process
+	PIDs []int `json:"pids"`
+	// Alive is false once every process of the scope has exited
+	Alive bool `json:"alive"`
+	// Mounted is false once the target is no longer a mount point
+	Mounted bool `json:"mounted"`
+	// RestartPolicy is the restart policy of the process and Restarts how often it was restarted
+	RestartPolicy string `json:"restartPolicy,omitempty"`
+	Restarts      int    `json:"restarts"`
+	// LastExit is the last time the process exited while its target was mounted
+	LastExit *ProcessExit `json:"lastExit,omitempty"`
+}
+
+// ProcessExit describes a fuse process which exited while its target was mounted
+type ProcessExit struct {
+	Time time.Time `json:"time"`
+	// Result is how the scope of the process ended as reported by systemd, such as signal or
+	// oom-kill, and unknown for the process launcher
+	Result string `json:"result"`
+	// Logs are the last log lines of the process
+	Logs []string `json:"logs,omitempty"`
+	// Restarted is true if the connector restarted the process, Message tells why it did not
+	Restarted bool   `json:"restarted"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Stats count the requests of a connector by type
+type Stats struct {
+	lock     sync.Mutex
+	requests map[string]*RequestStats
+}
+
+// NewStats create empty stats
+func NewStats() *Stats {
+	return &Stats{requests: map[string]*RequestStats{}}
+}
+
+// Observe count a request of type kind which took d
+func (s *Stats) Observe(kind string, d time.Duration, failed bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, ok := s.requests[kind]
+	if !ok {
+		r = &RequestStats{}
+		s.requests[kind] = r
+	}
+	r.Count++
+	if failed {
+		r.Errors++
+	}
+	r.DurationSeconds += d.Seconds()
+	if d.Seconds() > r.MaxDurationSeconds {
+		r.MaxDurationSeconds = d.Seconds()
+	}
+}
+
+// Snapshot return a copy of the counters
+func (s *Stats) Snapshot() map[string]RequestStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snapshot := make(map[string]RequestStats, len(s.requests))
+	for kind, r := range s.requests {
+		snapshot[kind] = *r
+	}
+	return snapshot
+}
+
+// StatusAddress return the address configured by StatusAddressEnv, which must be a loopback
+// address as the endpoint is not authenticated. It is empty if the endpoint is disabled.
+func StatusAddress() (string, error) {
+	address := strings.TrimSpace(os.Getenv(StatusAddressEnv))
+	if address == "" {
+		return "", nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", StatusAddressEnv, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("%s: %s is not a loopback address", StatusAddressEnv, address)
+	}
+	return address, nil
+}
@@ -0,0 +1,288 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a command without a timeout whose context has no deadline
+	DefaultTimeout = 2 * time.Minute
+	// DefaultMaxOutput is the number of bytes kept of stdout and of stderr, the rest is discarded
+	DefaultMaxOutput = 1 << 20
+	// outputGrace is how long the output is read after the command exits, a daemon it started may keep the pipes open
+	outputGrace = time.Second
+)
+
+// Command is a command to run, its argv is run directly and never interpreted by a shell
+type Command struct {
+	// Argv is the program and its arguments
+	Argv []string
+	// Timeout bounds the command, 0 for the deadline of the context or DefaultTimeout
+	Timeout time.Duration
+	// MaxOutput is the number of bytes kept of stdout and of stderr, 0 for DefaultMaxOutput
+	MaxOutput int
+	// Combined writes stderr to Stdout of the result in order, like CombinedOutput of os/exec
+	Combined bool
+	// Setsid runs the command in a new session, a fuse client started by it is not bound to the caller
+	Setsid bool
+	// Env is the environment of the command, nil for the environment of the caller
+	Env []string
+}
+
+// Shell return the command running script with sh, for the commands of the plugin which are shell
+// pipelines. Prefer argv of the program, a script is interpreted by the shell.
+func Shell(script string) *Command {
+	return &Command{Argv: []string{"sh", "-c", script}}
+}
+
+// NewCommand return the command running argv
+func NewCommand(argv ...string) *Command {
+	return &Command{Argv: argv}
+}
+
+// String return the command line of the command
+func (c *Command) String() string {
+	return strings.Join(c.Argv, " ")
+}
+
+// Result is how a command ran
+type Result struct {
+	Argv   []string `json:"argv"`
+	Stdout string   `json:"stdout"`
+	Stderr string   `json:"stderr,omitempty"`
+	// ExitCode is the exit code of the command, -1 if it did not start or was killed
+	ExitCode int           `json:"exitCode"`
+	Duration time.Duration `json:"duration"`
+	// TimedOut is set if the command is killed at its deadline
+	TimedOut bool `json:"timedOut,omitempty"`
+	// Truncated is set if some output is discarded beyond MaxOutput
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Output return stdout and stderr of the result
+func (r *Result) Output() string {
+	return r.Stdout + r.Stderr
+}
+
+// Error is a command which did not start, failed or timed out, with its result
+type Error struct {
+	Result *Result
+	Err    error
+}
+
+func (e *Error) Error() string {
+	name := ""
+	if len(e.Result.Argv) > 0 {
+		name = e.Result.Argv[0]
+	}
+	if e.Result.TimedOut {
+		return fmt.Sprintf("%s is killed at its deadline after %s", name, e.Result.Duration.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%s: %v", name, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Executor run commands. Run return the result of the command, also with the error if the command
+// did not start, exited with a non-zero code or was killed at its deadline.
+type Executor interface {
+	Run(ctx context.Context, cmd *Command) (*Result, error)
+}
+
+// ExitCode return the exit code of the command failing with err, 0 if err is nil and -1 if it did not exit
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var execErr *Error
+	if errors.As(err, &execErr) {
+		return execErr.Result.ExitCode
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+type osExecutor struct{}
+
+// New return the executor running commands as processes. A command is run in its own process group,
+// the whole group is killed at the deadline.
+func New() Executor {
+	return osExecutor{}
+}
+
+func (osExecutor) Run(ctx context.Context, cmd *Command) (*Result, error) {
+	ctx, cancel := withTimeout(ctx, cmd.Timeout)
+	defer cancel()
+	result := &Result{Argv: cmd.Argv, ExitCode: -1}
+	start := time.Now()
+	err := run(ctx, cmd, result)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.TimedOut = ctx.Err() != nil
+		return result, &Error{Result: result, Err: err}
+	}
+	return result, nil
+}
+
+// withTimeout return ctx bounded by timeout, or by DefaultTimeout if neither sets a deadline
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, DefaultTimeout)
+}
+
+// run the command and fill the output and exit code of result. The output is read from pipes of
+// its own instead of those of os/exec, which are read until every process holding them exits.
+func run(ctx context.Context, cmd *Command, result *Result) error {
+	if len(cmd.Argv) == 0 {
+		return errors.New("empty command")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	limit := cmd.MaxOutput
+	if limit <= 0 {
+		limit = DefaultMaxOutput
+	}
+	c := exec.Command(cmd.Argv[0], cmd.Argv[1:]...)
+	c.Env = cmd.Env
+	if cmd.Setsid {
+		c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	} else {
+		c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	stdout, stderr := &limitedBuffer{limit: limit}, &limitedBuffer{limit: limit}
+	outputs := []*pipeReader{newPipeReader(stdout)}
+	if !cmd.Combined {
+		outputs = append(outputs, newPipeReader(stderr))
+	}
+	for _, o := range outputs {
+		if o.err != nil {
+			closeAll(outputs)
+			return o.err
+		}
+	}
+	c.Stdout, c.Stderr = outputs[0].w, outputs[len(outputs)-1].w
+	err := c.Start()
+	for _, o := range outputs {
+		o.w.Close()
+	}
+	if err != nil {
+		closeAll(outputs)
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// the command is the leader of its process group or session
+			syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		case <-exited:
+		}
+	}()
+	err = c.Wait()
+	close(exited)
+	grace := time.NewTimer(outputGrace)
+	defer grace.Stop()
+	for _, o := range outputs {
+		select {
+		case <-o.done:
+		case <-grace.C:
+			closeAll(outputs)
+			<-o.done
+		}
+	}
+	result.Stdout, result.Stderr = stdout.String(), stderr.String()
+	result.Truncated = stdout.truncated || stderr.truncated
+	if err == nil {
+		result.ExitCode = 0
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// pipeReader copy a pipe written by the command into a buffer
+type pipeReader struct {
+	r, w *os.File
+	err  error
+	done chan struct{}
+}
+
+func newPipeReader(buf io.Writer) *pipeReader {
+	p := &pipeReader{done: make(chan struct{})}
+	if p.r, p.w, p.err = os.Pipe(); p.err != nil {
+		close(p.done)
+		return p
+	}
+	go func() {
+		defer close(p.done)
+		io.Copy(buf, p.r)
+	}()
+	return p
+}
+
+// closeAll close the read ends of the pipes, the copies return
+func closeAll(pipes []*pipeReader) {
+	for _, p := range pipes {
+		if p.r != nil {
+			p.r.Close()
+		}
+		if p.w != nil {
+			p.w.Close()
+		}
+	}
+}
+
+// limitedBuffer keep the first limit bytes written to it
+type limitedBuffer struct {
+	lock      sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	b.buf.Write(p)
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
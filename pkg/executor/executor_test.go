@@ -0,0 +1,102 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	e := New()
+	result, err := e.Run(context.Background(), NewCommand("sh", "-c", "echo out; echo err >&2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "out\n", result.Stdout)
+	assert.Equal(t, "err\n", result.Stderr)
+	assert.Equal(t, 0, result.ExitCode)
+
+	cmd := Shell("echo out; echo err >&2")
+	cmd.Combined = true
+	result, err = e.Run(context.Background(), cmd)
+	assert.Nil(t, err)
+	assert.Equal(t, "out\nerr\n", result.Stdout)
+	assert.Equal(t, "", result.Stderr)
+
+	// arguments are not interpreted by a shell
+	result, err = e.Run(context.Background(), NewCommand("echo", "$HOME;", "id"))
+	assert.Nil(t, err)
+	assert.Equal(t, "$HOME; id\n", result.Stdout)
+
+	result, err = e.Run(context.Background(), Shell("echo failed; exit 3"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Equal(t, 3, ExitCode(err))
+	assert.Equal(t, "failed\n", result.Stdout)
+	var execErr *Error
+	assert.True(t, errors.As(err, &execErr))
+
+	result, err = e.Run(context.Background(), NewCommand("/nonexistent/binary"))
+	assert.NotNil(t, err)
+	assert.Equal(t, -1, result.ExitCode)
+
+	_, err = e.Run(context.Background(), &Command{})
+	assert.NotNil(t, err)
+}
+
+func TestRunTimeout(t *testing.T) {
+	e := New()
+	start := time.Now()
+	// the background sleep keeps the pipes open, it is killed with the process group
+	result, err := e.Run(context.Background(), &Command{Argv: []string{"sh", "-c", "sleep 30 & sleep 30"}, Timeout: 200 * time.Millisecond})
+	assert.NotNil(t, err)
+	assert.True(t, result.TimedOut)
+	assert.Equal(t, -1, result.ExitCode)
+	assert.True(t, strings.Contains(err.Error(), "deadline"))
+	assert.True(t, time.Since(start) < 10*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	result, err = e.Run(ctx, NewCommand("sleep", "30"))
+	assert.NotNil(t, err)
+	assert.True(t, result.TimedOut)
+
+	// a daemon leaving the pipes open does not block the command after it exits
+	start = time.Now()
+	result, err = e.Run(context.Background(), &Command{Argv: []string{"sh", "-c", "setsid sleep 5 & echo started"}, Timeout: 10 * time.Second})
+	assert.Nil(t, err)
+	assert.Equal(t, "started\n", result.Stdout)
+	assert.True(t, time.Since(start) < 4*time.Second)
+}
+
+func TestRunMaxOutput(t *testing.T) {
+	result, err := New().Run(context.Background(), &Command{Argv: []string{"sh", "-c", "head -c 10000 /dev/zero"}, MaxOutput: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(result.Stdout))
+	assert.True(t, result.Truncated)
+}
+
+func TestFake(t *testing.T) {
+	f := NewFake().On("mount", "ossfs on /mnt type fuse.ossfs\n", 0).On("umount", "not mounted", 32)
+	result, err := f.Run(context.Background(), NewCommand("mount"))
+	assert.Nil(t, err)
+	assert.Equal(t, "ossfs on /mnt type fuse.ossfs\n", result.Stdout)
+
+	result, err = f.Run(context.Background(), NewCommand("umount", "/mnt"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 32, ExitCode(err))
+	assert.Equal(t, "not mounted", result.Stdout)
+
+	result, err = f.Run(context.Background(), Shell("ls /"))
+	assert.Nil(t, err)
+	assert.Equal(t, "", result.Stdout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err = f.Run(ctx, NewCommand("mount"))
+	assert.NotNil(t, err)
+	assert.True(t, result.TimedOut)
+	assert.Equal(t, []string{"mount", "umount /mnt", "sh -c ls /", "mount"}, f.Commands())
+}
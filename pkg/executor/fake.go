@@ -0,0 +1,85 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Fake is an executor recording the commands instead of running them, to test the callers without root.
+// A command is answered by the last stub whose prefix its command line starts with, or succeeds without output.
+type Fake struct {
+	lock     sync.Mutex
+	commands []string
+	stubs    []fakeStub
+}
+
+type fakeStub struct {
+	prefix   string
+	stdout   string
+	exitCode int
+	// times is the number of commands the stub answers, any number if 0
+	times, used int
+}
+
+// NewFake return an executor without stubs
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+// On answer the commands whose command line starts with prefix with stdout and exitCode
+func (f *Fake) On(prefix, stdout string, exitCode int) *Fake {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.stubs = append(f.stubs, fakeStub{prefix: prefix, stdout: stdout, exitCode: exitCode})
+	return f
+}
+
+// OnTimes answer the next times commands whose command line starts with prefix with stdout and exitCode
+func (f *Fake) OnTimes(prefix, stdout string, exitCode, times int) *Fake {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.stubs = append(f.stubs, fakeStub{prefix: prefix, stdout: stdout, exitCode: exitCode, times: times})
+	return f
+}
+
+// Commands return the command lines run so far
+func (f *Fake) Commands() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func (f *Fake) Run(ctx context.Context, cmd *Command) (*Result, error) {
+	line := cmd.String()
+	f.lock.Lock()
+	f.commands = append(f.commands, line)
+	stub := fakeStub{}
+	for i := len(f.stubs) - 1; i >= 0; i-- {
+		s := &f.stubs[i]
+		if strings.HasPrefix(line, s.prefix) && (s.times == 0 || s.used < s.times) {
+			s.used++
+			stub = *s
+			break
+		}
+	}
+	f.lock.Unlock()
+
+	result := &Result{Argv: cmd.Argv, ExitCode: -1}
+	if err := ctx.Err(); err != nil {
+		result.TimedOut = true
+		return result, &Error{Result: result, Err: err}
+	}
+	limit := cmd.MaxOutput
+	if limit <= 0 {
+		limit = DefaultMaxOutput
+	}
+	out := &limitedBuffer{limit: limit}
+	out.Write([]byte(stub.stdout))
+	result.Stdout, result.Truncated, result.ExitCode = out.String(), out.truncated, stub.exitCode
+	if stub.exitCode != 0 {
+		return result, &Error{Result: result, Err: fmt.Errorf("exit status %d", stub.exitCode)}
+	}
+	return result, nil
+}
@@ -0,0 +1,104 @@
+package fuse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// OssfsClient is the client name of ossfs
+	OssfsClient = "ossfs"
+)
+
+// ossfsOptions maps each known ossfs -o option to the first release supporting it.
+// Options which are not listed here are generic fuse options and are passed through.
+var ossfsOptions = map[string]Version{
+	"allow_other":          MustParseVersion("1.80.0"),
+	"connect_timeout":      MustParseVersion("1.80.0"),
+	"default_acl":          MustParseVersion("1.80.0"),
+	"del_cache":            MustParseVersion("1.80.0"),
+	"enable_noobj_cache":   MustParseVersion("1.80.0"),
+	"ensure_diskfree":      MustParseVersion("1.80.0"),
+	"gid":                  MustParseVersion("1.80.0"),
+	"kernel_cache":         MustParseVersion("1.80.0"),
+	"max_stat_cache_size":  MustParseVersion("1.80.0"),
+	"mp_umask":             MustParseVersion("1.80.0"),
+	"multipart_size":       MustParseVersion("1.80.0"),
+	"multireq_max":         MustParseVersion("1.80.0"),
+	"nomultipart":          MustParseVersion("1.80.0"),
+	"noxmlns":              MustParseVersion("1.80.0"),
+	"parallel_count":       MustParseVersion("1.80.0"),
+	"passwd_file":          MustParseVersion("1.80.0"),
+	"public_bucket":        MustParseVersion("1.80.0"),
+	"readwrite_timeout":    MustParseVersion("1.80.0"),
+	"retries":              MustParseVersion("1.80.0"),
+	"stat_cache_expire":    MustParseVersion("1.80.0"),
+	"uid":                  MustParseVersion("1.80.0"),
+	"umask":                MustParseVersion("1.80.0"),
+	"url":                  MustParseVersion("1.80.0"),
+	"use_cache":            MustParseVersion("1.80.0"),
+	"dbglevel":             MustParseVersion("1.80.6"),
+	"ram_role":             MustParseVersion("1.80.6"),
+	"listobjectsv2":        MustParseVersion("1.91.1"),
+	"max_dirty_data":       MustParseVersion("1.91.1"),
+	"direct_read":          MustParseVersion("1.91.2"),
+	"direct_read_prefetch": MustParseVersion("1.91.2"),
+	"free_space_ratio":     MustParseVersion("1.91.2"),
+	"readdir_optimize":     MustParseVersion("1.91.3"),
+	"sigv4":                MustParseVersion("1.91.4"),
+	"region":               MustParseVersion("1.91.4"),
+}
+
+// Capability describes the version and the options supported by an installed fuse client
+type Capability struct {
+	Client  string
+	Version Version
+	options map[string]Version
+}
+
+// NewOssfsCapability return the capability of ossfs parsed from `ossfs --version` output
+func NewOssfsCapability(versionOutput string) (*Capability, error) {
+	v, err := ParseVersion(versionOutput)
+	if err != nil {
+		return nil, fmt.Errorf("parse ossfs version: %v", err)
+	}
+	return &Capability{Client: OssfsClient, Version: v, options: ossfsOptions}, nil
+}
+
+// Supports return false only if option is known and requires a newer client
+func (c *Capability) Supports(option string) bool {
+	since, ok := c.options[option]
+	if !ok {
+		return true
+	}
+	return !c.Version.LessThan(since)
+}
+
+// CheckOptions return an error listing every option that the installed client does not support
+func (c *Capability) CheckOptions(options []string) error {
+	var unsupported []string
+	for _, opt := range options {
+		if !c.Supports(opt) {
+			unsupported = append(unsupported, fmt.Sprintf("%s (requires %s >= %s)", opt, c.Client, c.options[opt]))
+		}
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+	sort.Strings(unsupported)
+	return fmt.Errorf("%w: %s %s on this node does not support %s", ErrUnsupportedOption, c.Client, c.Version, strings.Join(unsupported, ", "))
+}
+
+// ParseOptionNames return the option names of an otherOpts string,
+// e.g. "-o max_stat_cache_size=0 -oallow_other,ro" returns [max_stat_cache_size allow_other ro]
+func ParseOptionNames(otherOpts string) []string {
+	options, _ := ParseOptions(otherOpts)
+	var names []string
+	for _, opt := range options {
+		if name := strings.TrimSpace(optionName(opt)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,76 @@
+package fuse
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	examples := []struct {
+		output string
+		expect string
+	}{
+		{output: "Ossfs V1.91.2 (commit:abcdef0) with OpenSSL", expect: "1.91.2"},
+		{output: "Amazon Simple Storage Service File System V1.80.6(commit:0dfa2e0) with OpenSSL", expect: "1.80.6"},
+		{output: "version 6.2", expect: "6.2.0"},
+	}
+	for _, example := range examples {
+		t.Run(example.output, func(t *testing.T) {
+			v, err := ParseVersion(example.output)
+			assert.Nil(t, err)
+			assert.Equal(t, example.expect, v.String())
+		})
+	}
+
+	_, err := ParseVersion("command not found")
+	assert.NotNil(t, err)
+}
+
+func TestVersionLessThan(t *testing.T) {
+	assert.True(t, MustParseVersion("1.80.6").LessThan(MustParseVersion("1.91.2")))
+	assert.True(t, MustParseVersion("1.91.1").LessThan(MustParseVersion("1.91.2")))
+	assert.False(t, MustParseVersion("1.91.2").LessThan(MustParseVersion("1.91.2")))
+	assert.False(t, MustParseVersion("2.0.0").LessThan(MustParseVersion("1.91.2")))
+}
+
+func TestParseOptionNames(t *testing.T) {
+	names := ParseOptionNames("-o max_stat_cache_size=0 -o allow_other -odirect_read,ro -s")
+	assert.Equal(t, []string{"max_stat_cache_size", "allow_other", "direct_read", "ro"}, names)
+	assert.Empty(t, ParseOptionNames(""))
+}
+
+func TestCheckOptions(t *testing.T) {
+	options, flags := ParseOptions("-o max_stat_cache_size=0 -oallow_other,ro -d")
+	assert.Equal(t, []string{"max_stat_cache_size=0", "allow_other", "ro"}, options)
+	assert.Equal(t, []string{"-d"}, flags)
+	assert.Nil(t, CheckOptions(OssfsClient, options, flags))
+
+	assert.NotNil(t, CheckOptions(OssfsClient, []string{"passwd_file=/etc/passwd-ossfs"}, nil))
+	assert.NotNil(t, CheckOptions(OssfsClient, []string{"url=a,passwd_file=b"}, nil))
+	// the endpoint and the credential are set by the plugin
+	assert.NotNil(t, CheckOptions(OssfsClient, []string{"url=http://oss-cn-beijing.aliyuncs.com"}, nil))
+	assert.NotNil(t, CheckOptions(OssfsClient, []string{"ram_role=http://100.100.100.200/latest/meta-data/ram/security-credentials/role"}, nil))
+	assert.NotNil(t, CheckOptions(OssfsClient, []string{"use_cache=/etc"}, nil))
+	assert.NotNil(t, CheckOptions(OssfsClient, []string{"unknown_option"}, nil))
+	assert.NotNil(t, CheckOptions(OssfsClient, nil, []string{"-f"}))
+	assert.NotNil(t, CheckOptions(JindofsClient, []string{"fs.oss.provider.endpoint=ECS_ROLE"}, nil))
+	assert.NotNil(t, CheckOptions(JindofsClient, []string{"fs.oss.tmp.data.dirs=/etc"}, nil))
+	assert.NotNil(t, CheckOptions(JindofsClient, nil, []string{"-d"}))
+	assert.Nil(t, CheckOptions(JindofsClient, []string{"fs.oss.download.thread.concurrency=4"}, nil))
+}
+
+func TestCapabilityCheckOptions(t *testing.T) {
+	c, err := NewOssfsCapability("Ossfs V1.80.6 (commit:0dfa2e0) with OpenSSL")
+	assert.Nil(t, err)
+	assert.Nil(t, c.CheckOptions([]string{"allow_other", "max_stat_cache_size", "nonempty"}))
+
+	err = c.CheckOptions([]string{"allow_other", "direct_read"})
+	assert.True(t, errors.Is(err, ErrUnsupportedOption))
+	assert.Contains(t, err.Error(), "direct_read (requires ossfs >= 1.91.2)")
+
+	c, err = NewOssfsCapability("Ossfs V1.91.2")
+	assert.Nil(t, err)
+	assert.Nil(t, c.CheckOptions([]string{"direct_read"}))
+}
@@ -0,0 +1,374 @@
+package fuse
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// FuseSlice is the systemd slice holding the scopes of fuse processes
+	FuseSlice = "ossplugin.slice"
+	// HostProcRoot is the root directory of the host seen from the plugin, which runs with hostPID
+	HostProcRoot = "/proc/1/root"
+	// HostCgroupRoot is the cgroup filesystem of the host seen from the plugin
+	HostCgroupRoot = HostProcRoot + "/sys/fs/cgroup"
+	// scopePrefix is the prefix of the scope unit of every fuse process
+	scopePrefix = "ossplugin-"
+	// scopeSuffix is the suffix of scope units
+	scopeSuffix = ".scope"
+	// cfsPeriodUs is the cpu period the cpu quota of the process launcher is applied to
+	cfsPeriodUs = 100000
+)
+
+var (
+	unitNameInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+	cpuPercentPattern    = regexp.MustCompile(`^[0-9]+%$`)
+)
+
+// Resources are the limits applied to the scope of a fuse process
+type Resources struct {
+	// MemoryMax in bytes, 0 means unlimited
+	MemoryMax int64
+	// CPUQuota in percent of one cpu, 0 means unlimited
+	CPUQuota int64
+	// TasksMax, 0 means the systemd default
+	TasksMax int64
+}
+
+// ParseResources parse the fuseMemoryLimit, fuseCPUQuota and fuseTasksMax volume attributes.
+// memory is a quantity such as 512Mi, cpu is either a percent such as 150% or a quantity such as 500m.
+func ParseResources(memory, cpu, tasks string) (Resources, error) {
+	r := Resources{}
+	if memory != "" {
+		q, err := resource.ParseQuantity(memory)
+		if err != nil || q.Value() <= 0 {
+			return r, fmt.Errorf("invalid fuseMemoryLimit %s", memory)
+		}
+		r.MemoryMax = q.Value()
+	}
+	if cpu != "" {
+		if cpuPercentPattern.MatchString(cpu) {
+			r.CPUQuota, _ = strconv.ParseInt(strings.TrimSuffix(cpu, "%"), 10, 64)
+		} else if q, err := resource.ParseQuantity(cpu); err == nil {
+			r.CPUQuota = q.MilliValue() / 10
+		}
+		if r.CPUQuota <= 0 {
+			return r, fmt.Errorf("invalid fuseCPUQuota %s", cpu)
+		}
+	}
+	if tasks != "" {
+		n, err := strconv.ParseInt(tasks, 10, 64)
+		if err != nil || n <= 0 {
+			return r, fmt.Errorf("invalid fuseTasksMax %s", tasks)
+		}
+		r.TasksMax = n
+	}
+	return r, nil
+}
+
+// Properties return the systemd scope properties of the resources
+func (r Resources) Properties() []string {
+	var props []string
+	if r.MemoryMax > 0 {
+		props = append(props, fmt.Sprintf("MemoryMax=%d", r.MemoryMax))
+	}
+	if r.CPUQuota > 0 {
+		props = append(props, fmt.Sprintf("CPUQuota=%d%%", r.CPUQuota))
+	}
+	if r.TasksMax > 0 {
+		props = append(props, fmt.Sprintf("TasksMax=%d", r.TasksMax))
+	}
+	return props
+}
+
+// ScopeUnit return the scope unit name of the fuse process serving a mount point of a volume
+func ScopeUnit(volumeID, mountPointKey string) string {
+	vol := unitNameInvalidChars.ReplaceAllString(volumeID, "_")
+	if len(vol) > 200 {
+		vol = vol[:200]
+	}
+	return scopePrefix + vol + "-" + mountPointKey + scopeSuffix
+}
+
+// ParseScopeUnit return the volume of a scope unit created by ScopeUnit
+func ParseScopeUnit(unit string) (string, bool) {
+	if !strings.HasPrefix(unit, scopePrefix) || !strings.HasSuffix(unit, scopeSuffix) {
+		return "", false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(unit, scopePrefix), scopeSuffix)
+	index := strings.LastIndex(name, "-")
+	if index <= 0 {
+		return "", false
+	}
+	return name[:index], true
+}
+
+// ScopeStat is the resource usage of the scope of a fuse process
+type ScopeStat struct {
+	Unit        string
+	VolumeID    string
+	MemoryBytes uint64
+	CPUSeconds  float64
+	Tasks       uint64
+	OOMKills    uint64
+}
+
+// isCgroupV2 return true if root is a cgroup v2 unified hierarchy
+func isCgroupV2(root string) bool {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	return err == nil
+}
+
+// ListScopeStats read the usage of every fuse scope from the cgroup filesystem
+func ListScopeStats(root string) ([]ScopeStat, error) {
+	var stats []ScopeStat
+	for _, parent := range cgroupParents() {
+		s, err := listScopeStats(root, parent)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, s...)
+	}
+	return stats, nil
+}
+
+func listScopeStats(root, parent string) ([]ScopeStat, error) {
+	v2 := isCgroupV2(root)
+	// the systemd hierarchy only holds the scopes of systemd, the memory one those of the process launcher
+	parentDirs := []string{filepath.Join(root, "systemd", parent), filepath.Join(root, "memory", parent)}
+	if v2 {
+		parentDirs = []string{filepath.Join(root, parent)}
+	}
+	units := map[string]bool{}
+	var stats []ScopeStat
+	for _, parentDir := range parentDirs {
+		entries, err := ioutil.ReadDir(parentDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			volumeID, ok := ParseScopeUnit(entry.Name())
+			if !entry.IsDir() || !ok || units[entry.Name()] {
+				continue
+			}
+			units[entry.Name()] = true
+			s := ScopeStat{Unit: entry.Name(), VolumeID: volumeID}
+			if v2 {
+				dir := filepath.Join(parentDir, entry.Name())
+				s.MemoryBytes = readCgroupUint(filepath.Join(dir, "memory.current"))
+				s.CPUSeconds = float64(readCgroupKey(filepath.Join(dir, "cpu.stat"), "usage_usec")) / 1e6
+				s.Tasks = readCgroupUint(filepath.Join(dir, "pids.current"))
+				s.OOMKills = readCgroupKey(filepath.Join(dir, "memory.events"), "oom_kill")
+			} else {
+				s.MemoryBytes = readCgroupUint(filepath.Join(root, "memory", parent, entry.Name(), "memory.usage_in_bytes"))
+				s.CPUSeconds = float64(readCgroupUint(filepath.Join(root, "cpuacct", parent, entry.Name(), "cpuacct.usage"))) / 1e9
+				s.Tasks = readCgroupUint(filepath.Join(root, "pids", parent, entry.Name(), "pids.current"))
+				s.OOMKills = readCgroupKey(filepath.Join(root, "memory", parent, entry.Name(), "memory.oom_control"), "oom_kill")
+			}
+			stats = append(stats, s)
+		}
+	}
+	return stats, nil
+}
+
+// SliceOOMKills return the number of fuse processes killed by the oom killer in the cgroups
+// holding fuse scopes, it keeps counting after the scope of a killed process is removed
+func SliceOOMKills(root string) (uint64, error) {
+	var kills uint64
+	found := false
+	for _, parent := range cgroupParents() {
+		path := filepath.Join(root, "memory", parent, "memory.oom_control")
+		if isCgroupV2(root) {
+			path = filepath.Join(root, parent, "memory.events")
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		found = true
+		kills += readCgroupKey(path, "oom_kill")
+	}
+	if !found {
+		return 0, os.ErrNotExist
+	}
+	return kills, nil
+}
+
+// CreateScopeCgroup create the cgroup of a fuse process started by the process launcher, apply
+// its resource limits and return the cgroup.procs files the process must join
+func CreateScopeCgroup(root, parent, unit string, r Resources) ([]string, error) {
+	if isCgroupV2(root) {
+		if err := enableControllers(root, parent); err != nil {
+			return nil, err
+		}
+		dir := filepath.Join(root, parent, unit)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		limits := map[string]string{}
+		if r.MemoryMax > 0 {
+			limits["memory.max"] = strconv.FormatInt(r.MemoryMax, 10)
+		}
+		if r.CPUQuota > 0 {
+			limits["cpu.max"] = fmt.Sprintf("%d %d", r.CPUQuota*cfsPeriodUs/100, cfsPeriodUs)
+		}
+		if r.TasksMax > 0 {
+			limits["pids.max"] = strconv.FormatInt(r.TasksMax, 10)
+		}
+		if err := writeCgroupFiles(dir, limits); err != nil {
+			return nil, err
+		}
+		return []string{filepath.Join(dir, "cgroup.procs")}, nil
+	}
+
+	var procs []string
+	controllers := []struct {
+		name   string
+		limits map[string]string
+	}{
+		{"memory", map[string]string{}},
+		{"cpu,cpuacct", map[string]string{}},
+		{"pids", map[string]string{}},
+	}
+	if r.MemoryMax > 0 {
+		controllers[0].limits["memory.limit_in_bytes"] = strconv.FormatInt(r.MemoryMax, 10)
+	}
+	if r.CPUQuota > 0 {
+		controllers[1].limits["cpu.cfs_period_us"] = strconv.FormatInt(cfsPeriodUs, 10)
+		controllers[1].limits["cpu.cfs_quota_us"] = strconv.FormatInt(r.CPUQuota*cfsPeriodUs/100, 10)
+	}
+	if r.TasksMax > 0 {
+		controllers[2].limits["pids.max"] = strconv.FormatInt(r.TasksMax, 10)
+	}
+	for _, c := range controllers {
+		if _, err := os.Stat(filepath.Join(root, c.name)); err != nil {
+			// the controller is not mounted, its limit cannot be enforced
+			if len(c.limits) > 0 {
+				return nil, fmt.Errorf("cgroup controller %s is not available", c.name)
+			}
+			continue
+		}
+		dir := filepath.Join(root, c.name, parent, unit)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := writeCgroupFiles(dir, c.limits); err != nil {
+			return nil, err
+		}
+		procs = append(procs, filepath.Join(dir, "cgroup.procs"))
+	}
+	return procs, nil
+}
+
+// RemoveScopeCgroup remove the cgroups of a fuse process which has exited
+func RemoveScopeCgroup(root, parent, unit string) {
+	if isCgroupV2(root) {
+		os.Remove(filepath.Join(root, parent, unit))
+		return
+	}
+	for _, c := range []string{"memory", "cpu,cpuacct", "pids"} {
+		os.Remove(filepath.Join(root, c, parent, unit))
+	}
+}
+
+// ScopePIDs return the processes in the cgroup of a fuse scope, none once the fuse process has exited
+func ScopePIDs(root, parent, unit string) []int {
+	dirs := []string{filepath.Join(root, parent, unit)}
+	if !isCgroupV2(root) {
+		// the systemd hierarchy holds the scopes of systemd, the memory one those of the process launcher
+		dirs = []string{filepath.Join(root, "systemd", parent, unit), filepath.Join(root, "memory", parent, unit)}
+	}
+	for _, dir := range dirs {
+		raw, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.procs"))
+		if err != nil {
+			continue
+		}
+		var pids []int
+		for _, field := range strings.Fields(string(raw)) {
+			if pid, err := strconv.Atoi(field); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+		return pids
+	}
+	return nil
+}
+
+// ReapScopeCgroups remove the cgroups of parent whose fuse process has exited, the kernel
+// refuses to remove a cgroup which still holds processes
+func ReapScopeCgroups(root, parent string) {
+	dir := filepath.Join(root, parent)
+	if !isCgroupV2(root) {
+		dir = filepath.Join(root, "memory", parent)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if _, ok := ParseScopeUnit(entry.Name()); ok && entry.IsDir() {
+			RemoveScopeCgroup(root, parent, entry.Name())
+		}
+	}
+}
+
+// enableControllers delegate the controllers of the fuse limits from the cgroup root down to parent
+func enableControllers(root, parent string) error {
+	dir := root
+	for _, name := range strings.Split(parent, "/") {
+		if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+memory +cpu +pids"), 0644); err != nil {
+			return fmt.Errorf("enable cgroup controllers in %s: %v", dir, err)
+		}
+		dir = filepath.Join(dir, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+memory +cpu +pids"), 0644); err != nil {
+		return fmt.Errorf("enable cgroup controllers in %s: %v", dir, err)
+	}
+	return nil
+}
+
+func writeCgroupFiles(dir string, files map[string]string) error {
+	for name, value := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+			return fmt.Errorf("set %s of cgroup %s: %v", name, dir, err)
+		}
+	}
+	return nil
+}
+
+func readCgroupUint(path string) uint64 {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	value, _ := strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
+	return value
+}
+
+// readCgroupKey read the value of key in a flat keyed cgroup file such as memory.events
+func readCgroupKey(path, key string) uint64 {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			value, _ := strconv.ParseUint(fields[1], 10, 64)
+			return value
+		}
+	}
+	return 0
+}
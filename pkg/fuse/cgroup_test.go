@@ -0,0 +1,132 @@
+package fuse
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResources(t *testing.T) {
+	cases := []struct {
+		name               string
+		memory, cpu, tasks string
+		want               Resources
+		wantErr            bool
+	}{
+		{name: "empty", want: Resources{}},
+		{name: "all", memory: "512Mi", cpu: "150%", tasks: "64", want: Resources{MemoryMax: 512 << 20, CPUQuota: 150, TasksMax: 64}},
+		{name: "cpu quantity", cpu: "500m", want: Resources{CPUQuota: 50}},
+		{name: "cpu cores", cpu: "2", want: Resources{CPUQuota: 200}},
+		{name: "invalid memory", memory: "lots", wantErr: true},
+		{name: "invalid cpu", cpu: "-1%", wantErr: true},
+		{name: "invalid tasks", tasks: "0", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := ParseResources(c.memory, c.cpu, c.tasks)
+			if c.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, c.want, r)
+		})
+	}
+}
+
+func TestLaunch(t *testing.T) {
+	unit := ScopeUnit("oss-csi-pv", "0123456789abcdef")
+	assert.Equal(t, "ossplugin-oss-csi-pv-0123456789abcdef.scope", unit)
+	volumeID, ok := ParseScopeUnit(unit)
+	assert.True(t, ok)
+	assert.Equal(t, "oss-csi-pv", volumeID)
+
+	r := Resources{MemoryMax: 1024, CPUQuota: 50}
+	l := NewLaunch(LauncherSystemd, unit, r, "/usr/local/bin/ossfs b:/ /mnt -ourl=x")
+	assert.Equal(t, "systemd-run --scope --slice=ossplugin.slice --unit="+unit+" -p MemoryMax=1024 -p CPUQuota=50% --", l.Prefix())
+	parsed, err := ParseLaunch(l.String())
+	assert.Nil(t, err)
+	assert.Equal(t, l, parsed)
+
+	l = NewLaunch(LauncherProcess, unit, r, "/usr/local/bin/ossfs b:/ /mnt -ourl=x")
+	assert.Equal(t, "csi-launch --cgroup-parent=ossplugin.slice --unit="+unit+" -p MemoryMax=1024 -p CPUQuota=50% --", l.Prefix())
+	parsed, err = ParseLaunch(l.String())
+	assert.Nil(t, err)
+	assert.Equal(t, l, parsed)
+
+	parsed, err = ParseLaunch("ossfs b:/ /mnt -ourl=x")
+	assert.Nil(t, err)
+	assert.Equal(t, "", parsed.Launcher)
+
+	for _, cmd := range []string{
+		"systemd-run --scope -p ExecStartPre=/bin/sh -- ossfs b:/ /mnt",
+		"systemd-run --slice=ossplugin.slice -- ossfs b:/ /mnt",
+		"csi-launch --cgroup-parent=../.. --unit=" + unit + " -- ossfs b:/ /mnt",
+		"csi-launch --unit=" + unit + " -- ossfs b:/ /mnt",
+		"csi-launch --cgroup-parent=ossplugin.slice --unit=" + unit + " ossfs b:/ /mnt",
+	} {
+		_, err := ParseLaunch(cmd)
+		assert.NotNil(t, err, cmd)
+	}
+}
+
+func TestCreateScopeCgroupV2(t *testing.T) {
+	root := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory pids"), 0644))
+	procs, err := CreateScopeCgroup(root, "ossplugin.slice", "ossplugin-pv1-0123456789abcdef.scope", Resources{MemoryMax: 1024, CPUQuota: 150, TasksMax: 8})
+	assert.Nil(t, err)
+	dir := filepath.Join(root, "ossplugin.slice", "ossplugin-pv1-0123456789abcdef.scope")
+	assert.Equal(t, []string{filepath.Join(dir, "cgroup.procs")}, procs)
+	for name, want := range map[string]string{"memory.max": "1024", "cpu.max": "150000 100000", "pids.max": "8"} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		assert.Equal(t, want, string(got))
+	}
+	subtree, _ := ioutil.ReadFile(filepath.Join(root, "ossplugin.slice", "cgroup.subtree_control"))
+	assert.Equal(t, "+memory +cpu +pids", string(subtree))
+}
+
+func TestListScopeStatsV2(t *testing.T) {
+	root := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory pids"), 0644))
+	scope := filepath.Join(root, FuseSlice, ScopeUnit("pv1", "0123456789abcdef"))
+	assert.Nil(t, os.MkdirAll(scope, 0755))
+	files := map[string]string{
+		"memory.current": "1048576\n",
+		"cpu.stat":       "usage_usec 2500000\nuser_usec 2000000\n",
+		"pids.current":   "5\n",
+		"memory.events":  "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+	}
+	for name, content := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(scope, name), []byte(content), 0644))
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, FuseSlice, "memory.events"), []byte("oom_kill 2\n"), 0644))
+
+	stats, err := ListScopeStats(root)
+	assert.Nil(t, err)
+	assert.Equal(t, []ScopeStat{{
+		Unit:        ScopeUnit("pv1", "0123456789abcdef"),
+		VolumeID:    "pv1",
+		MemoryBytes: 1048576,
+		CPUSeconds:  2.5,
+		Tasks:       5,
+		OOMKills:    1,
+	}}, stats)
+	kills, err := SliceOOMKills(root)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), kills)
+}
+
+func TestScopePIDs(t *testing.T) {
+	root := t.TempDir()
+	unit := ScopeUnit("pv1", "0123456789abcdef")
+	assert.Empty(t, ScopePIDs(root, FuseSlice, unit))
+
+	scope := filepath.Join(root, "systemd", FuseSlice, unit)
+	assert.Nil(t, os.MkdirAll(scope, 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(scope, "cgroup.procs"), []byte("123\n456\n"), 0644))
+	assert.Equal(t, []int{123, 456}, ScopePIDs(root, FuseSlice, unit))
+}
@@ -0,0 +1,319 @@
+package fuse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// InstallModeEnv selects how fuse clients are provided on the node
+	InstallModeEnv = "FUSE_INSTALL_MODE"
+	// InstallModeHost use fuse clients installed on the host by the administrator
+	InstallModeHost = "host"
+	// InstallModeManaged install the fuse clients shipped in the plugin image on the host
+	InstallModeManaged = "managed"
+
+	// ImageManifest lists the fuse clients shipped in the plugin image
+	ImageManifest = "/csi/fuse/manifest.json"
+	// ManagedRoot is the host directory holding managed fuse clients
+	ManagedRoot = "/usr/local/oss-csi/fuse"
+	// HostPrefix is where the host root directories are mounted in the plugin container
+	HostPrefix = "/host"
+	// currentLink points to the active version of a managed client
+	currentLink = "current"
+)
+
+// Manifest lists the pinned fuse clients shipped in the plugin image
+type Manifest struct {
+	Clients []ManifestClient `json:"clients"`
+}
+
+// ManifestClient is one pinned version of a fuse client
+type ManifestClient struct {
+	Name       string         `json:"name"`
+	Version    string         `json:"version"`
+	Entrypoint string         `json:"entrypoint"`
+	Files      []ManifestFile `json:"files"`
+}
+
+// ManifestFile is a file of a fuse client, relative to the client version directory
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+	Mode   string `json:"mode"`
+}
+
+// IsManagedInstall return true if fuse clients are installed by the plugin
+func IsManagedInstall() bool {
+	return strings.TrimSpace(os.Getenv(InstallModeEnv)) == InstallModeManaged
+}
+
+// ManagedBinary return the host path of the active managed client binary
+func ManagedBinary(client string) string {
+	return filepath.Join(ManagedRoot, client, currentLink, client)
+}
+
+// IsManagedClientInstalled return true if the plugin installed a version of client on the host
+func IsManagedClientInstalled(client string) bool {
+	_, err := os.Stat(filepath.Join(HostPrefix, ManagedBinary(client)))
+	return err == nil
+}
+
+// Installer copies the fuse clients of the image to the host
+type Installer struct {
+	// ImageDir is the directory holding the manifest and the client files in the image
+	ImageDir string
+	// HostRoot is the managed root as seen from the plugin container
+	HostRoot string
+	// HostRootOnHost is the managed root as seen from the host
+	HostRootOnHost string
+	// ProcRoot is used to find processes still running a managed binary
+	ProcRoot string
+	// Registry lists named versions volumes may select, they are kept installed
+	Registry *Registry
+}
+
+// NewInstaller return an installer using the default image and host layout
+func NewInstaller() *Installer {
+	return &Installer{
+		ImageDir:       filepath.Dir(ImageManifest),
+		HostRoot:       filepath.Join(HostPrefix, ManagedRoot),
+		HostRootOnHost: ManagedRoot,
+		ProcRoot:       "/proc",
+	}
+}
+
+// LoadManifest read the manifest from the image directory
+func (i *Installer) LoadManifest() (*Manifest, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(i.ImageDir, filepath.Base(ImageManifest)))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, fmt.Errorf("parse fuse manifest: %v", err)
+	}
+	return m, nil
+}
+
+// Install copies every client of the manifest to the host, switches its current link
+// and removes older versions which are not used by any running process.
+func (i *Installer) Install() error {
+	m, err := i.LoadManifest()
+	if err != nil {
+		return err
+	}
+	for _, c := range m.Clients {
+		if err := i.installClient(c); err != nil {
+			return fmt.Errorf("install %s %s: %v", c.Name, c.Version, err)
+		}
+		log.Infof("Install fuse client %s %s is successfully", c.Name, c.Version)
+		i.removeUnusedVersions(c)
+	}
+	return nil
+}
+
+func (i *Installer) installClient(c ManifestClient) error {
+	if c.Name == "" || c.Version == "" || c.Entrypoint == "" || strings.Contains(c.Name+c.Version, "/") {
+		return fmt.Errorf("invalid manifest entry: %+v", c)
+	}
+	srcDir := filepath.Join(i.ImageDir, c.Name, c.Version)
+	if err := verifyFiles(srcDir, c.Files); err != nil {
+		return fmt.Errorf("image files are corrupted: %v", err)
+	}
+
+	clientDir := filepath.Join(i.HostRoot, c.Name)
+	versionDir := filepath.Join(clientDir, c.Version)
+	if err := verifyFiles(versionDir, c.Files); err != nil {
+		// versions are never overwritten in place, running mounts may still use the files
+		if _, statErr := os.Stat(versionDir); statErr == nil {
+			if i.isVersionInUse(c.Name, c.Version) {
+				return fmt.Errorf("installed files are corrupted but still in use: %v", err)
+			}
+			if err := os.RemoveAll(versionDir); err != nil {
+				return err
+			}
+		}
+		tmpDir := filepath.Join(clientDir, fmt.Sprintf(".%s.tmp-%d", c.Version, os.Getpid()))
+		if err := copyFiles(srcDir, tmpDir, c.Files); err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+		if err := verifyFiles(tmpDir, c.Files); err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+		if err := writeLauncher(tmpDir, filepath.Join(i.HostRootOnHost, c.Name, c.Version), c); err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+		if err := os.Rename(tmpDir, versionDir); err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+	}
+	return switchLink(clientDir, c.Version)
+}
+
+// writeLauncher create the <client> script which runs the entrypoint with the shipped libraries
+func writeLauncher(dir, dirOnHost string, c ManifestClient) error {
+	script := fmt.Sprintf("#!/bin/sh\nLD_LIBRARY_PATH=%s${LD_LIBRARY_PATH:+:$LD_LIBRARY_PATH} exec %s \"$@\"\n",
+		filepath.Join(dirOnHost, "lib"), filepath.Join(dirOnHost, c.Entrypoint))
+	return ioutil.WriteFile(filepath.Join(dir, c.Name), []byte(script), 0755)
+}
+
+// switchLink atomically points <clientDir>/current to version
+func switchLink(clientDir, version string) error {
+	link := filepath.Join(clientDir, currentLink)
+	if target, err := os.Readlink(link); err == nil && target == version {
+		return nil
+	}
+	tmpLink := link + ".tmp"
+	os.Remove(tmpLink)
+	if err := os.Symlink(version, tmpLink); err != nil {
+		return err
+	}
+	return os.Rename(tmpLink, link)
+}
+
+// removeUnusedVersions delete the versions of a client other than the current one,
+// unless a running process still executes one of their binaries
+func (i *Installer) removeUnusedVersions(c ManifestClient) {
+	entries, err := ioutil.ReadDir(filepath.Join(i.HostRoot, c.Name))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || name == c.Version || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if i.isVersionInUse(c.Name, name) {
+			log.Infof("Fuse client %s %s is still used by running mounts, keep it", c.Name, name)
+			continue
+		}
+		if i.isVersionRegistered(c.Name, name) {
+			log.Infof("Fuse client %s %s is listed in the fuse registry, keep it", c.Name, name)
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(i.HostRoot, c.Name, name)); err != nil {
+			log.Errorf("Remove fuse client %s %s is failed, err: %v", c.Name, name, err)
+		} else {
+			log.Infof("Remove unused fuse client %s %s", c.Name, name)
+		}
+	}
+}
+
+// isVersionRegistered return true if the registry lists the managed version of a client
+func (i *Installer) isVersionRegistered(client, version string) bool {
+	if i.Registry == nil {
+		return false
+	}
+	for _, binary := range i.Registry.Clients[client].Versions {
+		if binary == ManagedVersionBinary(client, version) {
+			return true
+		}
+	}
+	return false
+}
+
+// isVersionInUse return true if a process on the host runs a binary of the version.
+// The plugin runs with hostPID, so the processes of the host are visible in ProcRoot.
+func (i *Installer) isVersionInUse(client, version string) bool {
+	prefix := filepath.Join(i.HostRootOnHost, client, version) + "/"
+	procs, err := ioutil.ReadDir(i.ProcRoot)
+	if err != nil {
+		// be conservative when processes cannot be listed
+		return true
+	}
+	for _, proc := range procs {
+		if _, err := strconv.Atoi(proc.Name()); err != nil {
+			continue
+		}
+		exe, err := os.Readlink(filepath.Join(i.ProcRoot, proc.Name(), "exe"))
+		if err == nil && strings.HasPrefix(exe, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyFiles(dir string, files []ManifestFile) error {
+	for _, f := range files {
+		sum, err := fileSha256(filepath.Join(dir, f.Path))
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sum, f.Sha256) {
+			return fmt.Errorf("checksum mismatch for %s: expect %s, got %s", f.Path, f.Sha256, sum)
+		}
+	}
+	return nil
+}
+
+func copyFiles(srcDir, dstDir string, files []ManifestFile) error {
+	for _, f := range files {
+		if filepath.IsAbs(f.Path) || strings.Contains(f.Path, "..") {
+			return fmt.Errorf("invalid file path in manifest: %s", f.Path)
+		}
+		mode := os.FileMode(0644)
+		if f.Mode != "" {
+			m, err := strconv.ParseUint(f.Mode, 8, 32)
+			if err != nil {
+				return fmt.Errorf("invalid mode %s for %s", f.Mode, f.Path)
+			}
+			mode = os.FileMode(m)
+		}
+		if err := copyFile(filepath.Join(srcDir, f.Path), filepath.Join(dstDir, f.Path), mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func fileSha256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,87 @@
+package fuse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeImageClient(t *testing.T, imageDir, version, content string) ManifestClient {
+	binary := filepath.Join(imageDir, "ossfs", version, "bin", "ossfs")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(binary), 0755))
+	assert.Nil(t, ioutil.WriteFile(binary, []byte(content), 0755))
+	sum := sha256.Sum256([]byte(content))
+	return ManifestClient{
+		Name:       "ossfs",
+		Version:    version,
+		Entrypoint: "bin/ossfs",
+		Files:      []ManifestFile{{Path: "bin/ossfs", Sha256: hex.EncodeToString(sum[:]), Mode: "0755"}},
+	}
+}
+
+func writeManifest(t *testing.T, imageDir string, clients ...ManifestClient) {
+	raw, err := json.Marshal(Manifest{Clients: clients})
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(imageDir, "manifest.json"), raw, 0644))
+}
+
+func TestInstallerInstall(t *testing.T) {
+	root := t.TempDir()
+	installer := &Installer{
+		ImageDir:       filepath.Join(root, "image"),
+		HostRoot:       filepath.Join(root, "host"),
+		HostRootOnHost: "/usr/local/oss-csi/fuse",
+		ProcRoot:       filepath.Join(root, "proc"),
+	}
+	assert.Nil(t, os.MkdirAll(installer.ProcRoot, 0755))
+
+	old := writeImageClient(t, installer.ImageDir, "1.80.6", "old ossfs")
+	writeManifest(t, installer.ImageDir, old)
+	assert.Nil(t, installer.Install())
+	target, err := os.Readlink(filepath.Join(installer.HostRoot, "ossfs", "current"))
+	assert.Nil(t, err)
+	assert.Equal(t, "1.80.6", target)
+	launcher, err := ioutil.ReadFile(filepath.Join(installer.HostRoot, "ossfs", "1.80.6", "ossfs"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(launcher), "exec /usr/local/oss-csi/fuse/ossfs/1.80.6/bin/ossfs")
+
+	// a running mount still uses the old binary
+	assert.Nil(t, os.MkdirAll(filepath.Join(installer.ProcRoot, "42"), 0755))
+	assert.Nil(t, os.Symlink("/usr/local/oss-csi/fuse/ossfs/1.80.6/bin/ossfs", filepath.Join(installer.ProcRoot, "42", "exe")))
+
+	assert.Nil(t, os.RemoveAll(installer.ImageDir))
+	newer := writeImageClient(t, installer.ImageDir, "1.91.2", "new ossfs")
+	writeManifest(t, installer.ImageDir, newer)
+	assert.Nil(t, installer.Install())
+	target, _ = os.Readlink(filepath.Join(installer.HostRoot, "ossfs", "current"))
+	assert.Equal(t, "1.91.2", target)
+	assert.DirExists(t, filepath.Join(installer.HostRoot, "ossfs", "1.80.6"))
+
+	// the old version is removed once no process uses it
+	assert.Nil(t, os.RemoveAll(filepath.Join(installer.ProcRoot, "42")))
+	assert.Nil(t, installer.Install())
+	_, err = os.Stat(filepath.Join(installer.HostRoot, "ossfs", "1.80.6"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInstallerRejectCorruptedImage(t *testing.T) {
+	root := t.TempDir()
+	installer := &Installer{
+		ImageDir:       filepath.Join(root, "image"),
+		HostRoot:       filepath.Join(root, "host"),
+		HostRootOnHost: "/usr/local/oss-csi/fuse",
+		ProcRoot:       filepath.Join(root, "proc"),
+	}
+	c := writeImageClient(t, installer.ImageDir, "1.91.2", "ossfs")
+	c.Files[0].Sha256 = "0000"
+	writeManifest(t, installer.ImageDir, c)
+	assert.NotNil(t, installer.Install())
+	_, err := os.Lstat(filepath.Join(installer.HostRoot, "ossfs", "current"))
+	assert.True(t, os.IsNotExist(err))
+}
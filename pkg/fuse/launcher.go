@@ -0,0 +1,238 @@
+package fuse
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// LauncherEnv selects how fuse processes are started on the host
+	LauncherEnv = "FUSE_LAUNCHER"
+	// LauncherAuto use systemd when the host runs it, and a detached process otherwise
+	LauncherAuto = "auto"
+	// LauncherSystemd start fuse processes in transient systemd scopes
+	LauncherSystemd = "systemd"
+	// LauncherProcess start fuse processes detached in their own session and cgroup
+	LauncherProcess = "process"
+	// CgroupParentEnv is the cgroup, relative to the cgroup root, holding the cgroups of
+	// fuse processes started by the process launcher
+	CgroupParentEnv = "FUSE_CGROUP_PARENT"
+	// LaunchCommand is handled by the connector itself to start a detached fuse process:
+	// csi-launch --cgroup-parent=<parent> --unit=<unit> [-p <property>]... -- <fuse command>
+	LaunchCommand = "csi-launch"
+	// JoinCgroupArg re-executes the connector or the plugin to join cgroups and exec a fuse command
+	// without a shell: <binary> --join-cgroup <cgroup.procs>... -- <argv>
+	JoinCgroupArg = "--join-cgroup"
+	// systemdRuntimeDir exists when systemd is the init system of a host
+	systemdRuntimeDir = "/run/systemd/system"
+)
+
+var (
+	scopeUnitPattern     = regexp.MustCompile(`^ossplugin-[A-Za-z0-9_.-]+\.scope$`)
+	cgroupParentPattern  = regexp.MustCompile(`^[A-Za-z0-9_.-]+(/[A-Za-z0-9_.-]+)*$`)
+	scopePropertyPattern = regexp.MustCompile(`^(MemoryMax=[0-9]+|CPUQuota=[0-9]+%|TasksMax=[0-9]+)$`)
+)
+
+// Launch is a fuse command started in its own scope by one of the launchers
+type Launch struct {
+	// Launcher is empty if the command is run as is
+	Launcher     string
+	Unit         string
+	CgroupParent string
+	Resources    Resources
+	Command      string
+}
+
+// HasSystemd return true if the host whose root directory is root runs systemd
+func HasSystemd(root string) bool {
+	info, err := os.Stat(filepath.Join(root, systemdRuntimeDir))
+	return err == nil && info.IsDir()
+}
+
+// SelectLauncher return the launcher configured for the host whose root directory is root
+func SelectLauncher(root string) string {
+	switch launcher := strings.TrimSpace(os.Getenv(LauncherEnv)); launcher {
+	case LauncherSystemd, LauncherProcess:
+		return launcher
+	default:
+		if HasSystemd(root) {
+			return LauncherSystemd
+		}
+		return LauncherProcess
+	}
+}
+
+// CgroupParent return the cgroup holding the fuse processes of the process launcher
+func CgroupParent() string {
+	if parent := strings.Trim(strings.TrimSpace(os.Getenv(CgroupParentEnv)), "/"); parent != "" {
+		return parent
+	}
+	return FuseSlice
+}
+
+// cgroupParents return the cgroups which may hold fuse scopes
+func cgroupParents() []string {
+	if parent := CgroupParent(); parent != FuseSlice {
+		return []string{FuseSlice, parent}
+	}
+	return []string{FuseSlice}
+}
+
+// NewLaunch return the launch of command in the scope unit with the selected launcher
+func NewLaunch(launcher, unit string, r Resources, command string) *Launch {
+	l := &Launch{Launcher: launcher, Unit: unit, Resources: r, Command: command}
+	if launcher == LauncherProcess {
+		l.CgroupParent = CgroupParent()
+	}
+	return l
+}
+
+// PrefixArgs return the arguments of the launcher in front of the fuse command, ending with --
+func (l *Launch) PrefixArgs() []string {
+	var args []string
+	switch l.Launcher {
+	case LauncherSystemd:
+		args = []string{"systemd-run", "--scope", "--slice=" + FuseSlice, "--unit=" + l.Unit}
+	case LauncherProcess:
+		args = []string{LaunchCommand, "--cgroup-parent=" + l.CgroupParent, "--unit=" + l.Unit}
+	default:
+		return nil
+	}
+	for _, p := range l.Resources.Properties() {
+		args = append(args, "-p", p)
+	}
+	return append(args, "--")
+}
+
+// Prefix return the launcher part of the command line
+func (l *Launch) Prefix() string {
+	return strings.Join(l.PrefixArgs(), " ")
+}
+
+// String return the whole command line of the launch
+func (l *Launch) String() string {
+	if prefix := l.Prefix(); prefix != "" {
+		return prefix + " " + l.Command
+	}
+	return l.Command
+}
+
+// ParseLaunch validate the launcher options of a command line built by Launch.String,
+// a command without launcher is returned as is with an empty Launcher.
+func ParseLaunch(cmd string) (*Launch, error) {
+	l := &Launch{}
+	var options []string
+	switch {
+	case strings.HasPrefix(cmd, "systemd-run "):
+		l.Launcher = LauncherSystemd
+		options = strings.Fields(strings.TrimPrefix(cmd, "systemd-run "))
+	case strings.HasPrefix(cmd, LaunchCommand+" "):
+		l.Launcher = LauncherProcess
+		options = strings.Fields(strings.TrimPrefix(cmd, LaunchCommand+" "))
+	default:
+		l.Command = cmd
+		return l, nil
+	}
+	index := strings.Index(cmd, " -- ")
+	if index < 0 {
+		return nil, errors.New("launcher options: missing -- before the fuse command: " + cmd)
+	}
+	l.Command = strings.TrimSpace(cmd[index+len(" -- "):])
+	scope := false
+	for i := 0; i < len(options) && options[i] != "--"; i++ {
+		option := options[i]
+		switch {
+		case option == "--scope" && l.Launcher == LauncherSystemd:
+			scope = true
+		case option == "--slice="+FuseSlice && l.Launcher == LauncherSystemd:
+		case strings.HasPrefix(option, "--cgroup-parent=") && l.Launcher == LauncherProcess:
+			l.CgroupParent = strings.TrimPrefix(option, "--cgroup-parent=")
+			if !cgroupParentPattern.MatchString(l.CgroupParent) || strings.Contains(l.CgroupParent, "..") {
+				return nil, errors.New("launcher options: invalid cgroup parent " + l.CgroupParent)
+			}
+		case strings.HasPrefix(option, "--unit=") && scopeUnitPattern.MatchString(strings.TrimPrefix(option, "--unit=")):
+			l.Unit = strings.TrimPrefix(option, "--unit=")
+		case option == "-p" && i+1 < len(options) && scopePropertyPattern.MatchString(options[i+1]):
+			l.Resources.setProperty(options[i+1])
+			i++
+		default:
+			return nil, errors.New("launcher options: not allowed option " + option)
+		}
+	}
+	if l.Launcher == LauncherSystemd && !scope {
+		return nil, errors.New("launcher options: fuse process must run in a scope: " + cmd)
+	}
+	if l.Launcher == LauncherProcess && (l.Unit == "" || l.CgroupParent == "") {
+		return nil, fmt.Errorf("launcher options: %s requires --unit and --cgroup-parent: %s", LaunchCommand, cmd)
+	}
+	return l, nil
+}
+
+// Validate check the launcher options of a launch built from a typed request, like ParseLaunch does for command lines
+func (l *Launch) Validate() error {
+	switch l.Launcher {
+	case "":
+		return nil
+	case LauncherSystemd, LauncherProcess:
+	default:
+		return errors.New("launcher options: unknown launcher " + l.Launcher)
+	}
+	if !scopeUnitPattern.MatchString(l.Unit) {
+		return errors.New("launcher options: invalid unit " + l.Unit)
+	}
+	if l.Launcher == LauncherProcess && (!cgroupParentPattern.MatchString(l.CgroupParent) || strings.Contains(l.CgroupParent, "..")) {
+		return errors.New("launcher options: invalid cgroup parent " + l.CgroupParent)
+	}
+	if l.Resources.MemoryMax < 0 || l.Resources.CPUQuota < 0 || l.Resources.TasksMax < 0 {
+		return fmt.Errorf("launcher options: invalid resources %+v", l.Resources)
+	}
+	return nil
+}
+
+// setProperty set the resource of a property validated by scopePropertyPattern
+func (r *Resources) setProperty(property string) {
+	kv := strings.SplitN(property, "=", 2)
+	value, _ := strconv.ParseInt(strings.TrimSuffix(kv[1], "%"), 10, 64)
+	switch kv[0] {
+	case "MemoryMax":
+		r.MemoryMax = value
+	case "CPUQuota":
+		r.CPUQuota = value
+	case "TasksMax":
+		r.TasksMax = value
+	}
+}
+
+// JoinCgroupAndExec handle JoinCgroupArg: write the pid to the cgroup.procs files and exec
+// the command in place, args are the procs files, -- and the argv of the command
+func JoinCgroupAndExec(args []string) {
+	index := -1
+	for i, arg := range args {
+		if arg == "--" {
+			index = i
+			break
+		}
+	}
+	if index < 0 || index == len(args)-1 {
+		fmt.Fprintf(os.Stderr, "%s: missing -- before the fuse command\n", JoinCgroupArg)
+		os.Exit(2)
+	}
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	for _, p := range args[:index] {
+		if err := os.WriteFile(p, pid, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "join cgroup %s: %v\n", p, err)
+			os.Exit(1)
+		}
+	}
+	argv := args[index+1:]
+	if err := syscall.Exec(argv[0], argv, os.Environ()); err != nil {
+		fmt.Fprintf(os.Stderr, "exec %s: %v\n", argv[0], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,44 @@
+package fuse
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// TargetLockDir is the host directory of the locks of mount targets. The connector holds the lock of a
+// target while it restarts its fuse process, the plugin while it unmounts the target. The lock files
+// are kept, removing one would race with its next lock, and /var/run is cleared at boot.
+const TargetLockDir = "/var/run/oss-csi/locks"
+
+// lockRetryPeriod is the interval to try a lock held by another process again
+const lockRetryPeriod = 100 * time.Millisecond
+
+// LockTarget take the lock of target in TargetLockDir under root, the host root directory, within timeout.
+// It return the unlock.
+func LockTarget(root, target string, timeout time.Duration) (func(), error) {
+	dir := filepath.Join(root, TargetLockDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	key := sha256.Sum256([]byte(filepath.Clean(target)))
+	f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%x.lock", key[:8])), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return func() { f.Close() }, nil
+		}
+		if err != syscall.EWOULDBLOCK || time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("lock target %s: %v", target, err)
+		}
+		time.Sleep(lockRetryPeriod)
+	}
+}
@@ -0,0 +1,34 @@
+package fuse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockTarget(t *testing.T) {
+	root := t.TempDir()
+	unlock, err := LockTarget(root, "/mnt/a", time.Second)
+	if !assert.Nil(t, err) {
+		return
+	}
+	// the lock of a target is exclusive, other targets are not locked
+	_, err = LockTarget(root, "/mnt/a/", 200*time.Millisecond)
+	assert.NotNil(t, err)
+	unlockOther, err := LockTarget(root, "/mnt/b", time.Second)
+	assert.Nil(t, err)
+	unlockOther()
+
+	locked := make(chan error, 1)
+	go func() {
+		unlock, err := LockTarget(root, "/mnt/a", 5*time.Second)
+		if err == nil {
+			unlock()
+		}
+		locked <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	unlock()
+	assert.Nil(t, <-locked)
+}
@@ -0,0 +1,126 @@
+package fuse
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// CredentialDir is the host directory holding the credential file of every mount point
+	CredentialDir = "/etc/oss-csi/credentials"
+	// CredentialTokenEnv holds the secret of a mount point in the environment of ossfs, the credential
+	// server answers only the process holding it. It is never on the command line, which any user can read.
+	CredentialTokenEnv = "OSS_CSI_CREDENTIAL_TOKEN"
+)
+
+// optionPattern is a fuse option, a name or name=value. Commas separate fuse options,
+// a value with a comma would smuggle more options past the checks.
+var optionPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+(=[^,\s]*)?$`)
+
+// allowedOptions are the option names each client accepts from otherOpts. Options the plugin sets from the
+// volume and its credential, such as url, passwd_file and ram_role of ossfs, and options taking a host path,
+// such as use_cache, are not listed: a volume could point the fuse process at any file of the node.
+var allowedOptions = map[string][]string{
+	OssfsClient: {
+		// generic fuse options
+		"allow_other", "allow_root", "ro", "rw", "nonempty", "default_permissions", "auto_cache", "direct_io",
+		"attr_timeout", "entry_timeout", "negative_timeout", "max_read", "max_write", "max_background", "big_writes",
+		"noatime", "nodiratime", "auto_unmount",
+		// ossfs options
+		"connect_timeout", "default_acl", "del_cache", "enable_noobj_cache", "ensure_diskfree", "gid",
+		"kernel_cache", "max_stat_cache_size", "mp_umask", "multipart_size", "multireq_max", "nomultipart",
+		"noxmlns", "parallel_count", "public_bucket", "readwrite_timeout", "retries", "stat_cache_expire", "uid",
+		"umask", "dbglevel", "listobjectsv2", "max_dirty_data", "direct_read", "direct_read_prefetch",
+		"free_space_ratio", "readdir_optimize", "sigv4", "region",
+	},
+	JindofsClient: {
+		// generic fuse options
+		"allow_other", "ro", "auto_cache", "kernel_cache", "direct_io", "attr_timeout", "entry_timeout",
+		"negative_timeout", "max_idle_threads",
+		// jindo-fuse options
+		"fs.oss.download.thread.concurrency", "fs.oss.download.queue.size", "fs.oss.upload.thread.concurrency",
+		"fs.oss.upload.queue.size", "fs.oss.upload.max.pending.tasks.per.stream", "fs.oss.read.buffer.size",
+		"fs.oss.read.readahead.buffer.count", "fs.oss.read.readahead.buffer.size", "fs.oss.read.sequence.ambiguity.range",
+		"fs.oss.write.buffer.size", "fs.oss.flush.interval.millisecond", "fs.oss.tmp.data.cleaner.enable",
+	},
+}
+
+// allowedFlags are the flags besides -o accepted by each client
+var allowedFlags = map[string][]string{
+	OssfsClient: {"-s", "-d", "--debug"},
+}
+
+// ParseOptions split an otherOpts string into the fuse options and the other flags,
+// e.g. "-o max_stat_cache_size=0 -oallow_other,ro -d" returns [max_stat_cache_size=0 allow_other ro] and [-d]
+func ParseOptions(otherOpts string) ([]string, []string) {
+	var options, flags []string
+	expectValue := false
+	for _, field := range strings.Fields(otherOpts) {
+		var value string
+		switch {
+		case field == "-o":
+			expectValue = true
+			continue
+		case expectValue:
+			value = field
+		case strings.HasPrefix(field, "-o"):
+			value = strings.TrimPrefix(field, "-o")
+		default:
+			flags = append(flags, field)
+			continue
+		}
+		expectValue = false
+		for _, opt := range strings.Split(value, ",") {
+			if opt = strings.TrimSpace(opt); opt != "" {
+				options = append(options, opt)
+			}
+		}
+	}
+	return options, flags
+}
+
+// optionName return the name of a name=value option
+func optionName(option string) string {
+	return strings.SplitN(option, "=", 2)[0]
+}
+
+// CheckOptionNames reject the option names which are not in the allow-list of client
+func CheckOptionNames(client string, names []string) error {
+	for _, name := range names {
+		if !containsString(allowedOptions[client], name) {
+			return fmt.Errorf("option %s is not allowed in otherOpts of %s", name, client)
+		}
+	}
+	return nil
+}
+
+// CheckOptions validate the options and flags of client passed from otherOpts, it is the
+// allow-list shared by the plugin and the connector
+func CheckOptions(client string, options, flags []string) error {
+	names := make([]string, 0, len(options))
+	for _, option := range options {
+		if !optionPattern.MatchString(option) {
+			return fmt.Errorf("invalid option %q of %s", option, client)
+		}
+		names = append(names, optionName(option))
+	}
+	if err := CheckOptionNames(client, names); err != nil {
+		return err
+	}
+	for _, flag := range flags {
+		if !containsString(allowedFlags[client], flag) {
+			return fmt.Errorf("flag %s of %s is not allowed", flag, client)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
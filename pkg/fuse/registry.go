@@ -0,0 +1,196 @@
+package fuse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// JindofsClient is the name of the jindo-fuse client
+	JindofsClient = "jindofs"
+	// DefaultOssfsBinary is where the administrator installs ossfs on the host
+	DefaultOssfsBinary = "/usr/local/bin/ossfs"
+	// DefaultJindofsBinary is where the administrator installs jindo-fuse on the host
+	DefaultJindofsBinary = "/etc/jindofs-tool/jindo-fuse"
+
+	// RegistryEnv is the path of the admin-defined registry in the plugin container
+	RegistryEnv = "FUSE_REGISTRY"
+	// DefaultRegistryPath is where the registry config map is mounted in the plugin container
+	DefaultRegistryPath = "/etc/oss-csi/fuse-clients.json"
+	// HostRegistryPath is the copy of the registry on the host read by the connector
+	HostRegistryPath = "/etc/oss-csi/fuse-clients.json"
+)
+
+var binaryPathPattern = regexp.MustCompile(`^/[A-Za-z0-9_.+/-]+$`)
+
+// Registry lets the administrator relocate the fuse clients of each backend and
+// install named versions side by side, which volumes select with the fuseVersion attribute.
+//
+//	{"clients": {"ossfs": {"binary": "/usr/local/bin/ossfs", "versions": {"1.91.4-rc1": "/opt/ossfs/1.91.4-rc1/bin/ossfs"}}}}
+type Registry struct {
+	Clients map[string]RegistryClient `json:"clients"`
+}
+
+// RegistryClient is the configuration of one fuse client
+type RegistryClient struct {
+	// Binary replaces the default binary of the client
+	Binary string `json:"binary,omitempty"`
+	// Versions maps a version name to its binary
+	Versions map[string]string `json:"versions,omitempty"`
+}
+
+// DefaultBinary return the built-in binary path of a client
+func DefaultBinary(client string) string {
+	if client == JindofsClient {
+		return DefaultJindofsBinary
+	}
+	return DefaultOssfsBinary
+}
+
+// ManagedVersionBinary return the host path of a version of a managed client
+func ManagedVersionBinary(client, version string) string {
+	return filepath.Join(ManagedRoot, client, version, client)
+}
+
+// LoadRegistry read the registry at path, a missing file is an empty registry
+func LoadRegistry(path string) (*Registry, error) {
+	r := &Registry{}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, r); err != nil {
+		return nil, fmt.Errorf("parse fuse registry %s: %v", path, err)
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Validate reject binary paths which cannot be passed safely on a command line
+func (r *Registry) Validate() error {
+	for client, c := range r.Clients {
+		if client != OssfsClient && client != JindofsClient {
+			return fmt.Errorf("fuse registry: unknown client %s", client)
+		}
+		if c.Binary != "" && !isValidBinaryPath(c.Binary) {
+			return fmt.Errorf("fuse registry: invalid binary %q of %s", c.Binary, client)
+		}
+		for version, binary := range c.Versions {
+			if !isValidBinaryPath(binary) || strings.ContainsAny(version, "/ ") {
+				return fmt.Errorf("fuse registry: invalid version %q of %s: %q", version, client, binary)
+			}
+		}
+	}
+	return nil
+}
+
+func isValidBinaryPath(path string) bool {
+	return binaryPathPattern.MatchString(path) && !strings.Contains(path, "..")
+}
+
+// Binary return the host path of the binary of client, version selects a named version.
+// root is where the host root directory is seen, it is used to find managed clients.
+func (r *Registry) Binary(client, version, root string) (string, error) {
+	c := r.Clients[client]
+	if version != "" {
+		if binary, ok := c.Versions[version]; ok {
+			return binary, nil
+		}
+		if !strings.ContainsAny(version, "/ ") && version != currentLink {
+			if _, err := os.Stat(filepath.Join(root, ManagedVersionBinary(client, version))); err == nil {
+				return ManagedVersionBinary(client, version), nil
+			}
+		}
+		return "", fmt.Errorf("%s version %s is not installed on this node", client, version)
+	}
+	if IsManagedInstall() {
+		if _, err := os.Stat(filepath.Join(root, ManagedBinary(client))); err == nil {
+			return ManagedBinary(client), nil
+		}
+	}
+	if c.Binary != "" {
+		return c.Binary, nil
+	}
+	return DefaultBinary(client), nil
+}
+
+// AllowedBinaries return every binary of client the connector may run
+func (r *Registry) AllowedBinaries(client string) []string {
+	binaries := []string{DefaultBinary(client), ManagedBinary(client)}
+	c := r.Clients[client]
+	if c.Binary != "" {
+		binaries = append(binaries, c.Binary)
+	}
+	for _, binary := range c.Versions {
+		binaries = append(binaries, binary)
+	}
+	sort.Strings(binaries)
+	return binaries
+}
+
+var managedVersionPattern = regexp.MustCompile(`^` + regexp.QuoteMeta(ManagedRoot) + `/(ossfs|jindofs)/[A-Za-z0-9_.+-]+/(ossfs|jindofs)$`)
+
+// MatchBinary return the client and binary a fuse command line starts with
+func (r *Registry) MatchBinary(cmd string) (string, string) {
+	binary := strings.SplitN(cmd, " ", 2)[0]
+	for _, client := range []string{OssfsClient, JindofsClient} {
+		for _, allowed := range r.AllowedBinaries(client) {
+			if binary == allowed {
+				return client, binary
+			}
+		}
+	}
+	if m := managedVersionPattern.FindStringSubmatch(binary); m != nil && m[1] == m[2] && !strings.Contains(binary, "..") {
+		return m[1], binary
+	}
+	return "", ""
+}
+
+// SyncRegistry copy the registry of the plugin container to the host for the connector,
+// the host copy is removed when no registry is configured
+func SyncRegistry(src, dst string) error {
+	raw, err := ioutil.ReadFile(src)
+	if os.IsNotExist(err) {
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := LoadRegistry(src); err != nil {
+		return err
+	}
+	if old, err := ioutil.ReadFile(dst); err == nil && bytes.Equal(old, raw) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// RegistryPath return the path of the registry in the plugin container
+func RegistryPath() string {
+	if path := strings.TrimSpace(os.Getenv(RegistryEnv)); path != "" {
+		return path
+	}
+	return DefaultRegistryPath
+}
@@ -0,0 +1,74 @@
+package fuse
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryBinary(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "fuse-clients.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"clients": {"ossfs": {"binary": "/opt/ossfs/bin/ossfs", "versions": {"1.91.4-rc1": "/opt/ossfs-1.91.4-rc1/bin/ossfs"}}}}`), 0644))
+	r, err := LoadRegistry(path)
+	assert.Nil(t, err)
+
+	binary, err := r.Binary(OssfsClient, "", root)
+	assert.Nil(t, err)
+	assert.Equal(t, "/opt/ossfs/bin/ossfs", binary)
+	binary, err = r.Binary(OssfsClient, "1.91.4-rc1", root)
+	assert.Nil(t, err)
+	assert.Equal(t, "/opt/ossfs-1.91.4-rc1/bin/ossfs", binary)
+	_, err = r.Binary(OssfsClient, "2.0.0", root)
+	assert.NotNil(t, err)
+	binary, err = r.Binary(JindofsClient, "", root)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultJindofsBinary, binary)
+
+	// versions installed side by side by the managed installer are selectable too
+	managed := filepath.Join(root, ManagedVersionBinary(OssfsClient, "1.80.6"))
+	assert.Nil(t, os.MkdirAll(filepath.Dir(managed), 0755))
+	assert.Nil(t, ioutil.WriteFile(managed, []byte("#!/bin/sh"), 0755))
+	binary, err = r.Binary(OssfsClient, "1.80.6", root)
+	assert.Nil(t, err)
+	assert.Equal(t, ManagedVersionBinary(OssfsClient, "1.80.6"), binary)
+}
+
+func TestRegistryMatchBinary(t *testing.T) {
+	r := &Registry{Clients: map[string]RegistryClient{
+		OssfsClient: {Versions: map[string]string{"canary": "/opt/ossfs-canary/ossfs"}},
+	}}
+	cases := []struct {
+		cmd    string
+		client string
+	}{
+		{"/usr/local/bin/ossfs b:/ /mnt -ourl=x", OssfsClient},
+		{"/opt/ossfs-canary/ossfs b:/ /mnt -ourl=x", OssfsClient},
+		{"/usr/local/oss-csi/fuse/ossfs/1.91.2/ossfs b:/ /mnt", OssfsClient},
+		{"/usr/local/oss-csi/fuse/ossfs/../../bin/ossfs b:/ /mnt", ""},
+		{"/etc/jindofs-tool/jindo-fuse /mnt -ouri=oss://b/", JindofsClient},
+		{"/usr/local/bin/ossfs2 b:/ /mnt", ""},
+		{"/bin/sh -c id", ""},
+	}
+	for _, c := range cases {
+		client, _ := r.MatchBinary(c.cmd)
+		assert.Equal(t, c.client, client, c.cmd)
+	}
+}
+
+func TestRegistryValidate(t *testing.T) {
+	for _, raw := range []string{
+		`{"clients": {"ossfs": {"binary": "ossfs"}}}`,
+		`{"clients": {"ossfs": {"binary": "/usr/bin/ossfs; rm -rf /"}}}`,
+		`{"clients": {"ossfs": {"versions": {"a b": "/usr/bin/ossfs"}}}}`,
+		`{"clients": {"s3fs": {"binary": "/usr/bin/s3fs"}}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "fuse-clients.json")
+		assert.Nil(t, ioutil.WriteFile(path, []byte(raw), 0644))
+		_, err := LoadRegistry(path)
+		assert.NotNil(t, err, raw)
+	}
+}
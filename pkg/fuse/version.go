@@ -0,0 +1,66 @@
+package fuse
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// versionRegexp matches version strings like "V1.91.2", "1.80.6" or "6.2.0"
+var versionRegexp = regexp.MustCompile(`[vV]?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// Version is the semantic version of an installed fuse client
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion return the first version found in s
+func ParseVersion(s string) (Version, error) {
+	match := versionRegexp.FindStringSubmatch(s)
+	if match == nil {
+		return Version{}, fmt.Errorf("no version found in %q", s)
+	}
+	v := Version{}
+	v.Major, _ = strconv.Atoi(match[1])
+	v.Minor, _ = strconv.Atoi(match[2])
+	if match[3] != "" {
+		v.Patch, _ = strconv.Atoi(match[3])
+	}
+	return v, nil
+}
+
+// MustParseVersion is like ParseVersion but panics if s contains no version
+func MustParseVersion(s string) Version {
+	v, err := ParseVersion(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// String return the version as major.minor.patch
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// IsZero return true if the version is unknown
+func (v Version) IsZero() bool {
+	return v == Version{}
+}
+
+// LessThan return true if v is older than o
+func (v Version) LessThan(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+// ErrUnsupportedOption is returned when a mount option is not supported by the installed client
+var ErrUnsupportedOption = errors.New("unsupported fuse option")
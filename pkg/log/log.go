@@ -30,7 +30,10 @@ func NewLogger(driver string) *logrus.Logger {
 		logrus.ErrorLevel: writer,
 	}
 
+	// secrets are redacted before any hook writes the entries
+	logrus.AddHook(RedactHook{})
 	Log = logrus.New()
+	Log.Hooks.Add(RedactHook{})
 	Log.Hooks.Add(lfshook.NewHook(
 		writerMap,
 		&Formatter{
@@ -0,0 +1,60 @@
+package log
+
+import (
+	"io"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces the values of secrets
+const Redacted = "******"
+
+var (
+	// secretValuePattern match the value after key: or key= of a secret, quoted or not, as printed
+	// by %v of maps, %+v of structs, JSON and fuse options such as fs.oss.accessKeySecret=
+	secretValuePattern = regexp.MustCompile(`(?i)((?:ak_?secret|access_?key_?secret|security_?token|token|password|passwd)"?\s*[:=]\s*"?)([^"\s,&>\]}]+)`)
+	// secretEntryPattern match the value of a map entry with a secret key printed by protobuf, key:"akSecret" value:"..."
+	secretEntryPattern = regexp.MustCompile(`(?i)(key:"[^"]*(?:secret|token|password)[^"]*"\s+value:")((?:[^"\\]|\\.)*)`)
+)
+
+// Redact mask the values of AccessKey secrets, security tokens, service account tokens and passwords in s
+func Redact(s string) string {
+	s = secretEntryPattern.ReplaceAllString(s, "${1}"+Redacted)
+	return secretValuePattern.ReplaceAllString(s, "${1}"+Redacted)
+}
+
+// RedactHook redact the message and string fields of log entries, it is added before the hooks writing them
+type RedactHook struct{}
+
+// Levels return all levels
+func (RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire redact the entry in place
+func (RedactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = Redact(entry.Message)
+	for k, v := range entry.Data {
+		if s, ok := v.(string); ok {
+			entry.Data[k] = Redact(s)
+		}
+	}
+	return nil
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+// RedactWriter return a writer redacting everything written to w, for loggers without hooks
+func RedactWriter(w io.Writer) io.Writer {
+	return &redactWriter{w: w}
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write([]byte(Redact(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
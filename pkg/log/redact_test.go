@@ -0,0 +1,44 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"map", "map[akId:id akSecret:secret bucket:b]", "map[akId:id akSecret:****** bucket:b]"},
+		{"protobuf", `secrets:<key:"akSecret" value:"se\"cret" > volume_context:<key:"bucket" value:"b" >`, `secrets:<key:"akSecret" value:"******" > volume_context:<key:"bucket" value:"b" >`},
+		{"json", `{"AccessKeyId":"id","AccessKeySecret":"secret","SecurityToken":"token"}`, `{"AccessKeyId":"id","AccessKeySecret":"******","SecurityToken":"******"}`},
+		{"service account token", `{"sts.aliyuncs.com":{"token":"eyJ","expirationTimestamp":"2026-01-01T00:00:00Z"}}`, `{"sts.aliyuncs.com":{"token":"******","expirationTimestamp":"2026-01-01T00:00:00Z"}}`},
+		{"fuse option", "jindo-fuse /mnt -ofs.oss.accessKeySecret=secret -oallow_other", "jindo-fuse /mnt -ofs.oss.accessKeySecret=****** -oallow_other"},
+		{"plain", "mount /mnt with passwd_file=/etc/oss-csi/credentials/a.passwd", "mount /mnt with passwd_file=/etc/oss-csi/credentials/a.passwd"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, Redact(test.input))
+		})
+	}
+}
+
+func TestRedactHook(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(out)
+	logger.AddHook(RedactHook{})
+	logger.WithField("secrets", "akSecret=secret").Infof("volume context: %v", map[string]string{"akSecret": "secret"})
+	assert.NotContains(t, out.String(), "secret]")
+	assert.NotContains(t, out.String(), "=secret")
+
+	out.Reset()
+	fmt.Fprintf(RedactWriter(out), "Fail: %s", "-ofs.oss.accessKeySecret=secret")
+	assert.Equal(t, "Fail: -ofs.oss.accessKeySecret=******", out.String())
+}
@@ -0,0 +1,116 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AliyunURL is the metadata service of ECS instances
+	AliyunURL = "http://100.100.100.200/latest/meta-data/"
+	// RAMRoleResource is the sub path of the credentials of the ram role of the instance
+	RAMRoleResource = "ram/security-credentials/"
+
+	regionResource     = "region-id"
+	zoneResource       = "zone-id"
+	instanceIDResource = "instance-id"
+	// aliyunTimeout keeps nodes without the metadata service from blocking
+	aliyunTimeout = 2 * time.Second
+	// unavailableRetryPeriod is how long a resource the metadata service does not have is not asked again,
+	// so nodes without the service do not wait aliyunTimeout on every lookup
+	unavailableRetryPeriod = 5 * time.Minute
+)
+
+// aliyunProvider read the metadata service of ECS instances. Region, zone and instance ID do not
+// change during the life of an instance and are cached, the ram role may be changed at any time.
+type aliyunProvider struct {
+	url    string
+	client *http.Client
+
+	lock  sync.Mutex
+	cache map[string]cachedResource
+	now   func() time.Time
+}
+
+// cachedResource is a resource of the metadata service, or the ErrUnavailable it answered at time
+type cachedResource struct {
+	value string
+	err   error
+	time  time.Time
+}
+
+// NewAliyunProvider create a provider of the metadata service at url
+func NewAliyunProvider(url string) MetadataProvider {
+	return &aliyunProvider{url: url, client: &http.Client{Timeout: aliyunTimeout}, cache: map[string]cachedResource{}, now: time.Now}
+}
+
+func (p *aliyunProvider) Name() string {
+	return ProviderAliyun
+}
+
+func (p *aliyunProvider) cached(resource string) (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if c, ok := p.cache[resource]; ok && (c.err == nil || p.now().Sub(c.time) < unavailableRetryPeriod) {
+		return c.value, c.err
+	}
+	value, err := get(p.client, p.url+resource)
+	if err != nil && !errors.Is(err, ErrUnavailable) {
+		return "", err
+	}
+	p.cache[resource] = cachedResource{value: value, err: err, time: p.now()}
+	return value, err
+}
+
+func (p *aliyunProvider) Region() (string, error) {
+	return p.cached(regionResource)
+}
+
+func (p *aliyunProvider) Zone() (string, error) {
+	return p.cached(zoneResource)
+}
+
+func (p *aliyunProvider) InstanceID() (string, error) {
+	return p.cached(instanceIDResource)
+}
+
+func (p *aliyunProvider) RAMRole() (string, error) {
+	return get(p.client, p.url+RAMRoleResource)
+}
+
+func (p *aliyunProvider) RAMRoleCredentialURL() (string, error) {
+	role, err := p.RAMRole()
+	if err != nil {
+		return "", err
+	}
+	return p.url + RAMRoleResource + role, nil
+}
+
+// get read a resource of the metadata service, missing resources answer 404 with an html page
+func get(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("%s: get %s: %v: %w", ProviderAliyun, url, err, ErrUnavailable)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%s: read %s: %v", ProviderAliyun, url, err)
+	}
+	value := strings.TrimSpace(string(body))
+	if resp.StatusCode != http.StatusOK || value == "" {
+		return "", fmt.Errorf("%s: get %s: status %d: %w", ProviderAliyun, url, resp.StatusCode, ErrUnavailable)
+	}
+	return value, nil
+}
+
+// RoleCredential return the credential of a ram role at url, see MetadataProvider.RAMRoleCredentialURL
+func RoleCredential(url string) ([]byte, error) {
+	value, err := get(&http.Client{Timeout: aliyunTimeout}, url)
+	return []byte(value), err
+}
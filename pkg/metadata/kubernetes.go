@@ -0,0 +1,106 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// RegionLabel is the well-known label of the region of a node
+	RegionLabel = "topology.kubernetes.io/region"
+	// ZoneLabel is the well-known label of the zone of a node
+	ZoneLabel = "topology.kubernetes.io/zone"
+
+	betaRegionLabel = "failure-domain.beta.kubernetes.io/region"
+	betaZoneLabel   = "failure-domain.beta.kubernetes.io/zone"
+	nodeTimeout     = 10 * time.Second
+)
+
+// kubernetesProvider read the topology labels and the provider ID of the node object
+type kubernetesProvider struct {
+	clientSet kubernetes.Interface
+	nodeName  string
+
+	lock sync.Mutex
+	node *v1.Node
+}
+
+// NewKubernetesProvider create a provider of the node nodeName
+func NewKubernetesProvider(clientSet kubernetes.Interface, nodeName string) MetadataProvider {
+	return &kubernetesProvider{clientSet: clientSet, nodeName: nodeName}
+}
+
+func (p *kubernetesProvider) Name() string {
+	return ProviderKubernetes
+}
+
+// getNode return the node object, it is fetched once as its labels are set when the node registers
+func (p *kubernetesProvider) getNode() (*v1.Node, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.node != nil {
+		return p.node, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), nodeTimeout)
+	defer cancel()
+	node, err := p.clientSet.CoreV1().Nodes().Get(ctx, p.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: get node %s: %v", ProviderKubernetes, p.nodeName, err)
+	}
+	p.node = node
+	return node, nil
+}
+
+func (p *kubernetesProvider) label(item string, keys ...string) (string, error) {
+	node, err := p.getNode()
+	if err != nil {
+		return "", err
+	}
+	for _, key := range keys {
+		if value := node.Labels[key]; value != "" {
+			return value, nil
+		}
+	}
+	return "", unavailable(ProviderKubernetes, item)
+}
+
+func (p *kubernetesProvider) Region() (string, error) {
+	return p.label("region", RegionLabel, betaRegionLabel)
+}
+
+func (p *kubernetesProvider) Zone() (string, error) {
+	return p.label("zone", ZoneLabel, betaZoneLabel)
+}
+
+// InstanceID return the instance in the provider ID of the node, such as i-xxx of
+// cn-hangzhou.i-xxx set by the Aliyun cloud controller manager or aws:///us-east-1a/i-xxx
+func (p *kubernetesProvider) InstanceID() (string, error) {
+	node, err := p.getNode()
+	if err != nil {
+		return "", err
+	}
+	id := node.Spec.ProviderID
+	id = id[strings.LastIndex(id, "/")+1:]
+	if region, err := p.Region(); err == nil {
+		id = strings.TrimPrefix(id, region+".")
+	}
+	if id == "" {
+		return "", unavailable(ProviderKubernetes, "instance id")
+	}
+	return id, nil
+}
+
+func (p *kubernetesProvider) RAMRole() (string, error) {
+	return "", unavailable(ProviderKubernetes, "ram role")
+}
+
+func (p *kubernetesProvider) RAMRoleCredentialURL() (string, error) {
+	return "", unavailable(ProviderKubernetes, "ram role")
+}
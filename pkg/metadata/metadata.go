@@ -0,0 +1,139 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ProvidersEnv is the ordered, comma separated list of providers of the metadata of the node
+	ProvidersEnv = "METADATA_PROVIDERS"
+	// DefaultProviders is the Aliyun metadata service, the only provider before they were configurable
+	DefaultProviders = "aliyun"
+
+	// ProviderAliyun reads the metadata service of ECS instances
+	ProviderAliyun = "aliyun"
+	// ProviderStatic reads the metadata configured by environment variables or a file
+	ProviderStatic = "static"
+	// ProviderKubernetes reads the labels and the provider ID of the node object
+	ProviderKubernetes = "kubernetes"
+)
+
+// ErrUnavailable is wrapped by the errors of metadata a provider does not have
+var ErrUnavailable = errors.New("not available")
+
+// MetadataProvider return the metadata of the node, an error wrapping ErrUnavailable
+// when the provider does not have it instead of an empty string
+type MetadataProvider interface {
+	Name() string
+	Region() (string, error)
+	Zone() (string, error)
+	InstanceID() (string, error)
+	// RAMRole return the name of the ram role attached to the node
+	RAMRole() (string, error)
+	// RAMRoleCredentialURL return the url of the credential of the ram role attached to the node,
+	// ossfs reads it by itself
+	RAMRoleCredentialURL() (string, error)
+}
+
+// unavailable return the error of metadata item not available from provider
+func unavailable(provider, item string) error {
+	return fmt.Errorf("%s: %s is %w", provider, item, ErrUnavailable)
+}
+
+// chain look up the providers in order, the first one having the metadata answers
+type chain []MetadataProvider
+
+// NewChain create a provider looking up providers in order
+func NewChain(providers ...MetadataProvider) MetadataProvider {
+	return chain(providers)
+}
+
+func (c chain) Name() string {
+	names := make([]string, 0, len(c))
+	for _, p := range c {
+		names = append(names, p.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c chain) lookup(get func(MetadataProvider) (string, error)) (string, error) {
+	var errs []string
+	missing := true
+	for _, p := range c {
+		value, err := get(p)
+		if err == nil {
+			return value, nil
+		}
+		errs = append(errs, err.Error())
+		missing = missing && errors.Is(err, ErrUnavailable)
+	}
+	if len(errs) == 0 {
+		return "", fmt.Errorf("no metadata provider: %w", ErrUnavailable)
+	}
+	if missing {
+		// no provider has it, rather than a provider failing
+		return "", fmt.Errorf("%s: %w", strings.Join(errs, "; "), ErrUnavailable)
+	}
+	return "", errors.New(strings.Join(errs, "; "))
+}
+
+func (c chain) Region() (string, error) {
+	return c.lookup(MetadataProvider.Region)
+}
+
+func (c chain) Zone() (string, error) {
+	return c.lookup(MetadataProvider.Zone)
+}
+
+func (c chain) InstanceID() (string, error) {
+	return c.lookup(MetadataProvider.InstanceID)
+}
+
+func (c chain) RAMRole() (string, error) {
+	return c.lookup(MetadataProvider.RAMRole)
+}
+
+func (c chain) RAMRoleCredentialURL() (string, error) {
+	return c.lookup(MetadataProvider.RAMRoleCredentialURL)
+}
+
+// ProvidersFromEnv create the providers configured by ProvidersEnv. clientSet and nodeName are
+// used by the kubernetes provider, clientSet is only called when the provider is configured.
+func ProvidersFromEnv(clientSet func() (kubernetes.Interface, error), nodeName string) (MetadataProvider, error) {
+	names := strings.TrimSpace(os.Getenv(ProvidersEnv))
+	if names == "" {
+		names = DefaultProviders
+	}
+	var providers []MetadataProvider
+	for _, name := range strings.Split(names, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+			continue
+		case ProviderAliyun:
+			providers = append(providers, NewAliyunProvider(AliyunURL))
+		case ProviderStatic:
+			p, err := NewStaticProviderFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, p)
+		case ProviderKubernetes:
+			if nodeName == "" {
+				return nil, fmt.Errorf("%s: provider %s requires the node name", ProvidersEnv, name)
+			}
+			client, err := clientSet()
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, NewKubernetesProvider(client, nodeName))
+		default:
+			return nil, fmt.Errorf("%s: unknown provider %s", ProvidersEnv, name)
+		}
+	}
+	return NewChain(providers...), nil
+}
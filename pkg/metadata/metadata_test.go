@@ -0,0 +1,107 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAliyunProvider(t *testing.T) {
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		switch r.URL.Path {
+		case "/region-id":
+			fmt.Fprint(w, "cn-hangzhou")
+		case "/ram/security-credentials/":
+			fmt.Fprint(w, "KubernetesWorkerRole")
+		default:
+			http.Error(w, "<html>404 Not Found</html>", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	p := NewAliyunProvider(server.URL + "/")
+
+	region, err := p.Region()
+	assert.Nil(t, err)
+	assert.Equal(t, "cn-hangzhou", region)
+	role, err := p.RAMRole()
+	assert.Nil(t, err)
+	assert.Equal(t, "KubernetesWorkerRole", role)
+	url, err := p.RAMRoleCredentialURL()
+	assert.Nil(t, err)
+	assert.Equal(t, server.URL+"/ram/security-credentials/KubernetesWorkerRole", url)
+	_, err = p.Zone()
+	assert.True(t, errors.Is(err, ErrUnavailable))
+
+	// the metadata the service does not have is asked again after a while only
+	p.Region()
+	p.Zone()
+	assert.Equal(t, 1, requests["/region-id"])
+	assert.Equal(t, 1, requests["/zone-id"])
+	p.(*aliyunProvider).now = func() time.Time { return time.Now().Add(unavailableRetryPeriod) }
+	_, err = p.Zone()
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.Equal(t, 2, requests["/zone-id"])
+}
+
+func TestStaticRAMRoleCredentialURL(t *testing.T) {
+	url, err := NewStaticProvider(StaticMetadata{RAMRole: "role"}).RAMRoleCredentialURL()
+	assert.Nil(t, err)
+	assert.Equal(t, AliyunURL+RAMRoleResource+"role", url)
+	url, err = NewStaticProvider(StaticMetadata{RAMRole: "role", RAMRoleCredentialURL: "http://127.0.0.1:8181/role"}).RAMRoleCredentialURL()
+	assert.Nil(t, err)
+	assert.Equal(t, "http://127.0.0.1:8181/role", url)
+	_, err = NewStaticProvider(StaticMetadata{}).RAMRoleCredentialURL()
+	assert.True(t, errors.Is(err, ErrUnavailable))
+}
+
+func TestKubernetesProvider(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{RegionLabel: "cn-hangzhou", betaZoneLabel: "cn-hangzhou-b"}},
+		Spec:       v1.NodeSpec{ProviderID: "cn-hangzhou.i-bp1"},
+	})
+	p := NewKubernetesProvider(clientSet, "node")
+
+	tests := []struct {
+		name     string
+		get      func() (string, error)
+		expected string
+	}{
+		{"region", p.Region, "cn-hangzhou"},
+		{"beta zone label", p.Zone, "cn-hangzhou-b"},
+		{"instance id", p.InstanceID, "i-bp1"},
+		{"ram role", p.RAMRole, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := test.get()
+			assert.Equal(t, test.expected, value)
+			assert.Equal(t, test.expected == "", errors.Is(err, ErrUnavailable))
+		})
+	}
+
+	_, err := NewKubernetesProvider(clientSet, "unknown").Region()
+	assert.NotNil(t, err)
+}
+
+func TestChain(t *testing.T) {
+	p := NewChain(NewStaticProvider(StaticMetadata{ZoneID: "zone-a"}), NewStaticProvider(StaticMetadata{RegionID: "region", ZoneID: "zone-b"}))
+
+	zone, err := p.Zone()
+	assert.Nil(t, err)
+	assert.Equal(t, "zone-a", zone)
+	region, err := p.Region()
+	assert.Nil(t, err)
+	assert.Equal(t, "region", region)
+	_, err = p.InstanceID()
+	assert.True(t, errors.Is(err, ErrUnavailable))
+}
@@ -0,0 +1,102 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// StaticFileEnv is the json file of the static provider,
+	// {"regionId": "", "zoneId": "", "instanceId": "", "ramRole": "", "ramRoleCredentialUrl": ""}
+	StaticFileEnv = "METADATA_FILE"
+	// RegionEnv overrides the region of the static provider
+	RegionEnv = "METADATA_REGION_ID"
+	// ZoneEnv overrides the zone of the static provider
+	ZoneEnv = "METADATA_ZONE_ID"
+	// InstanceIDEnv overrides the instance ID of the static provider
+	InstanceIDEnv = "METADATA_INSTANCE_ID"
+	// RAMRoleEnv overrides the ram role of the static provider
+	RAMRoleEnv = "METADATA_RAM_ROLE"
+	// RAMRoleCredentialURLEnv overrides the url of the credential of the ram role of the static provider
+	RAMRoleCredentialURLEnv = "METADATA_RAM_ROLE_CREDENTIAL_URL"
+)
+
+// StaticMetadata is the metadata configured for nodes without a metadata service
+type StaticMetadata struct {
+	RegionID   string `json:"regionId"`
+	ZoneID     string `json:"zoneId"`
+	InstanceID string `json:"instanceId"`
+	RAMRole    string `json:"ramRole"`
+	// RAMRoleCredentialURL is where the credential of the ram role is read, the metadata service by default
+	RAMRoleCredentialURL string `json:"ramRoleCredentialUrl"`
+}
+
+type staticProvider struct {
+	metadata StaticMetadata
+}
+
+// NewStaticProvider create a provider of fixed metadata
+func NewStaticProvider(metadata StaticMetadata) MetadataProvider {
+	return &staticProvider{metadata: metadata}
+}
+
+// NewStaticProviderFromEnv create a provider of the metadata of StaticFileEnv, overridden by the other environment variables
+func NewStaticProviderFromEnv() (MetadataProvider, error) {
+	metadata := StaticMetadata{}
+	if path := strings.TrimSpace(os.Getenv(StaticFileEnv)); path != "" {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", ProviderStatic, err)
+		}
+		if err := json.Unmarshal(raw, &metadata); err != nil {
+			return nil, fmt.Errorf("%s: invalid %s: %v", ProviderStatic, path, err)
+		}
+	}
+	for env, value := range map[string]*string{RegionEnv: &metadata.RegionID, ZoneEnv: &metadata.ZoneID, InstanceIDEnv: &metadata.InstanceID, RAMRoleEnv: &metadata.RAMRole, RAMRoleCredentialURLEnv: &metadata.RAMRoleCredentialURL} {
+		if v := strings.TrimSpace(os.Getenv(env)); v != "" {
+			*value = v
+		}
+	}
+	return NewStaticProvider(metadata), nil
+}
+
+func (p *staticProvider) Name() string {
+	return ProviderStatic
+}
+
+func (p *staticProvider) value(item, value string) (string, error) {
+	if value == "" {
+		return "", unavailable(ProviderStatic, item)
+	}
+	return value, nil
+}
+
+func (p *staticProvider) Region() (string, error) {
+	return p.value("region", p.metadata.RegionID)
+}
+
+func (p *staticProvider) Zone() (string, error) {
+	return p.value("zone", p.metadata.ZoneID)
+}
+
+func (p *staticProvider) InstanceID() (string, error) {
+	return p.value("instance id", p.metadata.InstanceID)
+}
+
+func (p *staticProvider) RAMRole() (string, error) {
+	return p.value("ram role", p.metadata.RAMRole)
+}
+
+func (p *staticProvider) RAMRoleCredentialURL() (string, error) {
+	if p.metadata.RAMRoleCredentialURL != "" {
+		return p.metadata.RAMRoleCredentialURL, nil
+	}
+	role, err := p.RAMRole()
+	if err != nil {
+		return "", err
+	}
+	return AliyunURL + RAMRoleResource + role, nil
+}
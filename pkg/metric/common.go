@@ -49,7 +49,7 @@ const (
 
 var (
 	metricType       string
-	nodeMetricSet    = hashset.New("disk_stat", "pfs_block_stat", "nfs_stat", "fuse_stat")
+	nodeMetricSet    = hashset.New("disk_stat", "pfs_block_stat", "nfs_stat", "fuse_stat", "fuse_client", "fuse_scope", "credential", "connector")
 	clusterMetricSet = hashset.New("")
 )
 
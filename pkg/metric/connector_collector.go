@@ -0,0 +1,109 @@
+package metric
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
+)
+
+var (
+	connectorUpDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "connector", "up"),
+		"Whether the host connector answered the status request.",
+		nil, nil,
+	)
+	connectorInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "connector", "info"),
+		"Version of the host connector.",
+		[]string{"version"}, nil,
+	)
+	connectorUptimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "connector", "uptime_seconds"),
+		"Time since the host connector started.",
+		nil, nil,
+	)
+	connectorRequestsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "connector", "request_duration_seconds"),
+		"Requests served by the host connector and the time spent on them, by type.",
+		[]string{"type"}, nil,
+	)
+	connectorRequestErrorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "connector", "request_errors_total"),
+		"Requests of the host connector which failed, by type.",
+		[]string{"type"}, nil,
+	)
+	connectorRequestMaxDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "connector", "request_max_duration_seconds"),
+		"Longest request served by the host connector, by type.",
+		[]string{"type"}, nil,
+	)
+	connectorFuseProcessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "connector", "fuse_process_alive"),
+		"Whether a fuse process started by the host connector is alive.",
+		[]string{"client", "scope", "target", "mounted"}, nil,
+	)
+	connectorInFlightDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "connector", "in_flight_requests"),
+		"Requests the host connector is serving.",
+		nil, nil,
+	)
+	connectorFuseRestartsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "connector", "fuse_process_restarts_total"),
+		"Restarts of a crashed fuse process by the host connector.",
+		[]string{"client", "scope", "target", "volume"}, nil,
+	)
+)
+
+type connectorCollector struct {
+	descs []typedFactorDesc
+}
+
+func init() {
+	registerCollector("connector", NewConnectorCollector)
+}
+
+// NewConnectorCollector returns a new Collector exposing the status of the host connector.
+func NewConnectorCollector() (Collector, error) {
+	return &connectorCollector{
+		descs: []typedFactorDesc{
+			{desc: connectorUpDesc, valueType: prometheus.GaugeValue},
+			{desc: connectorInfoDesc, valueType: prometheus.GaugeValue},
+			{desc: connectorUptimeDesc, valueType: prometheus.GaugeValue},
+			{desc: connectorRequestErrorsDesc, valueType: prometheus.CounterValue},
+			{desc: connectorRequestMaxDesc, valueType: prometheus.GaugeValue},
+			{desc: connectorFuseProcessDesc, valueType: prometheus.GaugeValue},
+			{desc: connectorFuseRestartsDesc, valueType: prometheus.CounterValue},
+			{desc: connectorInFlightDesc, valueType: prometheus.GaugeValue},
+		},
+	}, nil
+}
+
+func (p *connectorCollector) Update(ch chan<- prometheus.Metric) error {
+	status, err := utils.ConnectorStatus()
+	if err != nil {
+		// a connector which is down or of the legacy protocol is reported, not a failed scrape
+		ch <- p.descs[0].mustNewConstMetric(0)
+		return nil
+	}
+	ch <- p.descs[0].mustNewConstMetric(1)
+	ch <- p.descs[1].mustNewConstMetric(1, status.Version)
+	ch <- p.descs[2].mustNewConstMetric(status.UptimeSeconds)
+	if status.Lifecycle != nil {
+		ch <- p.descs[7].mustNewConstMetric(float64(status.Lifecycle.InFlight))
+	}
+	for kind, r := range status.Requests {
+		ch <- prometheus.MustNewConstSummary(connectorRequestsDesc, r.Count, r.DurationSeconds, nil, kind)
+		ch <- p.descs[3].mustNewConstMetric(float64(r.Errors), kind)
+		ch <- p.descs[4].mustNewConstMetric(r.MaxDurationSeconds, kind)
+	}
+	for _, process := range status.Processes {
+		alive := 0.0
+		if process.Alive {
+			alive = 1
+		}
+		ch <- p.descs[5].mustNewConstMetric(alive, process.Client, process.Unit, process.Target, strconv.FormatBool(process.Mounted))
+		ch <- p.descs[6].mustNewConstMetric(float64(process.Restarts), process.Client, process.Unit, process.Target, process.VolumeID)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package metric
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"wujunyi792/oss-csi-lite-plugin/pkg/auth"
+)
+
+var credentialRemainingDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(nodeNamespace, "credential", "remaining_seconds"),
+	"Remaining lifetime of a temporary credential cached by the plugin.",
+	[]string{"credential", "mode"}, nil,
+)
+
+type credentialCollector struct {
+	descs []typedFactorDesc
+}
+
+func init() {
+	registerCollector("credential", NewCredentialCollector)
+}
+
+// NewCredentialCollector returns a new Collector exposing the lifetime of cached credentials.
+func NewCredentialCollector() (Collector, error) {
+	return &credentialCollector{
+		descs: []typedFactorDesc{
+			{desc: credentialRemainingDesc, valueType: prometheus.GaugeValue},
+		},
+	}, nil
+}
+
+func (p *credentialCollector) Update(ch chan<- prometheus.Metric) error {
+	lifetimes := auth.DefaultCache().Lifetimes()
+	if len(lifetimes) == 0 {
+		return ErrNoData
+	}
+	for _, l := range lifetimes {
+		ch <- p.descs[0].mustNewConstMetric(l.Remaining.Seconds(), credentialLabel(l.Key), l.Mode)
+	}
+	return nil
+}
+
+// credentialLabel return the label of a cache key, the key holds the AccessKey ID of static credentials
+func credentialLabel(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%x", sum[:8])
+}
@@ -0,0 +1,53 @@
+package metric
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	fuseClientInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "fuse", "client_info"),
+		"Version of the fuse client installed on the node.",
+		[]string{"client_name", "version"}, nil,
+	)
+)
+
+// fuseClientVersions maps client name to the version probed by the node server
+var fuseClientVersions sync.Map
+
+// SetFuseClientVersion record the version of an installed fuse client
+func SetFuseClientVersion(clientName, version string) {
+	fuseClientVersions.Store(clientName, version)
+}
+
+type fuseClientCollector struct {
+	descs []typedFactorDesc
+}
+
+func init() {
+	registerCollector("fuse_client", NewFuseClientCollector)
+}
+
+// NewFuseClientCollector returns a new Collector exposing installed fuse client versions.
+func NewFuseClientCollector() (Collector, error) {
+	return &fuseClientCollector{
+		descs: []typedFactorDesc{
+			{desc: fuseClientInfoDesc, valueType: prometheus.GaugeValue},
+		},
+	}, nil
+}
+
+func (p *fuseClientCollector) Update(ch chan<- prometheus.Metric) error {
+	found := false
+	fuseClientVersions.Range(func(key, value interface{}) bool {
+		found = true
+		ch <- p.descs[0].mustNewConstMetric(1, key.(string), value.(string))
+		return true
+	})
+	if !found {
+		return ErrNoData
+	}
+	return nil
+}
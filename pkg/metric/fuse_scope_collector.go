@@ -0,0 +1,66 @@
+package metric
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+)
+
+var (
+	fuseScopeMemoryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "fuse_scope", "memory_bytes"),
+		"Memory used by the scope of a fuse process.",
+		[]string{"volume", "scope"}, nil,
+	)
+	fuseScopeCPUDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "fuse_scope", "cpu_seconds_total"),
+		"CPU time consumed by the scope of a fuse process.",
+		[]string{"volume", "scope"}, nil,
+	)
+	fuseScopeTasksDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "fuse_scope", "tasks"),
+		"Number of tasks in the scope of a fuse process.",
+		[]string{"volume", "scope"}, nil,
+	)
+	fuseScopeOOMDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(nodeNamespace, "fuse_scope", "oom_kills_total"),
+		"Number of processes killed by the oom killer in the scope of a fuse process.",
+		[]string{"volume", "scope"}, nil,
+	)
+)
+
+type fuseScopeCollector struct {
+	descs []typedFactorDesc
+}
+
+func init() {
+	registerCollector("fuse_scope", NewFuseScopeCollector)
+}
+
+// NewFuseScopeCollector returns a new Collector exposing the resource usage of fuse scopes.
+func NewFuseScopeCollector() (Collector, error) {
+	return &fuseScopeCollector{
+		descs: []typedFactorDesc{
+			{desc: fuseScopeMemoryDesc, valueType: prometheus.GaugeValue},
+			{desc: fuseScopeCPUDesc, valueType: prometheus.CounterValue},
+			{desc: fuseScopeTasksDesc, valueType: prometheus.GaugeValue},
+			{desc: fuseScopeOOMDesc, valueType: prometheus.CounterValue},
+		},
+	}, nil
+}
+
+func (p *fuseScopeCollector) Update(ch chan<- prometheus.Metric) error {
+	stats, err := fuse.ListScopeStats(fuse.HostCgroupRoot)
+	if err != nil {
+		return err
+	}
+	if len(stats) == 0 {
+		return ErrNoData
+	}
+	for _, s := range stats {
+		ch <- p.descs[0].mustNewConstMetric(float64(s.MemoryBytes), s.VolumeID, s.Unit)
+		ch <- p.descs[1].mustNewConstMetric(s.CPUSeconds, s.VolumeID, s.Unit)
+		ch <- p.descs[2].mustNewConstMetric(float64(s.Tasks), s.VolumeID, s.Unit)
+		ch <- p.descs[3].mustNewConstMetric(float64(s.OOMKills), s.VolumeID, s.Unit)
+	}
+	return nil
+}
@@ -2,7 +2,6 @@ package metric
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"errors"
@@ -16,11 +15,11 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
 	"wujunyi792/oss-csi-lite-plugin/pkg/cnfs/v1beta1"
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
 	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
 )
 
@@ -119,18 +118,17 @@ func findVolJSON(rootDir string) ([]string, error) {
 	return resDir, err
 }
 
+// commandExecutor run the commands of the collectors, a fake replaces it in tests
+var commandExecutor executor.Executor = executor.New()
+
 // ExecCheckOutput check output
 func execCheckOutput(cmd string, args ...string) (io.Reader, error) {
-	c := exec.Command(cmd, args...)
-	stdout := bytes.NewBuffer(nil)
-	stderr := bytes.NewBuffer(nil)
-	c.Stdout = stdout
-	c.Stderr = stderr
-	if err := c.Run(); err != nil {
-		return nil, errors.New("cmd:" + cmd + ", stdout: " + stdout.String() + ", stderr: " + stderr.String() + ", err: " + err.Error())
+	result, err := commandExecutor.Run(context.Background(), executor.NewCommand(append([]string{cmd}, args...)...))
+	if err != nil {
+		return nil, errors.New("cmd:" + cmd + ", stdout: " + result.Stdout + ", stderr: " + result.Stderr + ", err: " + err.Error())
 	}
 
-	return stdout, nil
+	return strings.NewReader(result.Stdout), nil
 }
 
 // FindLines parse lines
@@ -0,0 +1,44 @@
+package metric
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
+)
+
+func TestIsVFNode(t *testing.T) {
+	previous := commandExecutor
+	defer func() { commandExecutor, vfOnce, isVF = previous, new(sync.Once), false }()
+
+	fake := executor.NewFake().
+		On("lspci -D", "0000:00:01.0 Ethernet controller: Device 1af4:1000\n0000:4b:00.0 SCSI storage controller: Device 1ded:1001\n", 0).
+		On("lspci -s 0000:4b:00.0 -v", "\tCapabilities: [110] Single Root I/O Virtualization (SR-IOV)\n", 0)
+	commandExecutor, vfOnce, isVF = fake, new(sync.Once), false
+	assert.True(t, isVFNode())
+	assert.Equal(t, []string{"lspci -D", "lspci -s 0000:4b:00.0 -v"}, fake.Commands())
+
+	fake = executor.NewFake().On("lspci -D", "0000:00:05.0 SCSI storage controller: Device 1af4:1001\n", 0)
+	commandExecutor, vfOnce, isVF = fake, new(sync.Once), false
+	assert.False(t, isVFNode())
+	assert.Equal(t, []string{"lspci -D"}, fake.Commands())
+}
+
+func TestExecCheckOutput(t *testing.T) {
+	previous := commandExecutor
+	defer func() { commandExecutor = previous }()
+	commandExecutor = executor.NewFake().On("lspci", "", 1)
+
+	_, err := execCheckOutput("lspci", "-D")
+	assert.NotNil(t, err)
+}
+
+func TestCredentialLabel(t *testing.T) {
+	label := credentialLabel("ak/LTAI5tExampleKeyID")
+	assert.Equal(t, 16, len(label))
+	assert.NotContains(t, label, "LTAI")
+	assert.Equal(t, label, credentialLabel("ak/LTAI5tExampleKeyID"))
+	assert.NotEqual(t, label, credentialLabel("oidc/acs:ram::123:role/oss/default/app"))
+}
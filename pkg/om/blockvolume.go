@@ -3,9 +3,9 @@ package om
 import (
 	log "github.com/sirupsen/logrus"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
 	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
 )
 
@@ -52,11 +52,9 @@ func FixReferenceMountIssue(line string) bool {
 // If podUid is in mount list, it means the pod related pod is running, not remove it.
 func isFileRemovable(file string) (bool, string) {
 	fileName := filepath.Base(file)
-	findmntCmd := "grep"
-	findmntArgs := []string{fileName, "/proc/mounts"}
-	out, err := exec.Command(findmntCmd, findmntArgs...).CombinedOutput()
+	out, err := runCommand(executor.NewCommand("grep", fileName, "/proc/mounts"))
 	if err == nil {
-		outStr := strings.TrimSpace(string(out))
+		outStr := strings.TrimSpace(out)
 		if outStr != "" {
 			return false, outStr
 		}
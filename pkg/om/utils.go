@@ -1,19 +1,24 @@
 package om
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
+	"strconv"
 	"strings"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
 )
 
+// commandExecutor run the commands of om, a fake replaces it in tests
+var commandExecutor executor.Executor = executor.New()
+
 // ReadFileLinesFromHost read file from /var/log/messages
 func ReadFileLinesFromHost(fname string) []string {
 	lineList := []string{}
 	out := ""
 	var err error
-	cmd := fmt.Sprintf("tail -n %d %s", GlobalConfigVar.MessageFileTailLines, fname)
-	if out, err = Run(cmd); err != nil {
+	if out, err = runCommand(executor.NewCommand("tail", "-n", strconv.Itoa(GlobalConfigVar.MessageFileTailLines), fname)); err != nil {
 		return lineList
 	}
 	return strings.Split(out, "\n")
@@ -21,11 +26,17 @@ func ReadFileLinesFromHost(fname string) []string {
 
 // Run run shell command
 func Run(cmd string) (string, error) {
-	out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+	return runCommand(executor.Shell(cmd))
+}
+
+// runCommand run command with stdout and stderr combined
+func runCommand(command *executor.Command) (string, error) {
+	command.Combined = true
+	result, err := commandExecutor.Run(context.Background(), command)
 	if err != nil {
-		return "", fmt.Errorf("Failed to run cmd: " + cmd + ", with out: " + string(out) + ", with error: " + err.Error())
+		return "", fmt.Errorf("Failed to run cmd: " + command.String() + ", with out: " + result.Stdout + ", with error: " + err.Error())
 	}
-	return string(out), nil
+	return result.Stdout, nil
 }
 
 // IsFileExisting check file exist in volume driver;
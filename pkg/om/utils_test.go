@@ -0,0 +1,34 @@
+package om
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
+)
+
+func TestReadFileLinesFromHost(t *testing.T) {
+	fake := executor.NewFake().On("tail -n 5 /var/log/messages", "line1\nline2", 0).On("tail -n 5 /missing", "", 1)
+	previous := commandExecutor
+	commandExecutor = fake
+	defer func() { commandExecutor = previous }()
+	GlobalConfigVar.MessageFileTailLines = 5
+
+	assert.Equal(t, []string{"line1", "line2"}, ReadFileLinesFromHost(SysLog))
+	assert.Equal(t, []string{}, ReadFileLinesFromHost("/missing"))
+	assert.Equal(t, []string{"tail -n 5 /var/log/messages", "tail -n 5 /missing"}, fake.Commands())
+}
+
+func TestIsFileRemovable(t *testing.T) {
+	fake := executor.NewFake().On("grep abc", "/dev/vdb /var/lib/kubelet/abc ext4 rw 0 0\n", 0).On("grep def", "", 1)
+	previous := commandExecutor
+	commandExecutor = fake
+	defer func() { commandExecutor = previous }()
+
+	removable, mounted := isFileRemovable("/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/pvc/dev/abc")
+	assert.False(t, removable)
+	assert.Equal(t, "/dev/vdb /var/lib/kubelet/abc ext4 rw 0 0", mounted)
+	removable, _ = isFileRemovable("/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/pvc/dev/def")
+	assert.True(t, removable)
+}
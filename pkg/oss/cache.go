@@ -0,0 +1,273 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oss
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
+	k8smount "k8s.io/utils/mount"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
+)
+
+const (
+	// CacheRootEnv is the node directory holding the cache of every volume,
+	// it must be mounted at the same path in the plugin container and on the host
+	CacheRootEnv = "OSS_CACHE_ROOT"
+	// CacheMediumDisk cache on the disk of the cache root
+	CacheMediumDisk = "disk"
+	// CacheMediumMemory cache on a tmpfs sized to cacheSizeLimit
+	CacheMediumMemory = "memory"
+	// cacheMetaSuffix is the suffix of the file recording the limits of a cache directory
+	cacheMetaSuffix = ".json"
+	// cacheJanitorPeriod is the interval to enforce cache size limits
+	cacheJanitorPeriod = time.Minute
+	// cacheLowWatermark is the ratio of the limit the janitor shrinks an oversized cache to
+	cacheLowWatermark = 0.9
+)
+
+// cacheMeta records the settings of a per-mount cache directory for the janitor
+type cacheMeta struct {
+	VolumeID   string `json:"volumeId"`
+	MountPoint string `json:"mountPoint"`
+	Medium     string `json:"medium"`
+	LimitBytes int64  `json:"limitBytes"`
+}
+
+// cacheRoot return the node directory holding volume caches
+func cacheRoot() string {
+	if root := strings.TrimSpace(os.Getenv(CacheRootEnv)); root != "" {
+		return root
+	}
+	return filepath.Join(utils.KubeletRootDir, "csi-plugins", driverName, "cache")
+}
+
+// checkCacheOptions validate the cache volume attributes
+func checkCacheOptions(opt *Options) error {
+	if opt.CacheDir == "" {
+		if opt.CacheSizeLimit != "" || opt.CacheMedium != "" {
+			return errors.New("cacheSizeLimit and cacheMedium require cacheDir")
+		}
+		return nil
+	}
+	if opt.FuseType != OssFsType {
+		return errors.New("cacheDir is only supported by ossfs")
+	}
+	if strings.Contains(opt.OtherOpts, "use_cache") {
+		return errors.New("use_cache in otherOpts conflicts with cacheDir")
+	}
+	if opt.CacheDir != "true" && (filepath.IsAbs(opt.CacheDir) || strings.Contains(opt.CacheDir, "/") || strings.Contains(opt.CacheDir, "..")) {
+		return fmt.Errorf("cacheDir should be true or the name of a directory under the node cache root, got %s", opt.CacheDir)
+	}
+	if opt.CacheSizeLimit != "" {
+		q, err := resource.ParseQuantity(opt.CacheSizeLimit)
+		if err != nil || q.Value() <= 0 {
+			return fmt.Errorf("invalid cacheSizeLimit %s", opt.CacheSizeLimit)
+		}
+	}
+	switch opt.CacheMedium {
+	case "", CacheMediumDisk:
+	case CacheMediumMemory:
+		if opt.CacheSizeLimit == "" {
+			return errors.New("cacheMedium memory requires cacheSizeLimit")
+		}
+	default:
+		return fmt.Errorf("invalid cacheMedium %s, should be disk or memory", opt.CacheMedium)
+	}
+	return nil
+}
+
+// cachePath return the cache directory of one mount of a volume
+func cachePath(opt *Options, volumeID, mountPoint string) string {
+	base := cacheRoot()
+	if opt.CacheDir != "true" {
+		base = filepath.Join(base, opt.CacheDir)
+	}
+	return filepath.Join(base, volumeID, mountPointKey(mountPoint))
+}
+
+// prepareCache create the cache directory of a mount and return it with the other ossfs options of the cache
+func (ns *nodeServer) prepareCache(opt *Options, volumeID, mountPoint string) (string, []string, error) {
+	if opt.CacheDir == "" {
+		return "", nil, nil
+	}
+	dir := cachePath(opt, volumeID, mountPoint)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", nil, err
+	}
+	meta := cacheMeta{VolumeID: volumeID, MountPoint: mountPoint, Medium: CacheMediumDisk}
+	if opt.CacheMedium != "" {
+		meta.Medium = opt.CacheMedium
+	}
+	if opt.CacheSizeLimit != "" {
+		q := resource.MustParse(opt.CacheSizeLimit)
+		meta.LimitBytes = q.Value()
+	}
+	if meta.Medium == CacheMediumMemory {
+		notMnt, err := ns.k8smounter.IsLikelyNotMountPoint(dir)
+		if err != nil {
+			return "", nil, err
+		}
+		if notMnt {
+			options := []string{"size=" + strconv.FormatInt(meta.LimitBytes, 10), "mode=0700"}
+			if err := ns.k8smounter.Mount("tmpfs", dir, "tmpfs", options); err != nil {
+				return "", nil, fmt.Errorf("mount tmpfs cache %s: %v", dir, err)
+			}
+		}
+	}
+	raw, _ := json.Marshal(meta)
+	if err := utils.WriteAndSyncFile(dir+cacheMetaSuffix, raw, 0600); err != nil {
+		return "", nil, err
+	}
+	log.Infof("PrepareCache: volume %s use cache %s, medium: %s, limit: %d", volumeID, dir, meta.Medium, meta.LimitBytes)
+	cacheOpts := []string{"del_cache"}
+	if meta.Medium == CacheMediumMemory {
+		// the tmpfs is dedicated to the mount, ossfs stops caching before it is full
+		cacheOpts = append(cacheOpts, fmt.Sprintf("ensure_diskfree=%d", ensureDiskFreeMB(meta.LimitBytes)))
+	}
+	return dir, cacheOpts, nil
+}
+
+// ensureDiskFreeMB return the free space ossfs keeps on a tmpfs cache of limit bytes
+func ensureDiskFreeMB(limit int64) int64 {
+	free := limit / 20 / (1 << 20)
+	if free < 1 {
+		free = 1
+	}
+	return free
+}
+
+// cleanupCache remove the cache directory of a mount after it is unmounted
+func (ns *nodeServer) cleanupCache(volumeID, mountPoint string) {
+	metaFiles, _ := filepath.Glob(filepath.Join(cacheRoot(), "*", mountPointKey(mountPoint)+cacheMetaSuffix))
+	more, _ := filepath.Glob(filepath.Join(cacheRoot(), "*", "*", mountPointKey(mountPoint)+cacheMetaSuffix))
+	for _, metaFile := range append(metaFiles, more...) {
+		if filepath.Base(filepath.Dir(metaFile)) != volumeID {
+			continue
+		}
+		dir := strings.TrimSuffix(metaFile, cacheMetaSuffix)
+		if err := k8smount.CleanupMountPoint(dir, ns.k8smounter, false); err != nil {
+			log.Errorf("CleanupCache: unmount cache %s is failed, err: %v", dir, err)
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Errorf("CleanupCache: remove cache %s is failed, err: %v", dir, err)
+			continue
+		}
+		os.Remove(metaFile)
+		// remove the volume directory once the last cache of the volume is gone
+		os.Remove(filepath.Dir(metaFile))
+		log.Infof("CleanupCache: remove cache %s of volume %s", dir, volumeID)
+	}
+}
+
+// cacheJanitor shrink every disk cache exceeding its size limit
+func cacheJanitor() {
+	metaFiles, _ := filepath.Glob(filepath.Join(cacheRoot(), "*", "*"+cacheMetaSuffix))
+	more, _ := filepath.Glob(filepath.Join(cacheRoot(), "*", "*", "*"+cacheMetaSuffix))
+	for _, metaFile := range append(metaFiles, more...) {
+		raw, err := ioutil.ReadFile(metaFile)
+		if err != nil {
+			continue
+		}
+		meta := cacheMeta{}
+		if err := json.Unmarshal(raw, &meta); err != nil || meta.LimitBytes <= 0 || meta.Medium != CacheMediumDisk {
+			continue
+		}
+		dir := strings.TrimSuffix(metaFile, cacheMetaSuffix)
+		freed, err := shrinkCache(dir, meta.LimitBytes)
+		if err != nil {
+			log.Errorf("CacheJanitor: shrink cache %s is failed, err: %v", dir, err)
+		} else if freed > 0 {
+			log.Infof("CacheJanitor: free %d bytes of cache %s of volume %s", freed, dir, meta.VolumeID)
+		}
+	}
+}
+
+type cacheFile struct {
+	path  string
+	size  int64
+	atime time.Time
+}
+
+// shrinkCache remove the least recently accessed files of dir until it uses less than
+// the low watermark of limit, it returns the number of bytes freed
+func shrinkCache(dir string, limit int64) (int64, error) {
+	var files []cacheFile
+	var total int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		// stat cache entries of ossfs are small and kept with their data file
+		if strings.Contains(path, ".stat/") {
+			return nil
+		}
+		f := cacheFile{path: path, size: info.Size(), atime: info.ModTime()}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			f.atime = time.Unix(st.Atim.Sec, st.Atim.Nsec)
+		}
+		files = append(files, f)
+		total += f.size
+		return nil
+	})
+	if err != nil || total <= limit {
+		return 0, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].atime.Before(files[j].atime) })
+	target := int64(float64(limit) * cacheLowWatermark)
+	var freed int64
+	for _, f := range files {
+		if total-freed <= target {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			continue
+		}
+		os.Remove(statCachePath(dir, f.path))
+		freed += f.size
+	}
+	return freed, nil
+}
+
+// statCachePath return the ossfs stat cache entry of a cached file,
+// ossfs keeps <cache>/<bucket>/<object> and <cache>/.<bucket>.stat/<object>
+func statCachePath(dir, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return ""
+	}
+	parts := strings.SplitN(rel, string(filepath.Separator), 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	return filepath.Join(dir, "."+parts[0]+".stat", parts[1])
+}
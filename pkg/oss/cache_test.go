@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package oss
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCacheOptions(t *testing.T) {
+	cases := []struct {
+		name    string
+		opt     Options
+		wantErr bool
+	}{
+		{"no cache", Options{FuseType: OssFsType}, false},
+		{"default root", Options{FuseType: OssFsType, CacheDir: "true"}, false},
+		{"sub root", Options{FuseType: OssFsType, CacheDir: "ssd", CacheSizeLimit: "10Gi"}, false},
+		{"memory", Options{FuseType: OssFsType, CacheDir: "true", CacheSizeLimit: "512Mi", CacheMedium: CacheMediumMemory}, false},
+		{"absolute dir", Options{FuseType: OssFsType, CacheDir: "/tmp"}, true},
+		{"escape root", Options{FuseType: OssFsType, CacheDir: ".."}, true},
+		{"limit without dir", Options{FuseType: OssFsType, CacheSizeLimit: "1Gi"}, true},
+		{"memory without limit", Options{FuseType: OssFsType, CacheDir: "true", CacheMedium: CacheMediumMemory}, true},
+		{"invalid medium", Options{FuseType: OssFsType, CacheDir: "true", CacheMedium: "ssd"}, true},
+		{"invalid limit", Options{FuseType: OssFsType, CacheDir: "true", CacheSizeLimit: "lots"}, true},
+		{"conflict with otherOpts", Options{FuseType: OssFsType, CacheDir: "true", OtherOpts: "-o use_cache=/tmp"}, true},
+		{"jindo", Options{FuseType: JindoFsType, CacheDir: "true"}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkCacheOptions(&c.opt)
+			assert.Equal(t, c.wantErr, err != nil, "%v", err)
+		})
+	}
+}
+
+func TestShrinkCache(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	files := []string{"bucket/old", "bucket/mid", "bucket/new"}
+	for i, f := range files {
+		path := filepath.Join(dir, f)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0700))
+		assert.Nil(t, ioutil.WriteFile(path, make([]byte, 100), 0600))
+		accessed := now.Add(time.Duration(i-len(files)) * time.Hour)
+		assert.Nil(t, os.Chtimes(path, accessed, accessed))
+	}
+	stat := filepath.Join(dir, ".bucket.stat", "old")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(stat), 0700))
+	assert.Nil(t, ioutil.WriteFile(stat, []byte("stat"), 0600))
+
+	freed, err := shrinkCache(dir, 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), freed)
+
+	freed, err = shrinkCache(dir, 250)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), freed)
+	_, err = os.Stat(filepath.Join(dir, "bucket/old"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(stat)
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, filepath.Join(dir, "bucket/new"))
+}
@@ -19,6 +19,7 @@ package oss
 import (
 	"fmt"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	csicommon "github.com/kubernetes-csi/drivers/pkg/csi-common"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
@@ -68,8 +69,6 @@ func getOssVolumeOptions(req *csi.CreateVolumeRequest) *Options {
 	volOptions := req.GetParameters()
 	secret := req.GetSecrets()
 	ossVolArgs.Path = "/"
-	fmt.Println("getOssVolumeOptions:")
-	fmt.Println(volOptions)
 	for k, v := range volOptions {
 		key := strings.TrimSpace(strings.ToLower(k))
 		value := strings.TrimSpace(v)
@@ -104,7 +103,7 @@ func validateCreateVolumeRequest(req *csi.CreateVolumeRequest) error {
 		return status.Error(codes.InvalidArgument, "Volume name not provided")
 	}
 
-	log.Infof("Starting oss validate create volume request: %s, %v", req.Name, req)
+	log.Infof("Starting oss validate create volume request: %s, %s", req.Name, protosanitizer.StripSecrets(req))
 	valid, err := utils.CheckRequestArgs(req.GetParameters())
 	if !valid {
 		return status.Errorf(codes.InvalidArgument, err.Error())
@@ -129,7 +128,7 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		VolumeContext: volumeContext,
 	}
 
-	log.Infof("Provision oss volume is successfully: %s,pvName: %v", req.Name, csiTargetVolume)
+	log.Infof("Provision oss volume is successfully: %s,pvName: %s", req.Name, protosanitizer.StripSecrets(csiTargetVolume))
 	return &csi.CreateVolumeResponse{Volume: csiTargetVolume}, nil
 
 }
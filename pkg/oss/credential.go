@@ -0,0 +1,274 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oss
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"wujunyi792/oss-csi-lite-plugin/pkg/auth"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+	"wujunyi792/oss-csi-lite-plugin/pkg/metadata"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
+)
+
+const (
+	// CredentialDir is the host directory holding the credential file of every mount point
+	CredentialDir = fuse.CredentialDir
+	// jsonCredentialSuffix is the suffix of JSON credential files, read by jindo-fuse and the credential server
+	jsonCredentialSuffix = ".json"
+	// ossfsCredentialSuffix is the suffix of ossfs passwd files
+	ossfsCredentialSuffix = ".passwd"
+	// credentialTokenSuffix is the suffix of the files of the secrets ossfs presents to the credential server
+	credentialTokenSuffix = ".token"
+	// SharedCredentialFileEnv keeps writing the host-wide passwd-ossfs, for tools still reading it
+	SharedCredentialFileEnv = "OSSFS_SHARED_PASSWD_FILE"
+	// credentialRefreshPeriod is the interval to renew temporary credentials of mounted volumes
+	credentialRefreshPeriod = 5 * time.Minute
+)
+
+// jsonCredential is read by the LOCAL credential provider of jindo-fuse and served to ossfs by the credential server
+type jsonCredential struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	SecurityToken   string `json:"SecurityToken,omitempty"`
+	Expiration      string `json:"Expiration,omitempty"`
+}
+
+// stsCredential is the ram role credential returned by the metadata server
+type stsCredential struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	SecurityToken   string `json:"SecurityToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+// credentialPath return the host path of the credential file of a mount point
+func credentialPath(mountPoint, suffix string) string {
+	return filepath.Join(CredentialDir, mountPointKey(mountPoint)+suffix)
+}
+
+// writeCredentialFile atomically replace the credential file at the host path,
+// readers never see a partial file and the file is only readable by root
+func writeCredentialFile(path string, content []byte) error {
+	dir := filepath.Join(fuse.HostPrefix, filepath.Dir(path))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(fuse.HostPrefix, path))
+}
+
+// removeCredentialFiles delete the credential files of a mount point after it is unmounted
+func removeCredentialFiles(mountPoint string) {
+	files, _ := filepath.Glob(filepath.Join(fuse.HostPrefix, CredentialDir, mountPointKey(mountPoint)+".*"))
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Errorf("RemoveCredentialFiles: remove %s is failed, err: %v", file, err)
+		}
+	}
+	credentialPids.Delete(mountPointKey(mountPoint))
+}
+
+// managedTokenTTL is how long the managed token of the addon secret is cached, the secret is rotated in place
+const managedTokenTTL = 10 * time.Minute
+
+// nodeSTSCredential return the temporary credential used by authType sts, from the managed
+// token of the addon secret if it is deployed, and from the ram role of the node otherwise
+func nodeSTSCredential() (*auth.Credential, error) {
+	if tokens := utils.GetManagedToken(); tokens.AccessKeyID != "" {
+		return &auth.Credential{AccessKeyID: tokens.AccessKeyID, AccessKeySecret: tokens.AccessKeySecret, SecurityToken: tokens.SecurityToken, Expiration: time.Now().Add(managedTokenTTL)}, nil
+	}
+	url, err := nodeMetadata.RAMRoleCredentialURL()
+	if err != nil {
+		return nil, fmt.Errorf("no sts credential: the addon token is not deployed and the ram role of the node is unknown: %v", err)
+	}
+	raw, err := metadata.RoleCredential(url)
+	if err != nil {
+		return nil, fmt.Errorf("no sts credential: get credential of ram role at %s: %v", url, err)
+	}
+	cred := &stsCredential{}
+	if err := json.Unmarshal(raw, cred); err != nil || cred.AccessKeyID == "" {
+		return nil, fmt.Errorf("no sts credential: invalid credential of ram role at %s", url)
+	}
+	expiration, err := time.Parse(time.RFC3339, cred.Expiration)
+	if err != nil {
+		return nil, fmt.Errorf("no sts credential: invalid expiration of ram role at %s", url)
+	}
+	return &auth.Credential{AccessKeyID: cred.AccessKeyID, AccessKeySecret: cred.AccessKeySecret, SecurityToken: cred.SecurityToken, Expiration: expiration}, nil
+}
+
+// credentialSource return the source of the credential of a volume, every auth type is behind the credential cache
+func credentialSource(opt *Options) auth.CredentialSource {
+	switch opt.AuthType {
+	case "sts":
+		return auth.NewSourceFunc(auth.ModeSTS, auth.ModeSTS, nodeSTSCredential)
+	case RAMRoleArnAuthType:
+		return auth.NewAssumeRoleSource(opt.AkID, opt.AkSecret, opt.RoleArn)
+	case RRSAAuthType:
+		token := opt.serviceAccountToken
+		region, _ := nodeMetadata.Region()
+		return auth.NewOIDCProviderWithToken(region, auth.STSEndpoint(), opt.RoleArn, opt.OIDCProviderArn, auth.RoleSessionName, opt.serviceAccount, auth.DefaultRoleDuration,
+			func() (string, error) { return token, nil })
+	}
+	if opt.envAK {
+		return auth.NewEnvAccessKeySource()
+	}
+	return auth.NewAccessKeySource(opt.AkID, opt.AkSecret)
+}
+
+// getCredential return the credential of a volume from the credential cache
+func getCredential(opt *Options) (*auth.Credential, error) {
+	return auth.DefaultCache().Get(credentialSource(opt))
+}
+
+// ossfsCredentialFile write the passwd file of an ossfs mount point and return its path,
+// so that volumes of one bucket with different AccessKeys do not overwrite each other
+func ossfsCredentialFile(opt *Options, mountPoint string) (string, error) {
+	path := credentialPath(mountPoint, ossfsCredentialSuffix)
+	content, err := ossfsCredential(opt)
+	if err != nil {
+		return "", err
+	}
+	if err := writeCredentialFile(path, content); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// ossfsCredential return the passwd file content of the AccessKey of opt
+func ossfsCredential(opt *Options) ([]byte, error) {
+	cred, err := getCredential(opt)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%s:%s:%s\n", opt.Bucket, cred.AccessKeyID, cred.AccessKeySecret)), nil
+}
+
+// IsSharedCredentialFile return if AccessKeys are also saved to the legacy host-wide passwd-ossfs
+func IsSharedCredentialFile() bool {
+	return strings.TrimSpace(os.Getenv(SharedCredentialFileEnv)) == "true"
+}
+
+// jindoCredentialFile write the credential file of a jindo-fuse mount point and return its path
+func jindoCredentialFile(opt *Options, mountPoint string) (string, error) {
+	raw, err := jsonCredentialContent(opt)
+	if err != nil {
+		return "", err
+	}
+	path := credentialPath(mountPoint, jsonCredentialSuffix)
+	if err := writeCredentialFile(path, raw); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// jsonCredentialContent return the content of the JSON credential file of a mount point
+func jsonCredentialContent(opt *Options) ([]byte, error) {
+	cred, err := getCredential(opt)
+	if err != nil {
+		return nil, err
+	}
+	content := jsonCredential{AccessKeyID: cred.AccessKeyID, AccessKeySecret: cred.AccessKeySecret, SecurityToken: cred.SecurityToken}
+	if cred.Temporary() {
+		content.Expiration = cred.Expiration.UTC().Format(time.RFC3339)
+	}
+	return json.Marshal(content)
+}
+
+// parseServiceAccountToken return the token of audience sts.aliyuncs.com
+// in the service account tokens kubelet passes to NodePublishVolume
+func parseServiceAccountToken(tokens string) string {
+	parsed := map[string]struct {
+		Token string `json:"token"`
+	}{}
+	if err := json.Unmarshal([]byte(tokens), &parsed); err != nil {
+		return ""
+	}
+	return parsed[auth.OIDCTokenAudience].Token
+}
+
+// refreshesCredentialFile return if the plugin renews the credential file of a mount of opt before it expires,
+// ossfs of authType sts reads the ram role of the node from the metadata server by itself
+func refreshesCredentialFile(opt *Options) bool {
+	return opt.AuthType == RAMRoleArnAuthType || opt.AuthType == RRSAAuthType || (opt.AuthType == "sts" && opt.FuseType == JindoFsType)
+}
+
+// temporaryMounts records the mount points whose credential files hold temporary credentials, which expire
+var temporaryMounts sync.Map
+
+// refreshTemporaryCredentials renew the credential files of mount points using temporary credentials
+func refreshTemporaryCredentials() {
+	temporaryMounts.Range(func(key, value interface{}) bool {
+		mountPoint := key.(string)
+		if !IsFuseMounted(mountPoint) {
+			forgetTemporaryMount(mountPoint)
+			return true
+		}
+		raw, err := jsonCredentialContent(value.(*Options))
+		if err == nil {
+			err = writeCredentialFile(credentialPath(mountPoint, jsonCredentialSuffix), raw)
+		}
+		if err != nil {
+			log.Errorf("RefreshTemporaryCredentials: renew credential of %s is failed, err: %v", mountPoint, err)
+		}
+		return true
+	})
+}
+
+// forgetTemporaryMount stop renewing the credential file of an unmounted mount point,
+// the credential cache evicts credentials no mount point asks for
+func forgetTemporaryMount(mountPoint string) {
+	temporaryMounts.Delete(mountPoint)
+}
+
+// checkClientOptions validate otherOpts with the option allow-list of the fuse client,
+// the connector checks the options of mount requests with the same list
+func checkClientOptions(client, otherOpts string) error {
+	options, flags := fuse.ParseOptions(otherOpts)
+	if err := fuse.CheckOptions(client, options, flags); err != nil {
+		return errors.New("Oss OtherOpts error: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oss
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+)
+
+const (
+	// CredentialServerPortEnv is the loopback port serving temporary credentials to ossfs
+	CredentialServerPortEnv = "CREDENTIAL_SERVER_PORT"
+	// defaultCredentialServerPort is the default loopback port of the credential server
+	defaultCredentialServerPort = "11261"
+	// credentialServerRestartPeriod is the interval to restart the credential server after it fails
+	credentialServerRestartPeriod = 10 * time.Second
+	// procNetTCP is the tcp table of the network namespace of the plugin, which is the host network
+	procNetTCP = "/proc/self/net/tcp"
+)
+
+var (
+	mountPointKeyPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+	// credentialProcRoot is the proc of the host pid namespace, where the client of the credential server is found
+	credentialProcRoot = "/proc"
+	// credentialPids maps a mount point key to the pid of the ossfs last found polling its credential
+	credentialPids sync.Map
+)
+
+// credentialServerAddress return the address of the credential server, the plugin runs
+// in the host network so ossfs on the host reaches it on the loopback interface
+func credentialServerAddress() string {
+	port := strings.TrimSpace(os.Getenv(CredentialServerPortEnv))
+	if port == "" {
+		port = defaultCredentialServerPort
+	}
+	return "127.0.0.1:" + port
+}
+
+// ossfsRoleCredential write the temporary credential of an ossfs mount point and return the ram_role url
+// pointing ossfs to it, with the token file of the mount point. ossfs polls the url before the credential
+// expires. The url carries no secret, it shows up in process listings; the token is passed in a 0600 file
+// and reaches ossfs in its environment.
+func ossfsRoleCredential(opt *Options, mountPoint string) (string, string, error) {
+	raw, err := jsonCredentialContent(opt)
+	if err != nil {
+		return "", "", err
+	}
+	if err := writeCredentialFile(credentialPath(mountPoint, jsonCredentialSuffix), raw); err != nil {
+		return "", "", err
+	}
+	tokenFile, err := credentialToken(mountPoint)
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("http://%s/credentials/%s", credentialServerAddress(), mountPointKey(mountPoint)), tokenFile, nil
+}
+
+// credentialToken return the token file of a mount point. A random token is written unless the file
+// exists, a remounted or restarted ossfs keeps the token of its mount point.
+func credentialToken(mountPoint string) (string, error) {
+	path := credentialPath(mountPoint, credentialTokenSuffix)
+	if raw, err := ioutil.ReadFile(filepath.Join(fuse.HostPrefix, path)); err == nil && len(strings.TrimSpace(string(raw))) > 0 {
+		return path, nil
+	}
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return path, writeCredentialFile(path, []byte(hex.EncodeToString(token)))
+}
+
+// serveCredentials serve the credential files of mount points to their ossfs at /credentials/<key>
+func serveCredentials() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/credentials/", credentialHandler)
+	server := &http.Server{Addr: credentialServerAddress(), Handler: mux}
+	log.Infof("Credential server listening on address: %s", server.Addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Errorf("Credential server listen and serve err: %v", err)
+	}
+}
+
+func credentialHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/credentials/")
+	if !mountPointKeyPattern.MatchString(key) {
+		http.NotFound(w, r)
+		return
+	}
+	dir := filepath.Join(fuse.HostPrefix, CredentialDir)
+	token, err := ioutil.ReadFile(filepath.Join(dir, key+credentialTokenSuffix))
+	if err != nil || len(bytes.TrimSpace(token)) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if !fromMount(r, key, bytes.TrimSpace(token)) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(dir, key+jsonCredentialSuffix))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	cred := jsonCredential{}
+	if err := json.Unmarshal(raw, &cred); err != nil {
+		http.Error(w, "invalid credential file", http.StatusInternalServerError)
+		return
+	}
+	// the response of the ram role credentials of the metadata server
+	resp, _ := json.Marshal(struct {
+		jsonCredential
+		Code        string
+		LastUpdated string
+	}{cred, "Success", time.Now().UTC().Format(time.RFC3339)})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// fromMount return if the client of the request is the ossfs of the mount point of token: a process of root
+// holding the token in its environment. The plugin runs in the
+// host network and the host pid namespace, the client socket is found in the tcp table of the host by its
+// address and port, and its process by the inode of the socket.
+func fromMount(r *http.Request, key string, token []byte) bool {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	table, err := ioutil.ReadFile(procNetTCP)
+	if err != nil {
+		log.Errorf("Credential server: read %s is failed, err: %v", procNetTCP, err)
+		return false
+	}
+	uid, inode, ok := tcpSocket(table, r.RemoteAddr, local.String())
+	if !ok {
+		log.Warnf("Credential server: socket of client %s is not found", r.RemoteAddr)
+		return false
+	}
+	if uid != 0 {
+		log.Warnf("Credential server: refuse client %s of uid %d", r.RemoteAddr, uid)
+		return false
+	}
+	if _, ok := mountProcess(credentialProcRoot, key, inode, token); !ok {
+		log.Warnf("Credential server: refuse client %s, no process holding the token of the mount point owns its socket", r.RemoteAddr)
+		return false
+	}
+	return true
+}
+
+// tcpSocket return the uid of the owner and the inode of the ipv4 tcp socket from local to remote in the
+// table of /proc/net/tcp
+func tcpSocket(table []byte, local, remote string) (int, string, bool) {
+	for _, line := range strings.Split(string(table), "\n") {
+		fields := strings.Fields(line)
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		if len(fields) < 10 || procTCPAddr(fields[1]) != local || procTCPAddr(fields[2]) != remote {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[7])
+		return uid, fields[9], err == nil
+	}
+	return 0, "", false
+}
+
+// mountProcess return the process holding both the socket of inode and the token of the mount point key in its
+// environment. The process found last for the mount point is tried first, other processes are only searched
+// for the socket when their environment holds the token, so the fds of unrelated host processes are never read.
+func mountProcess(procRoot, key, inode string, token []byte) (int, bool) {
+	if inode == "" || inode == "0" {
+		return 0, false
+	}
+	if value, ok := credentialPids.Load(key); ok {
+		if pid := value.(int); hasToken(procRoot, pid, token) && holdsSocket(procRoot, pid, inode) {
+			return pid, true
+		}
+	}
+	dir, err := os.Open(procRoot)
+	if err != nil {
+		return 0, false
+	}
+	names, _ := dir.Readdirnames(-1)
+	dir.Close()
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil || !hasToken(procRoot, pid, token) {
+			continue
+		}
+		if holdsSocket(procRoot, pid, inode) {
+			credentialPids.Store(key, pid)
+			return pid, true
+		}
+	}
+	return 0, false
+}
+
+// hasToken return if the environment of process pid holds token as fuse.CredentialTokenEnv
+func hasToken(procRoot string, pid int, token []byte) bool {
+	return subtle.ConstantTimeCompare(token, []byte(processEnv(procRoot, pid, fuse.CredentialTokenEnv))) == 1
+}
+
+// holdsSocket return if process pid has a fd of the socket of inode
+func holdsSocket(procRoot string, pid int, inode string) bool {
+	fdDir := filepath.Join(procRoot, strconv.Itoa(pid), "fd")
+	d, err := os.Open(fdDir)
+	if err != nil {
+		return false
+	}
+	fds, _ := d.Readdirnames(-1)
+	d.Close()
+	link := "socket:[" + inode + "]"
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join(fdDir, fd)); err == nil && target == link {
+			return true
+		}
+	}
+	return false
+}
+
+// processEnv return the value of the variable key in the environment of process pid, which only root reads
+func processEnv(procRoot string, pid int, key string) string {
+	raw, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "environ"))
+	if err != nil {
+		return ""
+	}
+	for _, kv := range bytes.Split(raw, []byte{0}) {
+		if bytes.HasPrefix(kv, []byte(key+"=")) {
+			return string(kv[len(key)+1:])
+		}
+	}
+	return ""
+}
+
+// procTCPAddr convert an address of /proc/net/tcp, such as 0100007F:2BFD, to 127.0.0.1:11261.
+// The ip is printed as an integer in the byte order of the host.
+func procTCPAddr(addr string) string {
+	parts := strings.Split(addr, ":")
+	if len(parts) != 2 {
+		return ""
+	}
+	ip, err := hex.DecodeString(parts[0])
+	port, perr := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil || perr != nil || len(ip) != net.IPv4len {
+		return ""
+	}
+	if littleEndian {
+		ip[0], ip[1], ip[2], ip[3] = ip[3], ip[2], ip[1], ip[0]
+	}
+	return net.JoinHostPort(net.IP(ip).String(), strconv.FormatUint(port, 10))
+}
+
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oss
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+)
+
+func TestTCPSocket(t *testing.T) {
+	if !littleEndian {
+		t.Skip("the sample table is printed by a little endian host")
+	}
+	table := []byte(`  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:2BFD 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:D431 0100007F:2BFD 01 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:D432 0100007F:2BFD 01 00000000:00000000 00:00000000 00000000  1000        0 1003 1 0000000000000000 20 4 30 10 -1
+   3: 0100007F:2BFD 0100007F:D431 01 00000000:00000000 00:00000000 00000000     0        0 1004 1 0000000000000000 20 4 30 10 -1
+`)
+	tests := []struct {
+		name   string
+		client string
+		uid    int
+		inode  string
+		found  bool
+	}{
+		{"root", "127.0.0.1:54321", 0, "1002", true},
+		{"user", "127.0.0.1:54322", 1000, "1003", true},
+		{"unknown", "127.0.0.1:54323", 0, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uid, inode, found := tcpSocket(table, test.client, "127.0.0.1:11261")
+			assert.Equal(t, test.found, found)
+			assert.Equal(t, test.uid, uid)
+			assert.Equal(t, test.inode, inode)
+		})
+	}
+}
+
+func TestMountProcess(t *testing.T) {
+	root := t.TempDir()
+	for pid, inode := range map[string]string{"10": "1002", "20": "1003"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(root, pid, "fd"), 0755))
+		assert.Nil(t, os.Symlink("socket:["+inode+"]", filepath.Join(root, pid, "fd", "3")))
+		assert.Nil(t, os.Symlink("/dev/null", filepath.Join(root, pid, "fd", "0")))
+	}
+	env := "PATH=/usr/bin\x00" + fuse.CredentialTokenEnv + "=secret\x00"
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "20", "environ"), []byte(env), 0600))
+
+	key := "0123456789abcdef"
+	defer credentialPids.Delete(key)
+	pid, found := mountProcess(root, key, "1003", []byte("secret"))
+	assert.True(t, found)
+	assert.Equal(t, 20, pid)
+	cached, _ := credentialPids.Load(key)
+	assert.Equal(t, 20, cached)
+	// the socket of a process without the token is not searched
+	_, found = mountProcess(root, key, "1002", []byte("secret"))
+	assert.False(t, found)
+	_, found = mountProcess(root, key, "1003", []byte("other"))
+	assert.False(t, found)
+	_, found = mountProcess(root, key, "1004", []byte("secret"))
+	assert.False(t, found)
+	_, found = mountProcess(root, key, "0", []byte("secret"))
+	assert.False(t, found)
+
+	assert.Equal(t, "secret", processEnv(root, 20, fuse.CredentialTokenEnv))
+	assert.Equal(t, "", processEnv(root, 10, fuse.CredentialTokenEnv))
+}
+
+func TestCredentialHandlerPath(t *testing.T) {
+	for _, path := range []string{"/credentials/0123456789abcdef/secret", "/credentials/../token", "/credentials/"} {
+		w := httptest.NewRecorder()
+		credentialHandler(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oss
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
+)
+
+const (
+	// fuseOOMCheckPeriod is the interval to check fuse scopes for oom kills
+	fuseOOMCheckPeriod = 15 * time.Second
+	// fuseOOMKilled is the event reason of an oom killed fuse process
+	fuseOOMKilled = "FuseOOMKilled"
+
+	podNameKey      = "csi.storage.k8s.io/pod.name"
+	podNamespaceKey = "csi.storage.k8s.io/pod.namespace"
+	podUIDKey       = "csi.storage.k8s.io/pod.uid"
+	// serviceAccountTokensKey holds the tokens requested by tokenRequests of the CSIDriver
+	serviceAccountTokensKey = "csi.storage.k8s.io/serviceAccount.tokens"
+	serviceAccountNameKey   = "csi.storage.k8s.io/serviceAccount.name"
+)
+
+// fuseLauncher return the launch starting a fuse process in its own scope unit,
+// with systemd on hosts running it and with the connector's process launcher otherwise
+func fuseLauncher(opt *Options, unit string) (*fuse.Launch, error) {
+	r, err := fuse.ParseResources(opt.FuseMemoryLimit, opt.FuseCPUQuota, opt.FuseTasksMax)
+	if err != nil {
+		return nil, err
+	}
+	launcher := fuse.SelectLauncher(fuse.HostProcRoot)
+	return fuse.NewLaunch(launcher, unit, r, ""), nil
+}
+
+// fuseRestartPolicy return the restart policy of the fuse process set by the volume attributes,
+// nil if none is set so the process is never restarted
+func fuseRestartPolicy(opt *Options) (*connector.RestartPolicy, error) {
+	if opt.FuseRestartPolicy == "" && opt.FuseMaxRestarts == "" {
+		return nil, nil
+	}
+	p := &connector.RestartPolicy{Policy: opt.FuseRestartPolicy}
+	if p.Policy == "" {
+		p.Policy = connector.RestartOnFailure
+	}
+	if opt.FuseMaxRestarts != "" {
+		n, err := strconv.Atoi(opt.FuseMaxRestarts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fuseMaxRestarts %q", opt.FuseMaxRestarts)
+		}
+		p.MaxRestarts = n
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// scopeOwner return the object to report events of a fuse scope on, the pod when the
+// mount point serves a single pod and the persistent volume otherwise
+func scopeOwner(volumeID string, volumeContext map[string]string, shared bool) *v1.ObjectReference {
+	if !shared && volumeContext[podNameKey] != "" {
+		return &v1.ObjectReference{
+			Kind:      "Pod",
+			Name:      volumeContext[podNameKey],
+			Namespace: volumeContext[podNamespaceKey],
+			UID:       types.UID(volumeContext[podUIDKey]),
+		}
+	}
+	return &v1.ObjectReference{Kind: "PersistentVolume", Name: volumeID}
+}
+
+// fuseOOMWatcher reports fuse processes killed by the oom killer in the fuse slice
+type fuseOOMWatcher struct {
+	recorder record.EventRecorder
+	// owners maps a scope unit to the object its events are reported on
+	owners      sync.Map
+	cgroupRoot  string
+	scopes      map[string]fuse.ScopeStat
+	sliceKills  uint64
+	initialized bool
+}
+
+func newFuseOOMWatcher(recorder record.EventRecorder) *fuseOOMWatcher {
+	return &fuseOOMWatcher{
+		recorder:   recorder,
+		cgroupRoot: fuse.HostCgroupRoot,
+		scopes:     map[string]fuse.ScopeStat{},
+	}
+}
+
+// track remember the owner of a scope started by NodePublishVolume
+func (w *fuseOOMWatcher) track(unit string, owner *v1.ObjectReference) {
+	w.owners.Store(unit, owner)
+}
+
+// forget drop the owner of a scope whose mount failed, check only drops the scopes it saw
+func (w *fuseOOMWatcher) forget(unit string) {
+	w.owners.Delete(unit)
+}
+
+// check compare the oom kill counters with the last check. A killed fuse process takes its scope
+// with it, so scopes vanishing while the slice counter grows are reported as killed too.
+func (w *fuseOOMWatcher) check() {
+	stats, err := fuse.ListScopeStats(w.cgroupRoot)
+	if err != nil {
+		log.Warnf("FuseOOMWatcher: list fuse scopes is failed, err: %v", err)
+		return
+	}
+	sliceKills, _ := fuse.SliceOOMKills(w.cgroupRoot)
+	current := map[string]fuse.ScopeStat{}
+	for _, s := range stats {
+		current[s.Unit] = s
+	}
+	if w.initialized {
+		reported := uint64(0)
+		for unit, s := range current {
+			if last, ok := w.scopes[unit]; ok && s.OOMKills > last.OOMKills {
+				reported += s.OOMKills - last.OOMKills
+				w.report(s, "a process of the fuse scope was killed by the oom killer")
+			}
+		}
+		if sliceKills > w.sliceKills+reported {
+			for unit, s := range w.scopes {
+				if _, ok := current[unit]; !ok {
+					w.report(s, "the fuse process was killed by the oom killer and the mount point is disconnected")
+				}
+			}
+		}
+	}
+	for unit := range w.scopes {
+		if _, ok := current[unit]; !ok {
+			w.owners.Delete(unit)
+		}
+	}
+	w.scopes = current
+	w.sliceKills = sliceKills
+	w.initialized = true
+}
+
+func (w *fuseOOMWatcher) report(s fuse.ScopeStat, message string) {
+	owner := &v1.ObjectReference{Kind: "PersistentVolume", Name: s.VolumeID}
+	if value, ok := w.owners.Load(s.Unit); ok {
+		owner = value.(*v1.ObjectReference)
+	}
+	reason := fmt.Sprintf("Volume %s: %s, scope: %s, node: %s", s.VolumeID, message, s.Unit, os.Getenv("KUBE_NODE_NAME"))
+	log.Warnf("FuseOOMWatcher: %s", reason)
+	if w.recorder != nil {
+		utils.CreateEvent(w.recorder, owner, v1.EventTypeWarning, fuseOOMKilled, reason)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oss
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
+)
+
+const (
+	// fuseExitCheckPeriod is the interval to look up the fuse processes supervised by the connector
+	fuseExitCheckPeriod = 15 * time.Second
+	// fuseProcessExited is the event reason of a fuse process which exited while its target was mounted
+	fuseProcessExited = "FuseProcessExited"
+	// fuseProcessRestarted is the event reason of a fuse process restarted by the connector
+	fuseProcessRestarted = "FuseProcessRestarted"
+	// fuseExitLogLines is the number of log lines of an exited fuse process put in its event
+	fuseExitLogLines = 3
+)
+
+// fuseExitWatcher reports the fuse processes the connector saw exiting and restarting. The connector
+// binds a restarted mount over the disconnected one, the targets bound to a shared mount point and the
+// containers receive it by propagation.
+type fuseExitWatcher struct {
+	recorder record.EventRecorder
+	// owners maps a scope unit to the object its events are reported on
+	owners sync.Map
+	// exits is the time of the last exit reported of each target
+	exits       map[string]time.Time
+	initialized bool
+}
+
+func newFuseExitWatcher(recorder record.EventRecorder) *fuseExitWatcher {
+	return &fuseExitWatcher{recorder: recorder, exits: map[string]time.Time{}}
+}
+
+// track remember the owner of a scope started by NodePublishVolume
+func (w *fuseExitWatcher) track(unit string, owner *v1.ObjectReference) {
+	w.owners.Store(unit, owner)
+}
+
+// forget drop the owner of a scope whose mount failed, check only drops the scopes the connector reported
+func (w *fuseExitWatcher) forget(unit string) {
+	w.owners.Delete(unit)
+}
+
+// check report the exits recorded by the connector since the last check. Exits before the first
+// check happened before the plugin started and are not reported again.
+func (w *fuseExitWatcher) check() {
+	status, err := utils.ConnectorStatus()
+	if err != nil {
+		// connectors of the legacy protocol supervise nothing
+		log.Debugf("FuseExitWatcher: get connector status is failed, err: %v", err)
+		return
+	}
+	exits, units := map[string]time.Time{}, map[string]bool{}
+	for i := range status.Processes {
+		p := &status.Processes[i]
+		units[p.Unit] = true
+		if p.LastExit == nil {
+			continue
+		}
+		exits[p.Target] = p.LastExit.Time
+		if last, ok := w.exits[p.Target]; w.initialized && (!ok || p.LastExit.Time.After(last)) {
+			w.handle(p)
+		}
+	}
+	w.owners.Range(func(unit, _ interface{}) bool {
+		if !units[unit.(string)] {
+			w.owners.Delete(unit)
+		}
+		return true
+	})
+	w.exits = exits
+	w.initialized = true
+}
+
+// handle report the exit of a fuse process and its restart
+func (w *fuseExitWatcher) handle(p *connector.Process) {
+	owner := &v1.ObjectReference{Kind: "PersistentVolume", Name: p.VolumeID}
+	if value, ok := w.owners.Load(p.Unit); ok {
+		owner = value.(*v1.ObjectReference)
+	}
+	exit := p.LastExit
+	message := fmt.Sprintf("Volume %s: the fuse process of %s exited, result: %s, scope: %s, node: %s", p.VolumeID, p.Target, exit.Result, p.Unit, os.Getenv("KUBE_NODE_NAME"))
+	if exit.Message != "" {
+		message += ", " + exit.Message
+	}
+	if logs := lastLines(exit.Logs, fuseExitLogLines); len(logs) > 0 {
+		message += ", logs: " + strings.Join(logs, " | ")
+	}
+	w.report(owner, v1.EventTypeWarning, fuseProcessExited, message)
+	if !exit.Restarted {
+		return
+	}
+	message = fmt.Sprintf("Volume %s: the fuse process of %s is restarted by the connector, restarts: %d", p.VolumeID, p.Target, p.Restarts)
+	w.report(owner, v1.EventTypeNormal, fuseProcessRestarted, message)
+}
+
+func (w *fuseExitWatcher) report(owner *v1.ObjectReference, eventType, reason, message string) {
+	if eventType == v1.EventTypeWarning {
+		log.Warnf("FuseExitWatcher: %s", message)
+	} else {
+		log.Infof("FuseExitWatcher: %s", message)
+	}
+	if w.recorder != nil {
+		utils.CreateEvent(w.recorder, owner, eventType, reason, message)
+	}
+}
+
+// lastLines return the last n lines
+func lastLines(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oss
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+	"wujunyi792/oss-csi-lite-plugin/pkg/metric"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
+)
+
+const (
+	// OssfsVersionTopologyKey is the topology key reporting the ossfs version in NodeGetInfo
+	OssfsVersionTopologyKey = driverName + "/ossfs-version"
+	// fuseProbePeriod is the interval to probe host fuse clients, catching upgrades
+	fuseProbePeriod = 5 * time.Minute
+)
+
+// fuseRegistry return the admin-defined registry of fuse clients, an invalid registry
+// is logged and ignored so that volumes without fuseVersion keep mounting
+func fuseRegistry() *fuse.Registry {
+	r, err := fuse.LoadRegistry(fuse.RegistryPath())
+	if err != nil {
+		log.Errorf("Load fuse registry is failed, err: %v", err)
+		return &fuse.Registry{}
+	}
+	return r
+}
+
+// syncFuseRegistry copy the registry to the host, where the connector allows its binaries
+func syncFuseRegistry() {
+	if err := fuse.SyncRegistry(fuse.RegistryPath(), filepath.Join(fuse.HostPrefix, fuse.HostRegistryPath)); err != nil {
+		log.Errorf("Sync fuse registry to host is failed, err: %v", err)
+	}
+}
+
+// ossfsBinary return the ossfs binary used for mounting, version selects a named version of the registry
+func ossfsBinary(version string) (string, error) {
+	return fuseRegistry().Binary(fuse.OssfsClient, version, fuse.HostPrefix)
+}
+
+// jindofsBinary return the jindo-fuse binary used for mounting, version selects a named version of the registry
+func jindofsBinary(version string) (string, error) {
+	return fuseRegistry().Binary(fuse.JindofsClient, version, fuse.HostPrefix)
+}
+
+// probeOssfs run `ossfs --version` on host through the connector
+func probeOssfs(binary string) (*fuse.Capability, error) {
+	out, err := utils.ConnectorRun(fmt.Sprintf("%s --version", binary))
+	if err != nil {
+		return nil, fmt.Errorf("probe ossfs version: %s", out)
+	}
+	return fuse.NewOssfsCapability(out)
+}
+
+// refreshOssfsCapability probe the default ossfs installed on host and cache its capability,
+// capabilities of named versions are probed again on their next use
+func (ns *nodeServer) refreshOssfsCapability() {
+	syncFuseRegistry()
+	binary, err := ossfsBinary("")
+	if err != nil {
+		log.Warnf("RefreshOssfsCapability: %v", err)
+		return
+	}
+	c, err := probeOssfs(binary)
+	if err != nil {
+		log.Warnf("RefreshOssfsCapability: %v", err)
+		return
+	}
+	ns.capabilityMutex.Lock()
+	old := ns.ossfsCapability
+	ns.ossfsCapability = c
+	ns.ossfsCapabilities = map[string]*fuse.Capability{binary: c}
+	ns.capabilityMutex.Unlock()
+
+	if old == nil || old.Version != c.Version {
+		log.Infof("RefreshOssfsCapability: ossfs version on host is %s", c.Version)
+		metric.SetFuseClientVersion(fuse.OssfsClient, c.Version.String())
+	}
+}
+
+// getOssfsCapability return the cached capability of the default ossfs, probing once if it is unknown
+func (ns *nodeServer) getOssfsCapability() *fuse.Capability {
+	ns.capabilityMutex.RLock()
+	c := ns.ossfsCapability
+	ns.capabilityMutex.RUnlock()
+	if c != nil {
+		return c
+	}
+	ns.refreshOssfsCapability()
+	ns.capabilityMutex.RLock()
+	defer ns.capabilityMutex.RUnlock()
+	return ns.ossfsCapability
+}
+
+// getBinaryCapability return the cached capability of an ossfs binary, probing once if it is unknown
+func (ns *nodeServer) getBinaryCapability(binary string) *fuse.Capability {
+	ns.capabilityMutex.RLock()
+	c := ns.ossfsCapabilities[binary]
+	ns.capabilityMutex.RUnlock()
+	if c != nil {
+		return c
+	}
+	c, err := probeOssfs(binary)
+	if err != nil {
+		log.Warnf("GetBinaryCapability: %v", err)
+		return nil
+	}
+	ns.capabilityMutex.Lock()
+	if ns.ossfsCapabilities == nil {
+		ns.ossfsCapabilities = map[string]*fuse.Capability{}
+	}
+	ns.ossfsCapabilities[binary] = c
+	ns.capabilityMutex.Unlock()
+	return c
+}
+
+// checkFuseOptions reject otherOpts which the fuse client selected by the volume does not support
+func (ns *nodeServer) checkFuseOptions(opt *Options) error {
+	if opt.FuseType != OssFsType || opt.OtherOpts == "" {
+		return nil
+	}
+	var c *fuse.Capability
+	if opt.FuseVersion == "" {
+		c = ns.getOssfsCapability()
+	} else {
+		binary, err := ossfsBinary(opt.FuseVersion)
+		if err != nil {
+			return err
+		}
+		c = ns.getBinaryCapability(binary)
+	}
+	if c == nil {
+		log.Warnf("CheckFuseOptions: ossfs version is unknown, skip options check: %s", opt.OtherOpts)
+		return nil
+	}
+	return c.CheckOptions(fuse.ParseOptionNames(opt.OtherOpts))
+}
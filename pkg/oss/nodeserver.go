@@ -21,16 +21,22 @@ import (
 	"errors"
 	"fmt"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"io/ioutil"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	k8smount "k8s.io/utils/mount"
 	"strconv"
 	"strings"
 	"sync"
+	"wujunyi792/oss-csi-lite-plugin/pkg/auth"
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
 	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
 )
 
@@ -38,7 +44,15 @@ type nodeServer struct {
 	k8smounter k8smount.Interface
 	*csicommon.DefaultNodeServer
 	dynamicClient        dynamic.Interface
+	clientSet            kubernetes.Interface
 	writeCredentialMutex sync.Mutex
+	capabilityMutex      sync.RWMutex
+	ossfsCapability      *fuse.Capability
+	ossfsCapabilities    map[string]*fuse.Capability
+	oomWatcher           *fuseOOMWatcher
+	exitWatcher          *fuseExitWatcher
+	recorder             record.EventRecorder
+	credentialProviders  *auth.ProviderChain
 }
 
 // Options contains options for target oss
@@ -53,10 +67,35 @@ type Options struct {
 	AuthType      string `json:"authType"`
 	FuseType      string `json:"fuseType"`
 	MetricsTop    string `json:"metricsTop"`
+	// CacheDir is true or the sub directory of the node cache root holding the cache
+	CacheDir       string `json:"cacheDir"`
+	CacheSizeLimit string `json:"cacheSizeLimit"`
+	CacheMedium    string `json:"cacheMedium"`
+	// resource limits of the fuse process scope
+	FuseMemoryLimit string `json:"fuseMemoryLimit"`
+	FuseCPUQuota    string `json:"fuseCPUQuota"`
+	FuseTasksMax    string `json:"fuseTasksMax"`
+	// restart policy of the fuse process, see connector.RestartPolicy
+	FuseRestartPolicy string `json:"fuseRestartPolicy"`
+	FuseMaxRestarts   string `json:"fuseMaxRestarts"`
+	// FuseVersion selects a named version of the fuse client registry
+	FuseVersion string `json:"fuseVersion"`
+	// RoleArn is the ram role assumed by authType ramRoleArn and rrsa
+	RoleArn string `json:"roleArn"`
+	// OIDCProviderArn is the oidc provider of the cluster trusted by the role of authType rrsa
+	OIDCProviderArn string `json:"oidcProviderArn"`
+	// serviceAccountToken is the token of the pod exchanged for its role credential by authType rrsa
+	serviceAccountToken string
+	// serviceAccount is the namespace/name of the service account of the pod
+	serviceAccount string
+	// envAK is set when the AccessKey comes from the plugin environment
+	envAK bool
+	// credentialProvider is the name of the provider supplying the AccessKey
+	credentialProvider string
 }
 
 const (
-	// OssfsCredentialFile is the path of oss ak credential file
+	// OssfsCredentialFile is the legacy host-wide oss ak credential file, see SharedCredentialFileEnv
 	OssfsCredentialFile = "/host/etc/passwd-ossfs"
 	// NsenterCmd is nsenter mount command
 	NsenterCmd = "nsenter --mount=/proc/1/ns/mnt"
@@ -68,10 +107,22 @@ const (
 	OssFsType = "ossfs"
 	// JindoFsType tag
 	JindoFsType = "jindofs"
+	// RAMRoleArnAuthType assumes the ram role of roleArn with the AccessKey of the volume
+	RAMRoleArnAuthType = "ramrolearn"
+	// RRSAAuthType assumes the ram role of roleArn with the service account token of the pod
+	RRSAAuthType = "rrsa"
+	// JindoFuseMountType is the filesystem type of jindo-fuse mount points
+	JindoFuseMountType = "fuse.jindo-fuse"
 	// metricsPathPrefix
 	metricsPathPrefix = "/host/var/run/ossfs/"
+	// maxStackedMounts bounds the mounts unmounted from a target, the connector binds a restarted fuse
+	// process over the disconnected mount
+	maxStackedMounts = 16
 )
 
+// targetLockRoot is the host root directory of fuse.TargetLockDir, shared with the connector
+var targetLockRoot = fuse.HostPrefix
+
 func validateNodePublishVolumeRequest(req *csi.NodePublishVolumeRequest) error {
 	valid, err := utils.CheckRequest(req.GetVolumeContext(), req.GetTargetPath())
 	if !valid {
@@ -82,7 +133,7 @@ func validateNodePublishVolumeRequest(req *csi.NodePublishVolumeRequest) error {
 
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	// logout oss paras
-	log.Infof("NodePublishVolume:: Starting Mount volume: %s mount with req: %+v", req.VolumeId, req)
+	log.Infof("NodePublishVolume:: Starting Mount volume: %s mount with req: %s", req.VolumeId, protosanitizer.StripSecrets(req))
 	mountPath := req.GetTargetPath()
 	if err := validateNodePublishVolumeRequest(req); err != nil {
 		return nil, err
@@ -92,8 +143,6 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	opt.UseSharedPath = false
 	opt.FuseType = OssFsType
 	opt.MetricsTop = "10"
-	fmt.Println("NodePublishVolume")
-	fmt.Println(req.VolumeContext)
 	for key, value := range req.VolumeContext {
 		key = strings.ToLower(key)
 		if key == "bucket" {
@@ -102,10 +151,6 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 			opt.URL = strings.TrimSpace(value)
 		} else if key == "otheropts" {
 			opt.OtherOpts = strings.TrimSpace(value)
-		} else if key == "akid" {
-			opt.AkID = strings.TrimSpace(value)
-		} else if key == "aksecret" {
-			opt.AkSecret = strings.TrimSpace(value)
 		} else if key == "path" {
 			if v := strings.TrimSpace(value); v == "" {
 				opt.Path = "/"
@@ -122,9 +167,39 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 			opt.FuseType = strings.ToLower(strings.TrimSpace(value))
 		} else if key == "metricstop" {
 			opt.MetricsTop = strings.ToLower(strings.TrimSpace(value))
+		} else if key == "cachedir" {
+			opt.CacheDir = strings.TrimSpace(value)
+		} else if key == "cachesizelimit" {
+			opt.CacheSizeLimit = strings.TrimSpace(value)
+		} else if key == "cachemedium" {
+			opt.CacheMedium = strings.ToLower(strings.TrimSpace(value))
+		} else if key == "fusememorylimit" {
+			opt.FuseMemoryLimit = strings.TrimSpace(value)
+		} else if key == "fusecpuquota" {
+			opt.FuseCPUQuota = strings.TrimSpace(value)
+		} else if key == "fusetasksmax" {
+			opt.FuseTasksMax = strings.TrimSpace(value)
+		} else if key == "fuserestartpolicy" {
+			opt.FuseRestartPolicy = strings.ToLower(strings.TrimSpace(value))
+		} else if key == "fusemaxrestarts" {
+			opt.FuseMaxRestarts = strings.TrimSpace(value)
+		} else if key == "fuseversion" {
+			opt.FuseVersion = strings.TrimSpace(value)
+		} else if key == "rolearn" {
+			opt.RoleArn = strings.TrimSpace(value)
+		} else if key == "oidcproviderarn" {
+			opt.OIDCProviderArn = strings.TrimSpace(value)
+		} else if key == strings.ToLower(serviceAccountTokensKey) {
+			opt.serviceAccountToken = parseServiceAccountToken(value)
+		} else if key == strings.ToLower(serviceAccountNameKey) {
+			opt.serviceAccount = value
 		}
 	}
 
+	if opt.serviceAccount != "" {
+		opt.serviceAccount = req.VolumeContext[podNamespaceKey] + "/" + opt.serviceAccount
+	}
+
 	if len(opt.Bucket) == 0 {
 		return nil, errors.New("empty bucket")
 	}
@@ -134,14 +209,9 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		opt.Path = "/"
 	}
 
-	// support set ak by secret
-	if opt.AkID == "" || opt.AkSecret == "" {
-		if value, ok := req.Secrets[AkID]; ok {
-			opt.AkID = value
-		}
-		if value, ok := req.Secrets[AkSecret]; ok {
-			opt.AkSecret = value
-		}
+	if err := ns.lookupAccessKey(req, opt); err != nil {
+		log.Errorf("Look up the AccessKey of volume %s is failed, err: %v", req.GetVolumeId(), err)
+		return nil, status.Error(codes.Unavailable, "Look up the AccessKey is failed, err: "+err.Error())
 	}
 
 	// check parameters
@@ -153,49 +223,56 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		log.Errorf("Check oss input error: mountPath is empty")
 		return nil, errors.New("mountPath is empty")
 	}
+	if _, err := fuse.ParseResources(opt.FuseMemoryLimit, opt.FuseCPUQuota, opt.FuseTasksMax); err != nil {
+		log.Errorf("Check oss input error: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, "Check oss input error: "+err.Error())
+	}
+	if _, err := fuseRestartPolicy(opt); err != nil {
+		log.Errorf("Check oss input error: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, "Check oss input error: "+err.Error())
+	}
+	fuseBinary, err := ossfsBinary(opt.FuseVersion)
+	if opt.FuseType == JindoFsType {
+		fuseBinary, err = jindofsBinary(opt.FuseVersion)
+	}
+	if err != nil {
+		log.Errorf("Check oss input error: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, "Check oss input error: "+err.Error())
+	}
+	if err := checkCacheOptions(opt); err != nil {
+		log.Errorf("Check oss input error: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, "Check oss input error: "+err.Error())
+	}
+	if err := ns.checkFuseOptions(opt); err != nil {
+		log.Errorf("Check oss input error: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, "Check oss input error: "+err.Error())
+	}
 
 	argStr := fmt.Sprintf("Bucket: %s, url: %s, , OtherOpts: %s, Path: %s, UseSharedPath: %s, authType: %s", opt.Bucket, opt.URL, opt.OtherOpts, opt.Path, strconv.FormatBool(opt.UseSharedPath), opt.AuthType)
 	log.Infof("NodePublishVolume:: Starting Oss Mount: %s", argStr)
 
-	if IsOssfsMounted(mountPath) {
+	if IsFuseMounted(mountPath) {
 		log.Infof("NodePublishVolume: The mountpoint is mounted: %s", mountPath)
-		return &csi.NodePublishVolumeResponse{}, nil
-	}
-
-	// If you do not use sts authentication, save ak
-	credentialProvider := ""
-	if opt.AuthType != "sts" {
-		if opt.FuseType == OssFsType {
-			if err := ns.saveOssCredential(opt); err != nil {
-				return nil, errors.New("Save ossfs ak is failed, err: " + err.Error())
-			}
-		} else if opt.FuseType == JindoFsType {
-			credentialProvider = fmt.Sprintf("-ofs.oss.accessKeyId=%s -ofs.oss.accessKeySecret=%s", opt.AkID, opt.AkSecret)
-		}
-	} else {
-		if opt.FuseType == OssFsType {
-			credentialProvider = GetRAMRoleOption()
-		} else if opt.FuseType == JindoFsType {
-			credentialProvider = "-ofs.oss.provider.endpoint=ECS_ROLE"
+		// kubelet republishes mounted volumes periodically, which picks up rotated AccessKeys
+		if err := ns.rotateCredential(req, opt); err != nil {
+			return nil, err
 		}
+		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
 	// default use allow_other
 	var mntCmd string
 	if opt.UseSharedPath {
 		sharedPath := GetGlobalMountPath(req.GetVolumeId())
-		if IsOssfsMounted(sharedPath) {
+		if IsFuseMounted(sharedPath) {
 			log.Infof("NodePublishVolume: The shared path: %s is already mounted", sharedPath)
 		} else {
 			if err := utils.CreateDest(sharedPath); err != nil {
 				log.Errorf("Ossfs mount is failed, err: %v", err.Error())
 				return nil, errors.New("Create OSS volume fail: " + err.Error())
 			}
-			mntCmd = fmt.Sprintf("systemd-run --scope -- /usr/local/bin/ossfs %s:%s %s -ourl=%s %s %s", opt.Bucket, opt.Path, sharedPath, opt.URL, opt.OtherOpts, credentialProvider)
-			if opt.FuseType == JindoFsType {
-				mntCmd = fmt.Sprintf("systemd-run --scope -- /etc/jindofs-tool/jindo-fuse %s -ouri=oss://%s%s -ofs.oss.endpoint=%s %s", sharedPath, opt.Bucket, opt.Path, opt.URL, credentialProvider)
-			}
-			if err := utils.DoMountInHost(mntCmd); err != nil {
+			unit := fuse.ScopeUnit(req.GetVolumeId(), mountPointKey(sharedPath))
+			if mntCmd, err = ns.mountFuse(req, opt, fuseBinary, sharedPath, unit, true); err != nil {
 				return nil, err
 			}
 		}
@@ -212,18 +289,15 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 			return nil, errors.New("Mount is failed, with create path err: " + err.Error() + mountPath)
 		}
 
-		//metaZoneID := GetMetaDataAsync(regionTag)
+		//metaZoneID, _ := nodeMetadata.Zone()
 		//if strings.Contains(opt.URL, metaZoneID) && !strings.Contains(opt.URL, "internal") && !utils.IsPrivateCloud() {
 		//	originUrl := opt.URL
 		//	opt.URL = strings.ReplaceAll(originUrl, metaZoneID, metaZoneID+"-internal")
 		//}
 
-		mntCmd = fmt.Sprintf("systemd-run --scope -- /usr/local/bin/ossfs %s:%s %s -ourl=%s %s %s", opt.Bucket, opt.Path, mountPath, opt.URL, opt.OtherOpts, credentialProvider)
-		if opt.FuseType == JindoFsType {
-			mntCmd = fmt.Sprintf("systemd-run --scope -- /etc/jindofs-tool/jindo-fuse %s -ouri=oss://%s%s -ofs.oss.endpoint=%s %s", mountPath, opt.Bucket, opt.Path, opt.URL, credentialProvider)
-		}
-		utils.WriteMetricsInfo(metricsPathPrefix, req, opt.MetricsTop, OssFsType, "oss", opt.Bucket)
-		if err := utils.DoMountInHost(mntCmd); err != nil {
+		utils.WriteMetricsInfo(metricsPathPrefix, req, opt.MetricsTop, opt.FuseType, "oss", opt.Bucket)
+		unit := fuse.ScopeUnit(req.GetVolumeId(), mountPointKey(mountPath))
+		if mntCmd, err = ns.mountFuse(req, opt, fuseBinary, mountPath, unit, false); err != nil {
 			return nil, err
 		}
 	}
@@ -302,20 +376,34 @@ func checkOssOptions(opt *Options) error {
 	}
 
 	if opt.FuseType == JindoFsType {
-		return nil
+		return checkClientOptions(fuse.JindofsClient, opt.OtherOpts)
 	}
 
-	// if not input ak from user, use the default ak value
-	if opt.AkID == "" || opt.AkSecret == "" {
-		ac := utils.GetEnvAK()
-		opt.AkID = ac.AccessKeyID
-		opt.AkSecret = ac.AccessKeySecret
-	}
 	if opt.AkID == "" || opt.AkSecret == "" {
 		if opt.AuthType == "" {
 			return errors.New("Oss Parametes error: AK and authType are both empty ")
 		}
 	}
+	if opt.AuthType == RAMRoleArnAuthType {
+		if opt.RoleArn == "" || opt.AkID == "" || opt.AkSecret == "" {
+			return errors.New("Oss Parametes error: authType ramRoleArn requires roleArn and AK ")
+		}
+	}
+	if opt.AuthType == RRSAAuthType {
+		if opt.RoleArn == "" || opt.OIDCProviderArn == "" {
+			return errors.New("Oss Parametes error: authType rrsa requires roleArn and oidcProviderArn ")
+		}
+		if opt.UseSharedPath {
+			return errors.New("Oss Parametes error: authType rrsa mounts with the credential of each pod, it cannot use useSharedPath ")
+		}
+		if opt.serviceAccountToken == "" {
+			return errors.New("Oss Parametes error: no service account token of audience " + auth.OIDCTokenAudience + ", check tokenRequests of the CSIDriver ")
+		}
+		// the credentials of the role are cached by service account, they are not shared across pods of unknown accounts
+		if parts := strings.SplitN(opt.serviceAccount, "/", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return errors.New("Oss Parametes error: authType rrsa requires the namespace and service account of the pod, check podInfoOnMount of the CSIDriver ")
+		}
+	}
 
 	if opt.OtherOpts != "" {
 		if !strings.HasPrefix(opt.OtherOpts, "-o ") {
@@ -323,9 +411,107 @@ func checkOssOptions(opt *Options) error {
 		}
 	}
 
+	return checkClientOptions(fuse.OssfsClient, opt.OtherOpts)
+}
+
+// lookupAccessKey set the AccessKey of the volume supplied by the credential provider chain,
+// authType sts and rrsa mount with temporary credentials and need no AccessKey
+func (ns *nodeServer) lookupAccessKey(req *csi.NodePublishVolumeRequest, opt *Options) error {
+	if opt.AuthType == "sts" || opt.AuthType == RRSAAuthType {
+		return nil
+	}
+	ak, provider, err := ns.credentialProviders.Lookup(&auth.VolumeRequest{
+		VolumeID:   req.GetVolumeId(),
+		Bucket:     opt.Bucket,
+		Attributes: req.GetVolumeContext(),
+		Secrets:    req.GetSecrets(),
+	})
+	if err != nil {
+		return err
+	}
+	if ak == nil {
+		log.Warnf("NodePublishVolume:: no credential provider of %v has the AccessKey of volume %s", ns.credentialProviders.Names(), req.GetVolumeId())
+		return nil
+	}
+	opt.credentialProvider = provider
+	if ak.SecurityToken != "" {
+		// the temporary credential of the managed provider expires, the volume mounts as authType sts
+		// which refreshes it from the same addon token
+		if opt.AuthType != "" {
+			return fmt.Errorf("credential provider %s supplies a temporary credential, which authType %s cannot use", provider, opt.AuthType)
+		}
+		opt.AuthType = "sts"
+		log.Infof("NodePublishVolume:: volume %s uses the temporary credential supplied by credential provider %s", req.GetVolumeId(), provider)
+		return nil
+	}
+	opt.AkID, opt.AkSecret = ak.ID, ak.Secret
+	opt.envAK = provider == auth.ProviderEnv
+	log.Infof("NodePublishVolume:: volume %s uses the AccessKey %s supplied by credential provider %s", req.GetVolumeId(), ak.ID, provider)
 	return nil
 }
 
+// mountFuse start the fuse process serving mountPoint in the scope unit, and return the mount command
+func (ns *nodeServer) mountFuse(req *csi.NodePublishVolumeRequest, opt *Options, fuseBinary, mountPoint, unit string, shared bool) (string, error) {
+	cacheDir, cacheOpts, err := ns.prepareCache(opt, req.GetVolumeId(), mountPoint)
+	if err != nil {
+		log.Errorf("Prepare cache is failed, err: %v", err)
+		return "", errors.New("Create OSS volume fail: " + err.Error())
+	}
+	m := &connector.MountRequest{Client: opt.FuseType, Binary: fuseBinary, Bucket: opt.Bucket, Path: opt.Path, URL: opt.URL, Target: mountPoint, VolumeID: req.GetVolumeId()}
+	m.Restart, _ = fuseRestartPolicy(opt)
+	if name := req.VolumeContext[podNameKey]; !shared && name != "" {
+		m.Pod = req.VolumeContext[podNamespaceKey] + "/" + name
+	}
+	m.Options, m.Flags = fuse.ParseOptions(opt.OtherOpts)
+	m.Options, m.CacheDir = append(m.Options, cacheOpts...), cacheDir
+	if err := ns.setCredential(opt, mountPoint, m); err != nil {
+		log.Errorf("Save %s credential is failed, err: %v", opt.FuseType, err)
+		ns.cleanupMount(req.GetVolumeId(), mountPoint)
+		return "", errors.New("Save " + opt.FuseType + " credential is failed, err: " + err.Error())
+	}
+	launch, err := fuseLauncher(opt, unit)
+	if err != nil {
+		log.Errorf("Parse fuse resources is failed, err: %v", err)
+		ns.cleanupMount(req.GetVolumeId(), mountPoint)
+		return "", errors.New("Create OSS volume fail: " + err.Error())
+	}
+	m.Launcher, m.Unit, m.CgroupParent, m.Resources = launch.Launcher, launch.Unit, launch.CgroupParent, launch.Resources
+	owner := scopeOwner(req.GetVolumeId(), req.VolumeContext, shared)
+	ns.oomWatcher.track(unit, owner)
+	ns.exitWatcher.track(unit, owner)
+	if err := utils.DoMountInHost(m); err != nil {
+		ns.oomWatcher.forget(unit)
+		ns.exitWatcher.forget(unit)
+		ns.cleanupMount(req.GetVolumeId(), mountPoint)
+		return "", err
+	}
+	if refreshesCredentialFile(opt) {
+		temporaryMounts.Store(mountPoint, opt)
+	}
+	return m.CommandLine(), nil
+}
+
+// setCredential write the credential file of a mount point and set the credential the fuse client reads
+func (ns *nodeServer) setCredential(opt *Options, mountPoint string, m *connector.MountRequest) error {
+	var err error
+	switch {
+	case opt.FuseType == JindoFsType:
+		m.CredentialFile, err = jindoCredentialFile(opt, mountPoint)
+	case opt.AuthType == "sts":
+		m.CredentialURL, err = GetRAMRoleURL()
+	case opt.AuthType == RAMRoleArnAuthType || opt.AuthType == RRSAAuthType:
+		m.CredentialURL, m.CredentialTokenFile, err = ossfsRoleCredential(opt, mountPoint)
+	default:
+		if IsSharedCredentialFile() {
+			if err := ns.saveOssCredential(opt); err != nil {
+				return err
+			}
+		}
+		m.CredentialFile, err = ossfsCredentialFile(opt, mountPoint)
+	}
+	return err
+}
+
 func (ns *nodeServer) saveOssCredential(opt *Options) error {
 	// Save ak file for ossfs, exist same entry
 	ns.writeCredentialMutex.Lock()
@@ -348,21 +534,31 @@ func validateNodeUnpublishVolumeRequest(req *csi.NodeUnpublishVolumeRequest) err
 }
 
 func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	log.Infof("NodeUnpublishVolume:: Starting Umount OSS: %s mount with req: %+v", req.TargetPath, req)
+	log.Infof("NodeUnpublishVolume:: Starting Umount OSS: %s mount with req: %s", req.TargetPath, protosanitizer.StripSecrets(req))
 	mountPoint := req.TargetPath
 	err := validateNodeUnpublishVolumeRequest(req)
 	if err != nil {
 		return nil, err
 	}
-	if !IsOssfsMounted(mountPoint) {
+	ctx = utils.WithAuditVolume(ctx, req.GetVolumeId(), "", targetPodUID(mountPoint))
+	// the connector does not restart the fuse process of a target being unmounted
+	unlock, err := fuse.LockTarget(targetLockRoot, mountPoint, connector.DefaultTimeout)
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer unlock()
+	if !IsFuseMounted(mountPoint) {
 		log.Infof("Directory is not mounted: %s", mountPoint)
+		ns.cleanupMount(req.GetVolumeId(), mountPoint)
 		return &csi.NodeUnpublishVolumeResponse{}, nil
 	}
 
 	pvName := req.GetVolumeId()
-	var umntCmd string
+	// the commands run one by one without a shell
+	var umntCmds []string
+	cacheMountPoint := mountPoint
 	sharedMountPoint := GetGlobalMountPath(req.GetVolumeId())
-	if IsOssfsMounted(sharedMountPoint) {
+	if IsFuseMounted(sharedMountPoint) {
 		log.Infof("NodeUnpublishVolume:: Starting umount a shared path oss volume: %s", req.TargetPath)
 		code, err := IsLastSharedVol(pvName)
 		if err != nil {
@@ -370,22 +566,53 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 			return nil, errors.New("Oss, Umount oss Fail: " + err.Error())
 		}
 		if code == "1" {
-			umntCmd = fmt.Sprintf("umount %s && umount -f %s", mountPoint, sharedMountPoint)
+			unlockShared, err := fuse.LockTarget(targetLockRoot, sharedMountPoint, connector.DefaultTimeout)
+			if err != nil {
+				return nil, status.Error(codes.Aborted, err.Error())
+			}
+			defer unlockShared()
+			umntCmds = []string{fmt.Sprintf("umount %s", mountPoint), fmt.Sprintf("umount -f %s", sharedMountPoint)}
+			cacheMountPoint = sharedMountPoint
 		} else {
-			umntCmd = fmt.Sprintf("umount %s", mountPoint)
+			umntCmds = []string{fmt.Sprintf("umount %s", mountPoint)}
+			cacheMountPoint = ""
 		}
 	} else {
-		umntCmd = fmt.Sprintf("umount -f %s", mountPoint)
+		umntCmds = []string{fmt.Sprintf("umount -f %s", mountPoint)}
 	}
-	if _, err := utils.ValidateRun(umntCmd); err != nil {
-		log.Errorf("Umount oss fail, with: %s", err.Error())
-		return nil, errors.New("Oss, Umount oss Fail: " + err.Error())
+	for _, umntCmd := range umntCmds {
+		if _, err := utils.ValidateRunContext(ctx, umntCmd); err != nil {
+			log.Errorf("Umount oss fail, with: %s", err.Error())
+			return nil, errors.New("Oss, Umount oss Fail: " + err.Error())
+		}
+	}
+	for _, path := range []string{mountPoint, cacheMountPoint} {
+		if err := unmountStacked(ctx, path); err != nil {
+			log.Errorf("Umount oss fail, with: %s", err.Error())
+			return nil, errors.New("Oss, Umount oss Fail: " + err.Error())
+		}
+	}
+	if cacheMountPoint != "" {
+		ns.cleanupMount(pvName, cacheMountPoint)
 	}
 
 	log.Infof("NodeUnpublishVolume:: Umount OSS Successful: %s", mountPoint)
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// unmountStacked unmount the disconnected mounts left under the mount of a restarted fuse process at path
+func unmountStacked(ctx context.Context, path string) error {
+	for i := 0; path != "" && IsFuseMounted(path); i++ {
+		if i == maxStackedMounts {
+			return fmt.Errorf("%s is still mounted after %d unmounts", path, maxStackedMounts)
+		}
+		if _, err := utils.ValidateRunContext(ctx, fmt.Sprintf("umount -l %s", path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (ns *nodeServer) NodeStageVolume(
 	ctx context.Context,
 	req *csi.NodeStageVolumeRequest) (
@@ -400,7 +627,27 @@ func (ns *nodeServer) NodeUnstageVolume(
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
+func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	resp, err := ns.DefaultNodeServer.NodeGetInfo(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if c := ns.getOssfsCapability(); c != nil {
+		resp.AccessibleTopology = &csi.Topology{
+			Segments: map[string]string{OssfsVersionTopologyKey: c.Version.String()},
+		}
+	}
+	return resp, nil
+}
+
 func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (
 	*csi.NodeExpandVolumeResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "")
 }
+
+// cleanupMount remove the cache and credential files of a fuse mount point after it is unmounted
+func (ns *nodeServer) cleanupMount(volumeID, mountPoint string) {
+	ns.cleanupCache(volumeID, mountPoint)
+	removeCredentialFiles(mountPoint)
+	forgetTemporaryMount(mountPoint)
+}
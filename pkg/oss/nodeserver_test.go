@@ -16,9 +16,16 @@ limitations under the License.
 package oss
 
 import (
+	"context"
 	"testing"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"wujunyi792/oss-csi-lite-plugin/pkg/auth"
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
 )
 
 func TestGetDiskVolumeOptions(t *testing.T) {
@@ -49,3 +56,159 @@ func TestGetDiskVolumeOptions(t *testing.T) {
 	assert.Equal(t, "Oss path error: start with "+options.Path+", should start with / ", err.Error())
 
 }
+
+func TestCheckJindoOptions(t *testing.T) {
+	cases := []struct {
+		otherOpts string
+		valid     bool
+	}{
+		{"", true},
+		{"-o allow_other -o fs.oss.download.thread.concurrency=16", true},
+		{"-o fs.oss.accessKeyId=ak", false},
+		{"-o fs.oss.provider.endpoint=ECS_ROLE", false},
+		{"-o uri=oss://other/", false},
+	}
+	for _, c := range cases {
+		t.Run(c.otherOpts, func(t *testing.T) {
+			opt := &Options{FuseType: JindoFsType, AkID: "ak", AkSecret: "sk", URL: "oss-cn-hangzhou.aliyuncs.com", Bucket: "aliyun", Path: "/", OtherOpts: c.otherOpts}
+			err := checkOssOptions(opt)
+			assert.Equal(t, c.valid, err == nil, err)
+		})
+	}
+}
+
+func TestCheckOssfsCredentialOptions(t *testing.T) {
+	opt := &Options{FuseType: OssFsType, AkID: "ak", AkSecret: "sk", URL: "oss-cn-hangzhou.aliyuncs.com", Bucket: "aliyun", Path: "/", OtherOpts: "-o allow_other"}
+	assert.Nil(t, checkOssOptions(opt))
+	opt.OtherOpts = "-o allow_other -o passwd_file=/etc/passwd-ossfs"
+	assert.NotNil(t, checkOssOptions(opt))
+}
+
+func TestCheckRAMRoleArnOptions(t *testing.T) {
+	opt := &Options{AuthType: RAMRoleArnAuthType, AkID: "ak", AkSecret: "sk", URL: "oss-cn-hangzhou.aliyuncs.com", Bucket: "aliyun", Path: "/"}
+	assert.NotNil(t, checkOssOptions(opt))
+	opt.RoleArn = "acs:ram::123:role/oss"
+	assert.Nil(t, checkOssOptions(opt))
+	assert.True(t, refreshesCredentialFile(opt))
+}
+
+func TestCheckRRSAOptions(t *testing.T) {
+	tokens := `{"sts.aliyuncs.com": {"token": "pod-token", "expirationTimestamp": "2022-01-01T00:00:00Z"}, "other": {"token": "x"}}`
+	assert.Equal(t, "pod-token", parseServiceAccountToken(tokens))
+	assert.Equal(t, "", parseServiceAccountToken("invalid"))
+
+	opt := &Options{AuthType: RRSAAuthType, URL: "oss-cn-hangzhou.aliyuncs.com", Bucket: "aliyun", Path: "/", RoleArn: "acs:ram::123:role/oss", OIDCProviderArn: "acs:ram::123:oidc-provider/ack-rrsa-c1"}
+	assert.NotNil(t, checkOssOptions(opt))
+	opt.serviceAccountToken = parseServiceAccountToken(tokens)
+	assert.NotNil(t, checkOssOptions(opt))
+	opt.serviceAccount = "/app"
+	assert.NotNil(t, checkOssOptions(opt))
+	opt.serviceAccount = "default/app"
+	assert.Nil(t, checkOssOptions(opt))
+	opt.UseSharedPath = true
+	assert.NotNil(t, checkOssOptions(opt))
+}
+
+// temporaryProvider supplies a temporary credential like the managed provider
+type temporaryProvider struct{}
+
+func (temporaryProvider) Name() string {
+	return auth.ProviderManaged
+}
+
+func (temporaryProvider) Provide(req *auth.VolumeRequest) (*auth.AccessKey, error) {
+	return &auth.AccessKey{ID: "sts-id", Secret: "sts-secret", SecurityToken: "token"}, nil
+}
+
+func TestLookupTemporaryCredential(t *testing.T) {
+	ns := &nodeServer{credentialProviders: auth.NewProviderChain(temporaryProvider{})}
+	req := &csi.NodePublishVolumeRequest{VolumeId: "pv-oss"}
+	opt := &Options{Bucket: "aliyun"}
+	assert.Nil(t, ns.lookupAccessKey(req, opt))
+	assert.Equal(t, "sts", opt.AuthType)
+	assert.Equal(t, "", opt.AkID)
+
+	opt = &Options{Bucket: "aliyun", AuthType: RAMRoleArnAuthType}
+	assert.NotNil(t, ns.lookupAccessKey(req, opt))
+}
+
+func TestFuseRestartPolicy(t *testing.T) {
+	p, err := fuseRestartPolicy(&Options{})
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+	p, err = fuseRestartPolicy(&Options{FuseMaxRestarts: "5"})
+	assert.Nil(t, err)
+	assert.Equal(t, &connector.RestartPolicy{Policy: connector.RestartOnFailure, MaxRestarts: 5}, p)
+	p, err = fuseRestartPolicy(&Options{FuseRestartPolicy: connector.RestartNever})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, p.Limit())
+	for _, opt := range []*Options{{FuseRestartPolicy: "always"}, {FuseMaxRestarts: "many"}, {FuseMaxRestarts: "-1"}} {
+		_, err = fuseRestartPolicy(opt)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestWatchersForgetFailedMount(t *testing.T) {
+	owner := &v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "p1"}
+	oomWatcher, exitWatcher := newFuseOOMWatcher(nil), newFuseExitWatcher(nil)
+	oomWatcher.track("unit", owner)
+	exitWatcher.track("unit", owner)
+	oomWatcher.forget("unit")
+	exitWatcher.forget("unit")
+	_, ok := oomWatcher.owners.Load("unit")
+	assert.False(t, ok)
+	_, ok = exitWatcher.owners.Load("unit")
+	assert.False(t, ok)
+}
+
+func TestNodeUnpublishVolume(t *testing.T) {
+	target := "/var/lib/kubelet/pods/p1/volumes/kubernetes.io~csi/pv-oss/mount"
+	mounts := "ossfs on " + target + " type fuse.ossfs (rw,nosuid,nodev)\n"
+	// the disconnected mount under the mount of a restarted fuse process is unmounted too
+	fake := executor.NewFake().On("sh -c "+NsenterCmd+" mount", "", 0).OnTimes("sh -c "+NsenterCmd+" mount", mounts, 0, 4)
+	previous := utils.SetExecutor(fake)
+	defer utils.SetExecutor(previous)
+
+	previousRoot := targetLockRoot
+	targetLockRoot = t.TempDir()
+	defer func() { targetLockRoot = previousRoot }()
+
+	ns := &nodeServer{}
+	req := &csi.NodeUnpublishVolumeRequest{VolumeId: "pv-oss", TargetPath: target}
+	_, err := ns.NodeUnpublishVolume(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Contains(t, fake.Commands(), "umount -f "+target)
+	assert.Contains(t, fake.Commands(), "umount -l "+target)
+
+	fake.On("sh -c "+NsenterCmd+" mount", mounts, 0).On("umount -f", "umount: target is busy", 32)
+	_, err = ns.NodeUnpublishVolume(context.Background(), req)
+	assert.NotNil(t, err)
+
+	// a target which is not mounted is not unmounted
+	fake = executor.NewFake()
+	utils.SetExecutor(fake)
+	_, err = ns.NodeUnpublishVolume(context.Background(), req)
+	assert.Nil(t, err)
+	assert.NotContains(t, fake.Commands(), "umount -f "+target)
+}
+
+func TestNodeUnpublishLastSharedVolume(t *testing.T) {
+	target := "/var/lib/kubelet/pods/p1/volumes/kubernetes.io~csi/pv-oss/mount"
+	shared := GetGlobalMountPath("pv-oss")
+	mounts := "ossfs on " + shared + " type fuse.ossfs (rw,nosuid,nodev)\n" +
+		"ossfs on " + target + " type fuse.ossfs (rw,nosuid,nodev)\n"
+	fake := executor.NewFake().On("sh -c "+NsenterCmd+" mount", "", 0).OnTimes("sh -c "+NsenterCmd+" mount", mounts, 0, 3)
+	previous := utils.SetExecutor(fake)
+	defer utils.SetExecutor(previous)
+
+	previousRoot := targetLockRoot
+	targetLockRoot = t.TempDir()
+	defer func() { targetLockRoot = previousRoot }()
+
+	ns := &nodeServer{}
+	req := &csi.NodeUnpublishVolumeRequest{VolumeId: "pv-oss", TargetPath: target}
+	_, err := ns.NodeUnpublishVolume(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Contains(t, fake.Commands(), "umount "+target)
+	assert.Contains(t, fake.Commands(), "umount -f "+shared)
+}
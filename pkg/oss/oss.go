@@ -21,15 +21,24 @@ import (
 	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	k8smount "k8s.io/utils/mount"
+	"os"
 	"sync"
+	"wujunyi792/oss-csi-lite-plugin/pkg/auth"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+	"wujunyi792/oss-csi-lite-plugin/pkg/metadata"
 	"wujunyi792/oss-csi-lite-plugin/pkg/options"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
 )
 
 const (
 	driverName = "ossplugin.csi.alibabacloud.com"
+	// kubeNodeNameEnv is the name of the node the plugin runs on
+	kubeNodeNameEnv = "KUBE_NODE_NAME"
 )
 
 var (
@@ -54,8 +63,14 @@ func NewDriver(nodeID, endpoint string) *OSS {
 	d := &OSS{}
 	d.endpoint = endpoint
 
+	nodeMetadata = newMetadataProvider()
 	if nodeID == "" {
-		nodeID = uuid.NewV4().String()
+		instanceID, err := nodeMetadata.InstanceID()
+		if err != nil {
+			log.Warnf("Instance id of the node is unknown, use a random node id, err: %v", err)
+			instanceID = uuid.NewV4().String()
+		}
+		nodeID = instanceID
 		log.Infof("Use node id : %s", nodeID)
 	}
 	csiDriver := csicommon.NewCSIDriver(driverName, version, nodeID)
@@ -70,6 +85,31 @@ func NewDriver(nodeID, endpoint string) *OSS {
 	return d
 }
 
+// newMetadataProvider create the providers of the node metadata configured by metadata.ProvidersEnv
+func newMetadataProvider() metadata.MetadataProvider {
+	clientSet := func() (kubernetes.Interface, error) {
+		cfg, err := clientcmd.BuildConfigFromFlags(options.MasterURL, options.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		return kubernetes.NewForConfig(cfg)
+	}
+	provider, err := metadata.ProvidersFromEnv(clientSet, os.Getenv(kubeNodeNameEnv))
+	if err != nil {
+		log.Fatalf("Create metadata providers is failed, err: %v", err)
+	}
+	region, err := provider.Region()
+	if err != nil {
+		log.Warnf("Region of the node is unknown, err: %v", err)
+	}
+	zone, err := provider.Zone()
+	if err != nil {
+		log.Warnf("Zone of the node is unknown, err: %v", err)
+	}
+	log.Infof("Metadata of the node from %s: region %q, zone %q", provider.Name(), region, zone)
+	return provider
+}
+
 // newNodeServer init oss type of csi nodeServer
 func newNodeServer(d *OSS) *nodeServer {
 	cfg, err := clientcmd.BuildConfigFromFlags(options.MasterURL, options.Kubeconfig)
@@ -80,12 +120,43 @@ func newNodeServer(d *OSS) *nodeServer {
 	if err != nil {
 		log.Fatalf("Create crd client is failed, err: %v", err)
 	}
-	return &nodeServer{
+	clientSet, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		log.Fatalf("Create client set is failed, err: %v", err)
+	}
+	if fuse.IsManagedInstall() {
+		installer := fuse.NewInstaller()
+		installer.Registry = fuseRegistry()
+		if err := installer.Install(); err != nil {
+			log.Fatalf("Install managed fuse clients is failed, err: %v", err)
+		}
+	}
+	credentialProviders, err := auth.ProvidersFromEnv(clientSet)
+	if err != nil {
+		log.Fatalf("Create credential providers is failed, err: %v", err)
+	}
+	log.Infof("AccessKeys of volumes are looked up from credential providers %v", credentialProviders.Names())
+	recorder := utils.NewEventRecorder()
+	ns := &nodeServer{
 		k8smounter:           k8smount.New(""),
 		DefaultNodeServer:    csicommon.NewDefaultNodeServer(d.driver),
 		writeCredentialMutex: sync.Mutex{},
 		dynamicClient:        crdClient,
+		clientSet:            clientSet,
+		oomWatcher:           newFuseOOMWatcher(recorder),
+		exitWatcher:          newFuseExitWatcher(recorder),
+		recorder:             recorder,
+		credentialProviders:  credentialProviders,
 	}
+	log.Infof("Fuse processes are started by the %s launcher", fuse.SelectLauncher(fuse.HostProcRoot))
+	go wait.Forever(ns.refreshOssfsCapability, fuseProbePeriod)
+	go wait.Forever(cacheJanitor, cacheJanitorPeriod)
+	go wait.Forever(ns.oomWatcher.check, fuseOOMCheckPeriod)
+	go wait.Forever(ns.exitWatcher.check, fuseExitCheckPeriod)
+	go wait.Forever(auth.DefaultCache().Renew, auth.RenewPeriod)
+	go wait.Forever(refreshTemporaryCredentials, credentialRefreshPeriod)
+	go wait.Forever(serveCredentials, credentialServerRestartPeriod)
+	return ns
 }
 
 // Run start a newNodeServer
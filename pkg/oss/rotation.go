@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oss
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
+)
+
+const (
+	// credentialRotated is the event reason of a mount switched to a rotated AccessKey
+	credentialRotated = "CredentialRotated"
+	// credentialRotationFailed is the event reason of a mount failed to switch to a rotated AccessKey
+	credentialRotationFailed = "CredentialRotationFailed"
+	// credentialRotationPending is the event reason of an ossfs mount keeping the previous AccessKey until it is mounted again
+	credentialRotationPending = "CredentialRotationPending"
+)
+
+// rotateCredential compare the AccessKey of a republished volume with the credential file of its fuse
+// mount point and rewrite the file. jindo-fuse reloads it. ossfs reads it only at start, the running
+// ossfs keeps the previous AccessKey until the pods are recreated: a remount would not reach the bind
+// mounts the containers already hold.
+func (ns *nodeServer) rotateCredential(req *csi.NodePublishVolumeRequest, opt *Options) error {
+	mountPoint, shared := req.GetTargetPath(), false
+	if sharedPath := GetGlobalMountPath(req.GetVolumeId()); opt.UseSharedPath && IsFuseMounted(sharedPath) {
+		mountPoint, shared = sharedPath, true
+	}
+	if opt.AuthType == "sts" || opt.AuthType == RAMRoleArnAuthType || opt.AuthType == RRSAAuthType {
+		// temporary credentials are renewed by the plugin itself, with the latest base AccessKey or token
+		if refreshesCredentialFile(opt) {
+			temporaryMounts.Store(mountPoint, opt)
+		}
+		return nil
+	}
+	path := credentialPath(mountPoint, ossfsCredentialSuffix)
+	content, err := ossfsCredential(opt)
+	if opt.FuseType == JindoFsType {
+		path = credentialPath(mountPoint, jsonCredentialSuffix)
+		content, err = jsonCredentialContent(opt)
+	}
+	if err != nil {
+		return errors.New("Rotate oss credential is failed, err: " + err.Error())
+	}
+	old, err := ioutil.ReadFile(filepath.Join(fuse.HostPrefix, path))
+	if err != nil {
+		// mounted before credential files were kept per mount point, nothing to compare with
+		log.Infof("RotateCredential: skip %s, read credential file is failed, err: %v", mountPoint, err)
+		return nil
+	}
+	if bytes.Equal(old, content) {
+		return nil
+	}
+
+	owner := scopeOwner(req.GetVolumeId(), req.VolumeContext, shared)
+	log.Infof("RotateCredential: AccessKey of volume %s is changed, update mount point %s", req.GetVolumeId(), mountPoint)
+	if err := writeCredentialFile(path, content); err != nil {
+		ns.recordEvent(owner, v1.EventTypeWarning, credentialRotationFailed, fmt.Sprintf("Volume %s: update credential file is failed, err: %v", req.GetVolumeId(), err))
+		return errors.New("Rotate oss credential is failed, err: " + err.Error())
+	}
+	if opt.FuseType == JindoFsType {
+		ns.recordEvent(owner, v1.EventTypeNormal, credentialRotated, fmt.Sprintf("Volume %s: jindo-fuse reloads the rotated AccessKey %s", req.GetVolumeId(), opt.AkID))
+		return nil
+	}
+	// a restarted ossfs reads the rewritten file
+	ns.recordEvent(owner, v1.EventTypeWarning, credentialRotationPending, fmt.Sprintf("Volume %s: ossfs of %s keeps the previous AccessKey, recreate the pods using the volume on this node to use the rotated AccessKey %s",
+		req.GetVolumeId(), mountPoint, opt.AkID))
+	return nil
+}
+
+// recordEvent report an event of a volume on its owner
+func (ns *nodeServer) recordEvent(owner *v1.ObjectReference, eventType, reason, message string) {
+	if eventType == v1.EventTypeWarning {
+		log.Warnf("%s: %s", reason, message)
+	}
+	if ns.recorder != nil {
+		utils.CreateEvent(ns.recorder, owner, eventType, reason, message)
+	}
+}
@@ -19,49 +19,15 @@ package oss
 import (
 	"crypto/sha256"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"path/filepath"
-	"time"
+	"strconv"
+	"strings"
+	"wujunyi792/oss-csi-lite-plugin/pkg/metadata"
 	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
 )
 
-const (
-	// MetadataURL is metadata url
-	MetadataURL = "http://100.100.100.200/latest/meta-data/"
-	// InstanceID is instance ID
-	InstanceID = "instance-id"
-	// RAMRoleResource is ram-role url subpath
-	RAMRoleResource = "ram/security-credentials/"
-)
-
-// GetMetaData get host regionid, zoneid
-func GetMetaData(resource string) string {
-	resp, err := http.Get(MetadataURL + resource)
-	if err != nil {
-		return ""
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return ""
-	}
-	return string(body)
-}
-
-func GetMetaDataAsync(resource string) string {
-	c1 := make(chan string, 1)
-	go func(r string) {
-		ans := GetMetaData(r)
-		c1 <- ans
-	}(resource)
-	select {
-	case res := <-c1:
-		return res
-	case <-time.After(2 * time.Second):
-		return ""
-	}
-}
+// nodeMetadata is the metadata of the node, configured by metadata.ProvidersEnv
+var nodeMetadata = metadata.NewAliyunProvider(metadata.AliyunURL)
 
 func GetGlobalMountPath(volumeId string) string {
 
@@ -78,12 +44,19 @@ func GetGlobalMountPath(volumeId string) string {
 	}
 }
 
-// GetRAMRoleOption get command line's ram_role option
-func GetRAMRoleOption() string {
-	ramRole := GetMetaData(RAMRoleResource)
-	ramRoleOpt := MetadataURL + RAMRoleResource + ramRole
-	mntCmdRamRole := fmt.Sprintf("-oram_role=%s", ramRoleOpt)
-	return mntCmdRamRole
+// mountPointKey return a short stable name for the files kept per mount point
+func mountPointKey(mountPoint string) string {
+	result := sha256.Sum256([]byte(filepath.Clean(mountPoint)))
+	return fmt.Sprintf("%x", result)[:16]
+}
+
+// GetRAMRoleURL get the ram_role url of ossfs, ossfs reads the credential of the ram role of the node
+func GetRAMRoleURL() (string, error) {
+	url, err := nodeMetadata.RAMRoleCredentialURL()
+	if err != nil {
+		return "", fmt.Errorf("the ram role of the node is unknown: %v", err)
+	}
+	return url, nil
 }
 
 // IsOssfsMounted return if oss mountPath is mounted
@@ -99,13 +72,60 @@ func IsOssfsMounted(mountPath string) bool {
 	return true
 }
 
+// IsFuseMounted return if mountPath is mounted by ossfs or jindo-fuse
+func IsFuseMounted(mountPath string) bool {
+	checkMountCountCmd := fmt.Sprintf("%s mount", NsenterCmd)
+	out, err := utils.RunWithFilter(checkMountCountCmd, mountPath)
+	if err != nil {
+		return false
+	}
+	return len(filterFuseMounts(out)) != 0
+}
+
+// filterFuseMounts return the mount entries of ossfs and jindo-fuse
+func filterFuseMounts(mounts []string) []string {
+	var out []string
+	for _, line := range mounts {
+		if strings.Contains(line, "fuse.ossfs") || strings.Contains(line, JindoFuseMountType) {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
 // IsLastSharedVol return code status to help check if this oss volume uses UseSharedPath and is the last one
 func IsLastSharedVol(pvName string) (string, error) {
 	keyStr := fmt.Sprintf("volumes/kubernetes.io~csi/%s/mount", pvName)
 	checkMountCountCmd := fmt.Sprintf("%s mount", NsenterCmd)
-	out, err := utils.RunWithFilter(checkMountCountCmd, keyStr, "fuse.ossfs")
+	out, err := utils.RunWithFilter(checkMountCountCmd, keyStr)
 	if err != nil {
 		return "0", err
 	}
-	return string(rune(len(out))), nil
+	return strconv.Itoa(len(mountTargets(filterFuseMounts(out)))), nil
+}
+
+// targetPodUID return the uid of the pod of a volume target under the kubelet pods directory,
+// empty for other paths such as the shared mount point
+func targetPodUID(target string) string {
+	rel, err := filepath.Rel(filepath.Join(utils.KubeletRootDir, "pods"), target)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return strings.SplitN(rel, string(filepath.Separator), 2)[0]
+}
+
+// mountTargets return the distinct mount points of mount entries, a target where the connector
+// restarted the fuse process holds the disconnected mount under the new one
+func mountTargets(mounts []string) []string {
+	var targets []string
+	seen := map[string]bool{}
+	for _, line := range mounts {
+		// ossfs on /var/lib/kubelet/pods/<uid>/volumes/kubernetes.io~csi/<pv>/mount type fuse.ossfs (rw)
+		fields := strings.Fields(line)
+		if len(fields) > 2 && fields[1] == "on" && !seen[fields[2]] {
+			seen[fields[2]] = true
+			targets = append(targets, fields[2])
+		}
+	}
+	return targets
 }
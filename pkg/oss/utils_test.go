@@ -20,12 +20,28 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
+	"wujunyi792/oss-csi-lite-plugin/pkg/metadata"
+	"wujunyi792/oss-csi-lite-plugin/pkg/utils"
 )
 
-func TestGetRAMRoleOption(t *testing.T) {
+func TestGetRAMRoleURL(t *testing.T) {
+	defer func(provider metadata.MetadataProvider) { nodeMetadata = provider }(nodeMetadata)
 
-	result := GetRAMRoleOption()
-	assert.NotEqual(t, "", result)
+	nodeMetadata = metadata.NewStaticProvider(metadata.StaticMetadata{RAMRole: "KubernetesWorkerRole"})
+	result, err := GetRAMRoleURL()
+	assert.Nil(t, err)
+	assert.Equal(t, "http://100.100.100.200/latest/meta-data/ram/security-credentials/KubernetesWorkerRole", result)
+
+	// the credential url of the static provider is passed to ossfs
+	nodeMetadata = metadata.NewStaticProvider(metadata.StaticMetadata{RAMRole: "KubernetesWorkerRole", RAMRoleCredentialURL: "http://127.0.0.1:8181/role"})
+	result, err = GetRAMRoleURL()
+	assert.Nil(t, err)
+	assert.Equal(t, "http://127.0.0.1:8181/role", result)
+
+	nodeMetadata = metadata.NewStaticProvider(metadata.StaticMetadata{})
+	_, err = GetRAMRoleURL()
+	assert.NotNil(t, err)
 }
 
 func TestIsOssfsMounted(t *testing.T) {
@@ -35,8 +51,45 @@ func TestIsOssfsMounted(t *testing.T) {
 }
 
 func TestIsLastSharedVol(t *testing.T) {
+	mounts := "ossfs on /var/lib/kubelet/pods/p1/volumes/kubernetes.io~csi/pv-oss/mount type fuse.ossfs (rw)\n" +
+		"ossfs on /var/lib/kubelet/pods/p2/volumes/kubernetes.io~csi/pv-oss/mount type fuse.ossfs (rw)\n" +
+		"ossfs on /var/lib/kubelet/pods/p3/volumes/kubernetes.io~csi/pv-other/mount type fuse.ossfs (rw)\n"
+	previous := utils.SetExecutor(executor.NewFake().On("sh -c "+NsenterCmd+" mount", mounts, 0))
+	defer utils.SetExecutor(previous)
+
+	result, err := IsLastSharedVol("pv-other")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", result)
+	result, err = IsLastSharedVol("pv-oss")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", result)
+
+	utils.SetExecutor(executor.NewFake().On("sh -c", "", 1))
+	result, err = IsLastSharedVol("pv-oss")
+	assert.NotNil(t, err)
+	assert.Equal(t, "0", result)
+}
+
+func TestFilterFuseMounts(t *testing.T) {
+	mounts := []string{
+		"ossfs on /var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/pv/mount type fuse.ossfs (rw,nosuid,nodev)",
+		"jindo-fuse on /var/lib/kubelet/pods/b/volumes/kubernetes.io~csi/pv/mount type fuse.jindo-fuse (rw,nosuid,nodev)",
+		"tmpfs on /var/lib/kubelet/pods/c/volumes/kubernetes.io~csi/pv/mount type tmpfs (rw)",
+	}
+	assert.Equal(t, mounts[:2], filterFuseMounts(mounts))
+}
+
+func TestMountTargets(t *testing.T) {
+	mounts := []string{
+		"ossfs on /var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/pv/mount type fuse.ossfs (rw,nosuid,nodev)",
+		"ossfs on /var/lib/kubelet/pods/b/volumes/kubernetes.io~csi/pv/mount type fuse.ossfs (rw,nosuid,nodev)",
+		"ossfs on /var/lib/kubelet/pods/b/volumes/kubernetes.io~csi/pv/mount type fuse.ossfs (rw,nosuid,nodev)",
+		"",
+	}
+	assert.Equal(t, []string{"/var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/pv/mount", "/var/lib/kubelet/pods/b/volumes/kubernetes.io~csi/pv/mount"}, mountTargets(mounts))
+}
 
-	lastSharedVol := ""
-	result, _ := IsLastSharedVol(lastSharedVol)
-	assert.NotEqual(t, "", result)
+func TestTargetPodUID(t *testing.T) {
+	assert.Equal(t, "p1", targetPodUID("/var/lib/kubelet/pods/p1/volumes/kubernetes.io~csi/pv/mount"))
+	assert.Equal(t, "", targetPodUID("/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv/globalmount"))
 }
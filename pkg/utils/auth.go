@@ -196,6 +196,11 @@ func GetEnvAK() AccessControl {
 	return AccessControl{AccessKeyID: strings.TrimSpace(accessKeyID), AccessKeySecret: strings.TrimSpace(accessSecret), UseMode: AccessKey}
 }
 
+// GetManagedToken return the temporary credential of the addon token secret, empty if it is not deployed
+func GetManagedToken() ManageTokens {
+	return getManagedToken()
+}
+
 // GetManagedToken get ak from csi secret
 func getManagedToken() (tokens ManageTokens) {
 	var akInfo AKInfo
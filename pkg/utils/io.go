@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	log "github.com/sirupsen/logrus"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -112,7 +111,7 @@ func writeIoLimit(majMinNum, podBlkIOPath, ioFile string, ioLimit int) error {
 	targetPath := filepath.Join(podBlkIOPath, ioFile)
 	content := majMinNum + " " + strconv.Itoa(ioLimit)
 	cmd := fmt.Sprintf("%s sh -c 'echo %s > %s'", NsenterCmd, content, targetPath)
-	_, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+	_, err := Run(cmd)
 	if err != nil {
 		log.Errorf("WriteBps: Write file command(%s) error %v", cmd, err)
 		return err
@@ -148,7 +147,7 @@ func getBpsLimt(bpsLimt string) (int, error) {
 
 func getMajMinDevice(devicePath string) string {
 	cmd := fmt.Sprintf("%s lsblk %s --noheadings --output MAJ:MIN", NsenterCmd, devicePath)
-	out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+	out, err := Run(cmd)
 	if err != nil {
 		log.Errorf("getMajMinDevice with error: %s", err.Error())
 		return ""
@@ -17,6 +17,7 @@ limitations under the License.
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -25,9 +26,12 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	utilio "k8s.io/utils/io"
+
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
 )
 
 const (
@@ -37,6 +41,8 @@ const (
 	procMountsPath = "/proc/mounts"
 	// Number of fields per line in /proc/mounts as per the fstab man page.
 	expectedNumFieldsPerLine = 6
+	// formatTimeout bounds mkfs, which takes longer than other commands on large disks
+	formatTimeout = 30 * time.Minute
 )
 
 type findmntResponse struct {
@@ -116,13 +122,21 @@ func (m *mounter) EnsureFolder(target string) error {
 
 	mkdirArgs := []string{"-p", target}
 	//log.Infof("mkdir for folder, the command is %s %v", mdkirCmd, mkdirArgs)
-	_, err = exec.Command(mdkirCmd, mkdirArgs...).CombinedOutput()
+	_, err = combinedOutput(0, mdkirCmd, mkdirArgs...)
 	if err != nil {
 		return fmt.Errorf("mkdir for folder error: %v", err)
 	}
 	return nil
 }
 
+// combinedOutput run name with args within timeout, 0 for executor.DefaultTimeout, and return stdout and stderr
+func combinedOutput(timeout time.Duration, name string, args ...string) (string, error) {
+	command := executor.NewCommand(append([]string{name}, args...)...)
+	command.Timeout, command.Combined = timeout, true
+	result, err := runCommand(context.Background(), command)
+	return result.Stdout, err
+}
+
 func (m *mounter) EnsureBlock(target string) error {
 	fi, err := os.Lstat(target)
 	if err != nil && !os.IsNotExist(err) {
@@ -167,10 +181,10 @@ func (m *mounter) Format(source, fsType string) error {
 	}
 
 	log.Infof("Format %s with fsType %s, the command is %s %v", source, fsType, mkfsCmd, mkfsArgs)
-	out, err := exec.Command(mkfsCmd, mkfsArgs...).CombinedOutput()
+	out, err := combinedOutput(formatTimeout, mkfsCmd, mkfsArgs...)
 	if err != nil {
 		return fmt.Errorf("formatting disk failed: %v cmd: '%s %s' output: %q",
-			err, mkfsCmd, strings.Join(mkfsArgs, " "), string(out))
+			err, mkfsCmd, strings.Join(mkfsArgs, " "), out)
 	}
 
 	return nil
@@ -199,10 +213,10 @@ func (m *mounter) MountBlock(source, target string, opts ...string) error {
 	}
 
 	log.Infof("Mount %s to %s, the command is %s %v", source, target, mountCmd, mountArgs)
-	out, err := exec.Command(mountCmd, mountArgs...).CombinedOutput()
+	out, err := combinedOutput(0, mountCmd, mountArgs...)
 	if err != nil {
 		return fmt.Errorf("mounting failed: %v cmd: '%s %s' output: %q",
-			err, mountCmd, strings.Join(mountArgs, " "), string(out))
+			err, mountCmd, strings.Join(mountArgs, " "), out)
 	}
 	return nil
 }
@@ -240,10 +254,10 @@ func (m *mounter) Mount(source, target, fsType string, opts ...string) error {
 
 	log.Infof("Mount %s to %s with fsType %s, the command is %s %v", source, target, fsType, mountCmd, mountArgs)
 
-	out, err := exec.Command(mountCmd, mountArgs...).CombinedOutput()
+	out, err := combinedOutput(0, mountCmd, mountArgs...)
 	if err != nil {
 		return fmt.Errorf("mounting failed: %v cmd: '%s %s' output: %q",
-			err, mountCmd, strings.Join(mountArgs, " "), string(out))
+			err, mountCmd, strings.Join(mountArgs, " "), out)
 	}
 
 	return nil
@@ -259,10 +273,10 @@ func (m *mounter) Unmount(target string) error {
 
 	log.Infof("Unmount %s, the command is %s %v", target, umountCmd, umountArgs)
 
-	out, err := exec.Command(umountCmd, umountArgs...).CombinedOutput()
+	out, err := combinedOutput(0, umountCmd, umountArgs...)
 	if err != nil {
 		return fmt.Errorf("unmounting failed: %v cmd: '%s %s' output: %q",
-			err, umountCmd, target, string(out))
+			err, umountCmd, target, out)
 	}
 
 	return nil
@@ -284,13 +298,13 @@ func (m *mounter) IsFormatted(source string) (bool, error) {
 
 	args := []string{"-sL", source}
 
-	out, err := exec.Command(fileCmd, args...).CombinedOutput()
+	out, err := combinedOutput(0, fileCmd, args...)
 	if err != nil {
 		return false, fmt.Errorf("checking formatting failed: %v cmd: %q output: %q",
-			err, fileCmd, string(out))
+			err, fileCmd, out)
 	}
 
-	output := strings.TrimPrefix(string(out), fmt.Sprintf("%s:", source))
+	output := strings.TrimPrefix(out, fmt.Sprintf("%s:", source))
 	if strings.TrimSpace(output) == "data" {
 		return false, nil
 	}
@@ -304,8 +318,8 @@ func (m *mounter) IsMounted(target string) (bool, error) {
 	}
 	findmntCmd := "grep"
 	findmntArgs := []string{target, "/proc/mounts"}
-	out, err := exec.Command(findmntCmd, findmntArgs...).CombinedOutput()
-	outStr := strings.TrimSpace(string(out))
+	out, err := combinedOutput(0, findmntCmd, findmntArgs...)
+	outStr := strings.TrimSpace(out)
 	if err != nil {
 		if outStr == "" {
 			return false, nil
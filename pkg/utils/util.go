@@ -17,17 +17,14 @@ limitations under the License.
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
-	utilexec "k8s.io/utils/exec"
-	"k8s.io/utils/mount"
-	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -49,6 +46,11 @@ import (
 	k8svol "k8s.io/kubernetes/pkg/volume"
 	k8sfs "k8s.io/kubernetes/pkg/volume/util/fs"
 
+	"wujunyi792/oss-csi-lite-plugin/pkg/audit"
+	"wujunyi792/oss-csi-lite-plugin/pkg/connector"
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
+	"wujunyi792/oss-csi-lite-plugin/pkg/fuse"
+	csilog "wujunyi792/oss-csi-lite-plugin/pkg/log"
 	"wujunyi792/oss-csi-lite-plugin/pkg/options"
 )
 
@@ -67,12 +69,6 @@ const (
 	UserAKID = "/etc/.volumeak/akId"
 	// UserAKSecret is user AK Secret
 	UserAKSecret = "/etc/.volumeak/akSecret"
-	// MetadataURL is metadata url
-	MetadataURL = "http://100.100.100.200/latest/meta-data/"
-	// RegionIDTag is region id
-	RegionIDTag = "region-id"
-	// InstanceIDTag is instance id
-	InstanceIDTag = "instance-id"
 	// DefaultRegion is default region
 	DefaultRegion = "cn-hangzhou"
 	// CsiPluginRunTimeFlagFile tag
@@ -89,8 +85,6 @@ const (
 	ProvisionerService = "provisioner"
 	// InstallSnapshotCRD tag
 	InstallSnapshotCRD = "INSTALL_SNAPSHOT_CRD"
-	// MetadataMaxRetrycount ...
-	MetadataMaxRetrycount = 4
 	// VolDataFileName file
 	VolDataFileName = "vol_data.json"
 	// fsckErrorsCorrected tag
@@ -162,53 +156,98 @@ type Result struct {
 // CommandRunFunc define the run function in utils for ut
 type CommandRunFunc func(cmd string) (string, error)
 
-// Run command
+// commandExecutor run the commands of the plugin, a fake replaces it in tests
+var commandExecutor executor.Executor = executor.New()
+
+// SetExecutor replace the executor of the commands of the plugin and return the previous one, for tests
+func SetExecutor(e executor.Executor) executor.Executor {
+	previous := commandExecutor
+	commandExecutor = e
+	return previous
+}
+
+// ValidateRun run cmd after checking the command and its arguments, cmd is split on spaces and run without a shell
 func ValidateRun(cmd string) (string, error) {
-	arr := strings.Split(cmd, " ")
-	withArgs := false
-	if len(arr) >= 2 {
-		withArgs = true
-	}
+	return ValidateRunContext(context.Background(), cmd)
+}
 
+// ValidateRunContext is ValidateRun until ctx is done, or executor.DefaultTimeout without a deadline
+func ValidateRunContext(ctx context.Context, cmd string) (string, error) {
+	arr := strings.Split(cmd, " ")
 	name := arr[0]
-	var args []string
+	args := arr[1:]
 	err := CheckCmd(cmd, name)
 	if err != nil {
 		return "", err
 	}
-	if withArgs {
-		args = arr[1:]
+	if len(args) > 0 {
 		err = CheckCmdArgs(cmd, args...)
 		if err != nil {
 			return "", err
 		}
 	}
-	safeMount := mount.SafeFormatAndMount{
-		Interface: mount.New(""),
-		Exec:      utilexec.New(),
-	}
-	var command utilexec.Cmd
-	if withArgs {
-		command = safeMount.Exec.Command(name, args...)
-	} else {
-		command = safeMount.Exec.Command(name)
-	}
 
-	stdout, err := command.CombinedOutput()
+	command := executor.NewCommand(arr...)
+	command.Combined = true
+	result, err := runCommand(ctx, command)
 	if err != nil {
-		return "", fmt.Errorf("Failed to exec command:%s, name:%s, args:%+v, stdout:%s, stderr:%s", cmd, name, args, string(stdout), err.Error())
+		return "", errors.New(csilog.Redact(fmt.Sprintf("Failed to exec command:%s, name:%s, args:%+v, stdout:%s, stderr:%s", cmd, name, args, result.Stdout, err.Error())))
 	}
 	log.Infof("Exec command %s is successfully, name:%s, args:%+v", cmd, name, args)
-	return string(stdout), nil
+	return result.Stdout, nil
 }
 
-// run shell command
+// auditVolumeKey is the context key of the volume and the pod the commands run with the context are for
+type auditVolumeKey struct{}
+
+type auditVolume struct {
+	volumeID, pod, podUID string
+}
+
+// WithAuditVolume return ctx whose commands are audited as run for the volume and the pod, identified
+// by its namespace/name, or by its uid when only the target of the volume is known
+func WithAuditVolume(ctx context.Context, volumeID, pod, podUID string) context.Context {
+	return context.WithValue(ctx, auditVolumeKey{}, &auditVolume{volumeID: volumeID, pod: pod, podUID: podUID})
+}
+
+// runCommand run command with the executor of the plugin and audit it
+func runCommand(ctx context.Context, command *executor.Command) (*executor.Result, error) {
+	start := time.Now()
+	result, err := commandExecutor.Run(ctx, command)
+	auditCommand(ctx, start, result, err)
+	return result, err
+}
+
+// auditCommand record a command run by the plugin in its audit log
+func auditCommand(ctx context.Context, start time.Time, result *executor.Result, err error) {
+	logger := audit.Default()
+	if logger == nil {
+		return
+	}
+	r := &audit.Record{Source: audit.SourcePlugin, Action: audit.ActionCommand}
+	if v, ok := ctx.Value(auditVolumeKey{}).(*auditVolume); ok {
+		r.VolumeID, r.Pod, r.PodUID = v.volumeID, v.pod, v.podUID
+	}
+	r.SetArgv(result.Argv...)
+	r.SetOutput(result.Stdout, result.Stderr)
+	r.Finish(start, result.ExitCode, err)
+	logger.Log(r)
+}
+
+// Run run the shell command cmd
 func Run(cmd string) (string, error) {
-	out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+	return RunContext(context.Background(), cmd)
+}
+
+// RunContext is Run until ctx is done, or executor.DefaultTimeout without a deadline
+func RunContext(ctx context.Context, cmd string) (string, error) {
+	command := executor.Shell(cmd)
+	command.Combined = true
+	result, err := runCommand(ctx, command)
 	if err != nil {
-		return "", fmt.Errorf("Failed to run cmd: " + cmd + ", with out: " + string(out) + ", with error: " + err.Error())
+		return "", fmt.Errorf("Failed to run cmd: " + cmd + ", with out: " + result.Stdout + ", with error: " + err.Error())
 	}
-	return string(out), nil
+	return result.Stdout, nil
 }
 
 func RunWithFilter(cmd string, filter ...string) ([]string, error) {
@@ -406,40 +445,61 @@ func IsHostFileExist(path string) bool {
 	return true
 }
 
-func DoMountInHost(mntCmd string) error {
-	out, err := ConnectorRun(mntCmd)
-	if err != nil {
-		msg := fmt.Sprintf("Mount is failed in host, mntCmd:%s, err: %s, out: %s", mntCmd, err.Error(), out)
+// DoMountInHost run the fuse mount m with host connector, which builds and runs its command without a shell
+func DoMountInHost(m *connector.MountRequest) error {
+	if _, err := hostConnector.Mount(m, 0); err != nil {
+		msg := csilog.Redact(fmt.Sprintf("Mount is failed in host, mntCmd:%s, err: %s", m.CommandLine(), err.Error()))
 		log.Errorf(msg)
 		return errors.New(msg)
 	}
 	return nil
 }
 
-// ConnectorRun Run shell command with host connector
-// host connector is daemon running in host.
-func ConnectorRun(cmd string) (string, error) {
-	c, err := net.Dial("unix", socketPath)
+// connectorStatusTimeout bounds the status request of a metrics scrape
+const connectorStatusTimeout = 5 * time.Second
+
+// hostConnector runs the requests on the host, with the host connector or by entering the host namespaces
+// as configured by connector.ExecModeEnv. The connector client falls back to the legacy protocol of older connectors.
+var hostConnector = newHostConnector()
+
+func newHostConnector() *connector.Selector {
+	mode, err := connector.ExecModeFromEnv()
 	if err != nil {
-		log.Errorf("Oss connector Dial error: %s", err.Error())
-		return err.Error(), err
+		log.Errorf("%v, use %s", err, connector.ExecModeAuto)
+		mode = connector.ExecModeAuto
 	}
-	defer c.Close()
+	return connector.NewSelector(mode, connector.NewClient(socketPath), connector.NewNsenter(loadFuseRegistry))
+}
 
-	_, err = c.Write([]byte(cmd))
+// loadFuseRegistry read the fuse client registry of the plugin, validating mounts run with nsenter
+func loadFuseRegistry() *fuse.Registry {
+	registry, err := fuse.LoadRegistry(fuse.RegistryPath())
 	if err != nil {
-		log.Errorf("Oss connector write error: %s", err.Error())
-		return err.Error(), err
+		log.Errorf("Load fuse registry is failed, only default binaries are allowed, err: %v", err)
+		return &fuse.Registry{}
 	}
+	return registry
+}
+
+// HostExecState return how the requests on the host are run
+func HostExecState() connector.ExecState {
+	return hostConnector.State()
+}
 
-	buf := make([]byte, 2048)
-	n, err := c.Read(buf[:])
-	response := string(buf[0:n])
-	if strings.HasPrefix(response, "Success") {
-		respstr := response[8:]
-		return respstr, nil
+// ConnectorRun Run shell command with host connector
+// host connector is daemon running in host.
+func ConnectorRun(cmd string) (string, error) {
+	resp, err := hostConnector.Run(cmd, 0)
+	if err != nil {
+		log.Errorf("Oss connector run error: %s", csilog.Redact(err.Error()))
+		return err.Error(), err
 	}
-	return response, errors.New("Exec command error:" + response)
+	return resp.Stdout, nil
+}
+
+// ConnectorStatus return the status of host connector
+func ConnectorStatus() (*connector.Status, error) {
+	return hostConnector.Status(connectorStatusTimeout)
 }
 
 func MkdirAll(path string, mode fs.FileMode) error {
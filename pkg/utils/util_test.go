@@ -17,8 +17,14 @@ limitations under the License.
 package utils
 
 import (
-	"github.com/stretchr/testify/assert"
+	"bytes"
+	"context"
+	"encoding/json"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"wujunyi792/oss-csi-lite-plugin/pkg/audit"
+	"wujunyi792/oss-csi-lite-plugin/pkg/executor"
 )
 
 //go test ./*.go -v util_test.go
@@ -154,3 +160,18 @@ func TestParseProviderID(t *testing.T) {
 		})
 	}
 }
+
+func TestAuditVolume(t *testing.T) {
+	var buf bytes.Buffer
+	defer audit.SetDefault(audit.SetDefault(audit.NewWriterLogger(&buf)))
+	defer SetExecutor(SetExecutor(executor.NewFake()))
+
+	ctx := WithAuditVolume(context.Background(), "pv-oss", "", "p1")
+	_, err := ValidateRunContext(ctx, "umount /var/lib/kubelet/pods/p1/volumes/kubernetes.io~csi/pv-oss/mount")
+	assert.Nil(t, err)
+	r := &audit.Record{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), r))
+	assert.Equal(t, "pv-oss", r.VolumeID)
+	assert.Equal(t, "p1", r.PodUID)
+	assert.Equal(t, "umount", r.Argv[0])
+}